@@ -0,0 +1,223 @@
+package lfs
+
+import (
+	"container/list"
+)
+
+// CacheConfig configures a CachedBlockDevice
+type CacheConfig struct {
+	// PageSize is the unit in which data is read from the device and kept in
+	// the cache; it must divide the block size. Zero means the block size.
+	PageSize uint32
+
+	// Pages is the number of pages kept in the cache, which are evicted in
+	// least recently used order
+	Pages int
+
+	// ReadAhead is the number of pages following the one that missed which
+	// are read along with it, as long as they are in the same block
+	ReadAhead int
+}
+
+// CachedBlockDevice wraps another block device with a RAM cache, so that the
+// many small reads made by littlefs are served from memory instead of
+// crossing to the device. Sequential programs are coalesced into a single
+// program of the underlying device, which is deferred until the next Sync,
+// erase, or program which does not follow on from them; littlefs syncs after
+// every metadata commit, so nothing that has been committed is ever lost.
+//
+// Since programs are deferred, an error from the underlying device may be
+// reported by the Sync or later operation that flushed it rather than by the
+// program itself. Reads that littlefs makes to validate programmed data are
+// served from the cache too, so a device that fails silently when
+// programming will not be detected.
+type CachedBlockDevice struct {
+	dev       BlockDevice
+	blockSize uint32
+	config    CacheConfig
+
+	// pages holds the cached pages, most recently used first; index maps the
+	// block and page number to their element of pages
+	pages *list.List
+	index map[cacheKey]*list.Element
+
+	// pending holds the coalesced programs not yet passed on to the device
+	pending pendingProgram
+}
+
+type cacheKey struct {
+	block uint32
+	page  uint32
+}
+
+type cachePage struct {
+	key  cacheKey
+	data []byte
+}
+
+type pendingProgram struct {
+	block  uint32
+	offset uint32
+	data   []byte
+}
+
+func NewCachedDevice(dev BlockDevice, config Config, cache CacheConfig) *CachedBlockDevice {
+	if cache.PageSize == 0 {
+		cache.PageSize = config.BlockSize
+	}
+	if cache.Pages < 1 {
+		cache.Pages = 1
+	}
+	return &CachedBlockDevice{
+		dev:       dev,
+		blockSize: config.BlockSize,
+		config:    cache,
+		pages:     list.New(),
+		index:     map[cacheKey]*list.Element{},
+		pending:   pendingProgram{data: make([]byte, 0, config.BlockSize)},
+	}
+}
+
+func (bd *CachedBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	pageSize := bd.config.PageSize
+	for len(buf) > 0 {
+		key := cacheKey{block: block, page: offset / pageSize}
+		elem, ok := bd.index[key]
+		if ok {
+			bd.pages.MoveToFront(elem)
+		} else {
+			var err error
+			if elem, err = bd.fill(key); err != nil {
+				return err
+			}
+		}
+		page := elem.Value.(*cachePage)
+		n := copy(buf, page.data[offset%pageSize:])
+		buf = buf[n:]
+		offset += uint32(n)
+	}
+	return nil
+}
+
+// fill reads the page for key from the device, along with the pages to be
+// read ahead, and returns the element for key
+func (bd *CachedBlockDevice) fill(key cacheKey) (*list.Element, error) {
+	pageSize := bd.config.PageSize
+	count := uint32(1 + bd.config.ReadAhead)
+	if max := uint32(bd.config.Pages); count > max {
+		count = max
+	}
+	if last := bd.blockSize / pageSize; key.page+count > last {
+		count = last - key.page
+	}
+	// the device does not have the data of pending programs yet
+	if bd.pending.overlaps(key.block, key.page*pageSize, count*pageSize) {
+		if err := bd.flush(); err != nil {
+			return nil, err
+		}
+	}
+	buf := make([]byte, count*pageSize)
+	if err := bd.dev.ReadBlock(key.block, key.page*pageSize, buf); err != nil {
+		return nil, err
+	}
+	// insert the read ahead pages in reverse so that the one that was asked
+	// for ends up most recently used
+	var elem *list.Element
+	for i := int(count) - 1; i >= 0; i-- {
+		k := cacheKey{block: key.block, page: key.page + uint32(i)}
+		data := buf[uint32(i)*pageSize : uint32(i+1)*pageSize]
+		if e, ok := bd.index[k]; ok {
+			// already cached, and possibly more up to date than the device
+			bd.pages.MoveToFront(e)
+			elem = e
+			continue
+		}
+		elem = bd.pages.PushFront(&cachePage{key: k, data: data})
+		bd.index[k] = elem
+	}
+	for bd.pages.Len() > bd.config.Pages {
+		last := bd.pages.Back()
+		delete(bd.index, last.Value.(*cachePage).key)
+		bd.pages.Remove(last)
+	}
+	return elem, nil
+}
+
+func (bd *CachedBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	p := &bd.pending
+	if len(p.data) > 0 && (p.block != block || p.offset+uint32(len(p.data)) != offset ||
+		len(p.data)+len(buf) > cap(p.data)) {
+		if err := bd.flush(); err != nil {
+			return err
+		}
+	}
+	if len(p.data) == 0 {
+		p.block, p.offset = block, offset
+	}
+	p.data = append(p.data, buf...)
+
+	// keep cached pages up to date with what has been programmed
+	pageSize := bd.config.PageSize
+	for len(buf) > 0 {
+		n := pageSize - offset%pageSize
+		if n > uint32(len(buf)) {
+			n = uint32(len(buf))
+		}
+		if elem, ok := bd.index[cacheKey{block: block, page: offset / pageSize}]; ok {
+			copy(elem.Value.(*cachePage).data[offset%pageSize:], buf[:n])
+		}
+		buf = buf[n:]
+		offset += n
+	}
+	return nil
+}
+
+func (bd *CachedBlockDevice) EraseBlock(block uint32) error {
+	if err := bd.flush(); err != nil {
+		return err
+	}
+	for page := uint32(0); page < bd.blockSize/bd.config.PageSize; page++ {
+		if elem, ok := bd.index[cacheKey{block: block, page: page}]; ok {
+			delete(bd.index, elem.Value.(*cachePage).key)
+			bd.pages.Remove(elem)
+		}
+	}
+	return bd.dev.EraseBlock(block)
+}
+
+// Sync passes any pending programs on to the device, and then syncs it
+func (bd *CachedBlockDevice) Sync() error {
+	if err := bd.flush(); err != nil {
+		return err
+	}
+	return bd.dev.Sync()
+}
+
+// flush passes the pending programs on to the device. If that fails, the
+// cached pages the programs went to are dropped, since they no longer
+// reflect what is on the device.
+func (bd *CachedBlockDevice) flush() error {
+	p := &bd.pending
+	if len(p.data) == 0 {
+		return nil
+	}
+	err := bd.dev.ProgramBlock(p.block, p.offset, p.data)
+	if err != nil {
+		pageSize := bd.config.PageSize
+		for page := p.offset / pageSize; page*pageSize < p.offset+uint32(len(p.data)); page++ {
+			if elem, ok := bd.index[cacheKey{block: p.block, page: page}]; ok {
+				delete(bd.index, elem.Value.(*cachePage).key)
+				bd.pages.Remove(elem)
+			}
+		}
+	}
+	p.data = p.data[:0]
+	return err
+}
+
+// overlaps reports whether the pending programs touch size bytes of block at
+// offset
+func (p *pendingProgram) overlaps(block uint32, offset uint32, size uint32) bool {
+	return len(p.data) > 0 && p.block == block &&
+		p.offset < offset+size && offset < p.offset+uint32(len(p.data))
+}
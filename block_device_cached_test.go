@@ -0,0 +1,234 @@
+package lfs
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+)
+
+var cacheConfig = CacheConfig{PageSize: 128, Pages: 16, ReadAhead: 3}
+
+func TestCachedBlockDevice(t *testing.T) {
+	t.Run("Identical", func(t *testing.T) {
+		plain := NewMemoryDevice(defaultConfig)
+		raw := NewMemoryDevice(defaultConfig)
+		counting := &countingBlockDevice{BlockDevice: raw}
+		cached := NewCachedDevice(counting, defaultConfig, cacheConfig)
+		want := cacheWorkload(t, plain, 1)
+		got := cacheWorkload(t, cached, 1)
+		if len(got) != len(want) {
+			t.Fatalf("expected %d files; found %d", len(want), len(got))
+		}
+		for name, data := range want {
+			if !bytes.Equal(got[name], data) {
+				t.Fatalf("%s: contents differ", name)
+			}
+		}
+		if !bytes.Equal(plain.Bytes(), raw.Bytes()) {
+			t.Fatal("expected identical images")
+		}
+
+		plainCount := &countingBlockDevice{BlockDevice: NewMemoryDevice(defaultConfig)}
+		cacheWorkload(t, plainCount, 1)
+		if counting.programs >= plainCount.programs {
+			t.Errorf("expected programs to be coalesced; cached %d, uncached %d",
+				counting.programs, plainCount.programs)
+		}
+	})
+
+	// cutting the power after the same number of programs and erases by
+	// littlefs must leave the same filesystem whether they went through the
+	// cache or not, although the cache may not have passed them all on yet
+	t.Run("PowerCut", func(t *testing.T) {
+		run := func(limit int, cached bool) (*powerCutBlockDevice, map[string][]byte) {
+			raw := NewMemoryDevice(defaultConfig)
+			check(t, New(defaultConfig, raw).Format())
+			var dev BlockDevice = raw
+			if cached {
+				dev = NewCachedDevice(raw, defaultConfig, cacheConfig)
+			}
+			cut := &powerCutBlockDevice{BlockDevice: dev, limit: limit, halt: true}
+			untilPowerCut(func() {
+				fs := New(defaultConfig, cut)
+				if fs.Mount() != nil {
+					return
+				}
+				churn(fs, 2)
+				fs.Unmount()
+			})
+			// only what reached raw survives
+			return cut, readAll(t, raw)
+		}
+		for limit := 1; ; limit += 23 {
+			cut, plain := run(limit, false)
+			_, cached := run(limit, true)
+			if plain == nil || cached == nil {
+				t.Fatalf("limit %d: could not mount after power cut", limit)
+			}
+			if fmt.Sprint(plain) != fmt.Sprint(cached) {
+				t.Fatalf("limit %d: filesystems differ after power cut", limit)
+			}
+			if !cut.cut() {
+				break
+			}
+		}
+	})
+}
+
+// cacheWorkload writes, rewrites and removes files on a new filesystem on dev,
+// and returns the resulting contents
+func cacheWorkload(t *testing.T, dev BlockDevice, seed int64) map[string][]byte {
+	fs := New(defaultConfig, dev)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	check(t, churn(fs, seed))
+	check(t, fs.Unmount())
+	return readAll(t, dev)
+}
+
+// churn writes, rewrites and removes files in fs
+func churn(fs *LFS, seed int64) error {
+	rnd := rand.New(rand.NewSource(seed))
+	for i := 0; i < 30; i++ {
+		name := fmt.Sprintf("file%d", rnd.Intn(8))
+		if rnd.Intn(5) == 0 {
+			if err := fs.Remove(name); err != nil && err != ErrNoEntry {
+				return err
+			}
+			continue
+		}
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		buf := make([]byte, rnd.Intn(3000))
+		rnd.Read(buf)
+		for len(buf) > 0 {
+			n := rnd.Intn(200) + 1
+			if n > len(buf) {
+				n = len(buf)
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				f.Close()
+				return err
+			}
+			buf = buf[n:]
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readAll returns the contents of the files in the root directory of the
+// filesystem on dev, or nil if it cannot be mounted
+func readAll(t *testing.T, dev BlockDevice) map[string][]byte {
+	fs := New(defaultConfig, dev)
+	if err := fs.Mount(); err != nil {
+		return nil
+	}
+	defer fs.Unmount()
+	dir, err := fs.Open("/")
+	check(t, err)
+	infos, err := dir.Readdir(0)
+	check(t, err)
+	check(t, dir.Close())
+	contents := map[string][]byte{}
+	for _, info := range infos {
+		f, err := fs.Open(info.Name())
+		check(t, err)
+		buf := make([]byte, info.Size())
+		for n := 0; n < len(buf); {
+			m, err := f.Read(buf[n:])
+			check(t, err)
+			n += m
+		}
+		check(t, f.Close())
+		contents[info.Name()] = buf
+	}
+	return contents
+}
+
+// latencyBlockDevice simulates the latency of a flash chip on a slow bus
+type latencyBlockDevice struct {
+	BlockDevice
+	latency time.Duration
+}
+
+func (bd *latencyBlockDevice) wait() {
+	for start := time.Now(); time.Since(start) < bd.latency; {
+	}
+}
+
+func (bd *latencyBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	bd.wait()
+	return bd.BlockDevice.ReadBlock(block, offset, buf)
+}
+
+func (bd *latencyBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	bd.wait()
+	return bd.BlockDevice.ProgramBlock(block, offset, buf)
+}
+
+func (bd *latencyBlockDevice) EraseBlock(block uint32) error {
+	bd.wait()
+	return bd.BlockDevice.EraseBlock(block)
+}
+
+func BenchmarkCachedBlockDevice(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		dev  func() BlockDevice
+	}{
+		{"Mem", func() BlockDevice {
+			return &latencyBlockDevice{NewMemoryDevice(defaultConfig), 10 * time.Microsecond}
+		}},
+		{"Cached", func() BlockDevice {
+			dev := &latencyBlockDevice{NewMemoryDevice(defaultConfig), 10 * time.Microsecond}
+			return NewCachedDevice(dev, defaultConfig, CacheConfig{PageSize: 128, Pages: 32, ReadAhead: 3})
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			fs := New(defaultConfig, bench.dev())
+			if err := fs.Format(); err != nil {
+				b.Fatal(err)
+			}
+			if err := fs.Mount(); err != nil {
+				b.Fatal(err)
+			}
+			defer fs.Unmount()
+			buf := make([]byte, 16*1024)
+			b.SetBytes(int64(2 * len(buf)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				f, err := fs.OpenFile("bench", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for off := 0; off < len(buf); off += 64 {
+					if _, err := f.Write(buf[off : off+64]); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := f.Close(); err != nil {
+					b.Fatal(err)
+				}
+				if f, err = fs.Open("bench"); err != nil {
+					b.Fatal(err)
+				}
+				for off := 0; off < len(buf); off += 64 {
+					if _, err := f.Read(buf[off : off+64]); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := f.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
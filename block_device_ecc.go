@@ -0,0 +1,228 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// eccSpareSize is the number of spare bytes kept for each page: the XOR of
+// the positions of the set bits of the page, the parity of the page, and a
+// CRC-32 of the page
+const eccSpareSize = 4 + 1 + 4
+
+// ECCStats counts what an ECCBlockDevice has found while reading pages
+type ECCStats struct {
+	Pages         uint64 // pages read and checked
+	Corrected     uint64 // pages with a single bit error which was corrected
+	Uncorrectable uint64 // pages with more errors than could be corrected
+}
+
+// ECCBlockDevice wraps another block device, keeping error correcting codes
+// for each page of data in spare bytes stored right after it, the way NAND
+// flash keeps them in the spare area of each of its pages. A single flipped
+// bit in a page is corrected when it is read, and any other error that is
+// detected is reported as ErrCorrupt.
+//
+// Each page is programmed along with its spare bytes in one go, padded to
+// the ProgSize of the underlying device. A page whose spare bytes are still
+// erased has no code yet and is read as it is, so a page which lost its code
+// to a power loss is left for littlefs to check like any other.
+//
+// The blocks of the device are smaller than those of the underlying device,
+// to make room for the spare bytes; the filesystem must be configured with
+// the BlockSize of the ECCBlockDevice, and a ProgSize that is a multiple of
+// its page size, so that each page is programmed in one go.
+type ECCBlockDevice struct {
+	dev       BlockDevice
+	pageSize  uint32
+	spareSize uint32 // eccSpareSize padded to the ProgSize of dev
+	pages     uint32
+	stats     ECCStats
+
+	// slot holds a page and its spare bytes as they are stored
+	slot  []byte
+	page  []byte
+	spare []byte
+}
+
+// NewECCDevice returns an ECC wrapper for dev, whose geometry is given by
+// the BlockSize and ProgSize of config, protecting each pageSize bytes of
+// data; pageSize must be a multiple of the ProgSize
+func NewECCDevice(dev BlockDevice, config Config, pageSize uint32) (*ECCBlockDevice, error) {
+	prog := max(config.ProgSize, 1)
+	if pageSize == 0 || pageSize%prog != 0 {
+		return nil, ErrInvalidParam
+	}
+	spareSize := (eccSpareSize + prog - 1) / prog * prog
+	pages := config.BlockSize / (pageSize + spareSize)
+	if pages == 0 {
+		return nil, ErrInvalidParam
+	}
+	slot := make([]byte, pageSize+spareSize)
+	return &ECCBlockDevice{
+		dev:       dev,
+		pageSize:  pageSize,
+		spareSize: spareSize,
+		pages:     pages,
+		slot:      slot,
+		page:      slot[:pageSize],
+		spare:     slot[pageSize : pageSize+eccSpareSize],
+	}, nil
+}
+
+// BlockSize returns the size of the blocks of the device, which is the size
+// of the data that fits in each block of the underlying device
+func (bd *ECCBlockDevice) BlockSize() uint32 {
+	return bd.pages * bd.pageSize
+}
+
+// PageSize returns the size of the data protected by each code
+func (bd *ECCBlockDevice) PageSize() uint32 {
+	return bd.pageSize
+}
+
+// Stats returns the number of pages that have been checked and corrected
+func (bd *ECCBlockDevice) Stats() ECCStats {
+	return bd.stats
+}
+
+// ResetStats sets all the counters of Stats back to zero
+func (bd *ECCBlockDevice) ResetStats() {
+	bd.stats = ECCStats{}
+}
+
+func (bd *ECCBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	if offset+uint32(len(buf)) > bd.BlockSize() {
+		return ErrInvalidParam
+	}
+	for len(buf) > 0 {
+		page := offset / bd.pageSize
+		if err := bd.readPage(block, page); err != nil {
+			return err
+		}
+		n := copy(buf, bd.page[offset%bd.pageSize:])
+		buf = buf[n:]
+		offset += uint32(n)
+	}
+	return nil
+}
+
+// readPage reads and corrects a page into bd.page
+func (bd *ECCBlockDevice) readPage(block, page uint32) error {
+	if err := bd.dev.ReadBlock(block, bd.pageOffset(page), bd.slot); err != nil {
+		return err
+	}
+	bd.stats.Pages++
+	// pages that have not been programmed since they were erased, or whose
+	// spare bytes were not programmed before power was lost, have no code
+	if isErased(bd.spare) {
+		return nil
+	}
+	switch eccCorrect(bd.page, bd.spare) {
+	case eccOK:
+	case eccCorrected:
+		bd.stats.Corrected++
+	default:
+		bd.stats.Uncorrectable++
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func (bd *ECCBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if offset%bd.pageSize != 0 || uint32(len(buf))%bd.pageSize != 0 ||
+		offset+uint32(len(buf)) > bd.BlockSize() {
+		return ErrInvalidParam
+	}
+	for ; len(buf) > 0; buf = buf[bd.pageSize:] {
+		page := offset / bd.pageSize
+		copy(bd.page, buf)
+		eccEncode(bd.page, bd.spare)
+		// the padding is left erased
+		for i := bd.pageSize + eccSpareSize; i < uint32(len(bd.slot)); i++ {
+			bd.slot[i] = 0xff
+		}
+		if err := bd.dev.ProgramBlock(block, bd.pageOffset(page), bd.slot); err != nil {
+			return err
+		}
+		offset += bd.pageSize
+	}
+	return nil
+}
+
+func (bd *ECCBlockDevice) EraseBlock(block uint32) error {
+	return bd.dev.EraseBlock(block)
+}
+
+func (bd *ECCBlockDevice) Sync() error {
+	return bd.dev.Sync()
+}
+
+// pageOffset returns the offset of page in its block, which its spare bytes
+// follow
+func (bd *ECCBlockDevice) pageOffset(page uint32) uint32 {
+	return page * (bd.pageSize + bd.spareSize)
+}
+
+type eccResult int
+
+const (
+	eccOK eccResult = iota
+	eccCorrected
+	eccUncorrectable
+)
+
+// eccSyndrome returns the XOR of the positions, counting from one, of the
+// set bits of data, and the parity of data. A single flipped bit changes the
+// parity, and changes the XOR by its position.
+func eccSyndrome(data []byte) (uint32, byte) {
+	var code uint32
+	var parity byte
+	for i, b := range data {
+		parity ^= b
+		for bit := uint32(0); b != 0; bit, b = bit+1, b>>1 {
+			if b&1 != 0 {
+				code ^= uint32(i)*8 + bit + 1
+			}
+		}
+	}
+	parity ^= parity >> 4
+	parity ^= parity >> 2
+	parity ^= parity >> 1
+	return code, parity & 1
+}
+
+// eccEncode writes the spare bytes for data
+func eccEncode(data []byte, spare []byte) {
+	code, parity := eccSyndrome(data)
+	binary.LittleEndian.PutUint32(spare, code)
+	spare[4] = parity
+	binary.LittleEndian.PutUint32(spare[5:], crc32.ChecksumIEEE(data))
+}
+
+// eccCorrect checks data against its spare bytes, correcting it in place if
+// a single bit was flipped
+func eccCorrect(data []byte, spare []byte) eccResult {
+	sum := binary.LittleEndian.Uint32(spare[5:])
+	if crc32.ChecksumIEEE(data) == sum {
+		// any errors are in the spare bytes themselves
+		return eccOK
+	}
+	code, parity := eccSyndrome(data)
+	syndrome := code ^ binary.LittleEndian.Uint32(spare)
+	switch {
+	case parity != spare[4]&1 && syndrome >= 1 && syndrome <= uint32(len(data))*8:
+		// an odd number of flipped bits, which is only correctable if there
+		// was just one of them; the CRC tells whether that was the case
+		pos := syndrome - 1
+		data[pos/8] ^= 1 << (pos % 8)
+		if crc32.ChecksumIEEE(data) == sum {
+			return eccCorrected
+		}
+		data[pos/8] ^= 1 << (pos % 8)
+	case parity == spare[4]&1 && syndrome == 0:
+		// the data agrees with its code, so it is the CRC that was damaged
+		return eccCorrected
+	}
+	return eccUncorrectable
+}
@@ -0,0 +1,118 @@
+package lfs
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestECCBlockDevice(t *testing.T) {
+	physical := defaultConfig
+	physical.BlockCount = 256
+	raw := NewMemoryDevice(physical)
+	dev, err := NewECCDevice(raw, physical, 64)
+	check(t, err)
+	config := physical
+	config.BlockSize = dev.BlockSize()
+	config.ProgSize = dev.PageSize()
+	config.CacheSize = dev.PageSize()
+
+	rng := rand.New(rand.NewSource(1))
+	contents := map[string][]byte{}
+	fs := New(config, dev)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	for i, size := range []int{10, 300, 2000, 5000} {
+		name := string(rune('a' + i))
+		contents[name] = make([]byte, size)
+		rng.Read(contents[name])
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		_, err = f.Write(contents[name])
+		check(t, err)
+		check(t, f.Close())
+	}
+	check(t, fs.Unmount())
+
+	// programmedPages calls fn with the data and spare bytes of each page
+	// that has been programmed
+	programmedPages := func(fn func(data, spare []byte)) {
+		for block := uint32(0); block < physical.BlockCount; block++ {
+			mem := raw.block(block)
+			for page := uint32(0); page < dev.pages; page++ {
+				slot := mem[dev.pageOffset(page):]
+				spare := slot[dev.pageSize:][:eccSpareSize]
+				if !isErased(spare) {
+					fn(slot[:dev.pageSize], spare)
+				}
+			}
+		}
+	}
+
+	t.Run("Correctable", func(t *testing.T) {
+		pages := 0
+		programmedPages(func(data, spare []byte) {
+			if pages%2 == 0 {
+				bit := rng.Intn(len(data) * 8)
+				data[bit/8] ^= 1 << (bit % 8)
+			} else {
+				bit := rng.Intn(len(spare) * 8)
+				spare[bit/8] ^= 1 << (bit % 8)
+			}
+			pages++
+		})
+		dev.ResetStats()
+		check(t, fs.Mount())
+		for name, want := range contents {
+			f, err := fs.Open(name)
+			check(t, err)
+			buf := make([]byte, len(want)+1)
+			n, err := f.Read(buf)
+			check(t, err)
+			check(t, f.Close())
+			if !bytes.Equal(buf[:n], want) {
+				t.Errorf("contents of %s differ", name)
+			}
+		}
+		check(t, fs.Unmount())
+		stats := dev.Stats()
+		if stats.Corrected == 0 || stats.Uncorrectable != 0 {
+			t.Errorf("unexpected stats %+v", stats)
+		}
+	})
+
+	t.Run("Uncorrectable", func(t *testing.T) {
+		check(t, dev.ProgramBlock(200, 0, bytes.Repeat([]byte("ecc!"), 16)))
+		raw.block(200)[3] ^= 0x11
+		buf := make([]byte, 16)
+		if err := dev.ReadBlock(200, 0, buf); err != ErrCorrupt {
+			t.Errorf("expected ErrCorrupt; got %v", err)
+		}
+		if dev.Stats().Uncorrectable != 1 {
+			t.Errorf("expected one uncorrectable page; got %+v", dev.Stats())
+		}
+	})
+
+	t.Run("NoCode", func(t *testing.T) {
+		// a page programmed without its spare bytes, as by a power loss
+		data := bytes.Repeat([]byte("data"), 16)
+		check(t, raw.ProgramBlock(202, dev.pageOffset(1), data))
+		buf := make([]byte, len(data))
+		if err := dev.ReadBlock(202, dev.pageSize, buf); err != nil || !bytes.Equal(buf, data) {
+			t.Errorf("expected the page to be read as it is; got %v", err)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		if _, err := NewECCDevice(raw, physical, 512); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+		if _, err := NewECCDevice(raw, physical, 40); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+		if err := dev.ProgramBlock(201, 16, make([]byte, 64)); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+}
@@ -0,0 +1,221 @@
+package lfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+)
+
+// ErrKeyLocked is returned by an EncryptedBlockDevice while its key is locked;
+// the error from the KeyProvider, if any, is wrapped along with it
+var ErrKeyLocked = errors.New("littlefs: encryption key is locked")
+
+// encryptionUnit is the size of the units in which data is encrypted, which
+// is the AES block size
+const encryptionUnit = aes.BlockSize
+
+// KeyProvider supplies the key of an EncryptedBlockDevice. It is asked for
+// the key when the device is first used and again after each Lock, so the key
+// can be kept in a secure element or derived from a passphrase only while it
+// is needed.
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// KeyFunc adapts an ordinary function to the KeyProvider interface
+type KeyFunc func() ([]byte, error)
+
+func (fn KeyFunc) Key() ([]byte, error) {
+	return fn()
+}
+
+// StaticKey returns a KeyProvider that always supplies key
+func StaticKey(key []byte) KeyProvider {
+	return KeyFunc(func() ([]byte, error) {
+		return key, nil
+	})
+}
+
+// EncryptedBlockDevice wraps another block device, encrypting everything
+// programmed to it with AES-XTS and decrypting everything read from it. The
+// tweak of each 16 byte unit is derived from its block number and its offset
+// in the block, so identical data stored in different places does not look
+// the same on the device.
+//
+// littlefs only ever programs erased regions and never programs the same
+// region twice without erasing it first, so each unit is encrypted exactly
+// once per erase cycle, however the programs of a block are split up. Units
+// which are still erased are read back as 0xff rather than decrypted, so that
+// littlefs sees erased regions as it expects.
+//
+// The ProgSize and BlockSize of the filesystem must be multiples of 16.
+type EncryptedBlockDevice struct {
+	dev  BlockDevice
+	keys KeyProvider
+
+	// key is a copy of the key from the KeyProvider, from which k1, which
+	// encrypts the data, and k2, which encrypts the tweaks, are derived; all
+	// are nil while locked
+	key    []byte
+	k1, k2 cipher.Block
+
+	buf []byte
+}
+
+// NewEncryptedDevice returns an encrypting wrapper for dev, whose key is
+// supplied by keys. The key must be 32 bytes long for AES-128-XTS or 64
+// bytes long for AES-256-XTS.
+func NewEncryptedDevice(dev BlockDevice, config Config, keys KeyProvider) (*EncryptedBlockDevice, error) {
+	if config.ProgSize%encryptionUnit != 0 || config.BlockSize%encryptionUnit != 0 {
+		return nil, ErrInvalidParam
+	}
+	return &EncryptedBlockDevice{
+		dev:  dev,
+		keys: keys,
+		buf:  make([]byte, config.BlockSize),
+	}, nil
+}
+
+// Lock forgets the key, so that the device cannot be used until Unlock is
+// called or the key is supplied again by the KeyProvider. The copy of the key
+// kept by the device is zeroed; the round keys crypto/aes expanded from it
+// cannot be, and are left to the garbage collector.
+func (bd *EncryptedBlockDevice) Lock() {
+	clear(bd.key)
+	bd.key, bd.k1, bd.k2 = nil, nil, nil
+}
+
+// Unlock asks the KeyProvider for the key
+func (bd *EncryptedBlockDevice) Unlock() error {
+	key, err := bd.keys.Key()
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 && len(key) != 64 {
+		return ErrInvalidParam
+	}
+	// the key is copied so that Lock can zero it without touching the slice
+	// the KeyProvider may supply again
+	key = append([]byte(nil), key...)
+	k1, err := aes.NewCipher(key[:len(key)/2])
+	if err != nil {
+		clear(key)
+		return err
+	}
+	k2, err := aes.NewCipher(key[len(key)/2:])
+	if err != nil {
+		clear(key)
+		return err
+	}
+	bd.Lock()
+	bd.key, bd.k1, bd.k2 = key, k1, k2
+	return nil
+}
+
+// unlocked makes sure the key is available before the device is used
+func (bd *EncryptedBlockDevice) unlocked() error {
+	if bd.k1 != nil {
+		return nil
+	}
+	if err := bd.Unlock(); err != nil {
+		return fmt.Errorf("%w: %v", ErrKeyLocked, err)
+	}
+	return nil
+}
+
+func (bd *EncryptedBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	if err := bd.unlocked(); err != nil {
+		return err
+	}
+	// read whole units, then copy out the part that was asked for
+	start := offset - offset%encryptionUnit
+	end := offset + uint32(len(buf))
+	if rem := end % encryptionUnit; rem != 0 {
+		end += encryptionUnit - rem
+	}
+	if end-start > uint32(len(bd.buf)) {
+		return ErrInvalidParam
+	}
+	data := bd.buf[:end-start]
+	if err := bd.dev.ReadBlock(block, start, data); err != nil {
+		return err
+	}
+	bd.crypt(block, start, data, false)
+	copy(buf, data[offset-start:])
+	return nil
+}
+
+func (bd *EncryptedBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if err := bd.unlocked(); err != nil {
+		return err
+	}
+	if offset%encryptionUnit != 0 || len(buf)%encryptionUnit != 0 || len(buf) > len(bd.buf) {
+		return ErrInvalidParam
+	}
+	data := bd.buf[:len(buf)]
+	copy(data, buf)
+	bd.crypt(block, offset, data, true)
+	return bd.dev.ProgramBlock(block, offset, data)
+}
+
+func (bd *EncryptedBlockDevice) EraseBlock(block uint32) error {
+	return bd.dev.EraseBlock(block)
+}
+
+func (bd *EncryptedBlockDevice) Sync() error {
+	return bd.dev.Sync()
+}
+
+// crypt encrypts or decrypts data in place, which starts at offset in block;
+// when decrypting, units which are still erased are left as they are
+func (bd *EncryptedBlockDevice) crypt(block uint32, offset uint32, data []byte, encrypt bool) {
+	// the tweak of the first unit of the block is the encrypted block number,
+	// and each following unit's is the previous one multiplied by x in
+	// GF(2^128), as in IEEE 1619
+	var tweak [encryptionUnit]byte
+	tweak[0], tweak[1], tweak[2], tweak[3] = byte(block), byte(block>>8), byte(block>>16), byte(block>>24)
+	bd.k2.Encrypt(tweak[:], tweak[:])
+	for i := uint32(0); i < offset/encryptionUnit; i++ {
+		gfDouble(&tweak)
+	}
+	for ; len(data) > 0; data = data[encryptionUnit:] {
+		unit := data[:encryptionUnit]
+		if encrypt || !isErased(unit) {
+			for i := range unit {
+				unit[i] ^= tweak[i]
+			}
+			if encrypt {
+				bd.k1.Encrypt(unit, unit)
+			} else {
+				bd.k1.Decrypt(unit, unit)
+			}
+			for i := range unit {
+				unit[i] ^= tweak[i]
+			}
+		}
+		gfDouble(&tweak)
+	}
+}
+
+// gfDouble multiplies the little-endian tweak by x in GF(2^128)
+func gfDouble(t *[encryptionUnit]byte) {
+	var carry byte
+	for i := range t {
+		next := t[i] >> 7
+		t[i] = t[i]<<1 | carry
+		carry = next
+	}
+	if carry != 0 {
+		t[0] ^= 0x87
+	}
+}
+
+func isErased(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
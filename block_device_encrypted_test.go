@@ -0,0 +1,143 @@
+package lfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedBlockDevice(t *testing.T) {
+	key := make([]byte, 64)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	secret := bytes.Repeat([]byte("wifi-password=hunter2;"), 50)
+
+	store := func(t *testing.T, dev BlockDevice) {
+		fs := New(defaultConfig, dev)
+		check(t, fs.Format())
+		check(t, fs.Mount())
+		check(t, fs.Mkdir("credentials"))
+		for _, name := range []string{"credentials/inline", "credentials/wifi"} {
+			f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+			check(t, err)
+			if name == "credentials/inline" {
+				_, err = f.Write(secret[:15])
+			} else {
+				_, err = f.Write(secret)
+			}
+			check(t, err)
+			check(t, f.Close())
+		}
+		check(t, fs.Unmount())
+	}
+
+	t.Run("NoPlaintext", func(t *testing.T) {
+		// make sure the check would catch plaintext on an unencrypted device
+		plain := NewMemoryDevice(defaultConfig)
+		store(t, plain)
+		if !bytes.Contains(plain.Bytes(), []byte("hunter2")) || !bytes.Contains(plain.Bytes(), []byte("credentials")) {
+			t.Fatal("expected plaintext on unencrypted device")
+		}
+
+		raw := NewMemoryDevice(defaultConfig)
+		dev, err := NewEncryptedDevice(raw, defaultConfig, StaticKey(key))
+		check(t, err)
+		store(t, dev)
+		for _, s := range []string{"hunter2", "wifi-password", "credentials", "littlefs"} {
+			if bytes.Contains(raw.Bytes(), []byte(s)) {
+				t.Errorf("found %q on the raw device", s)
+			}
+		}
+
+		fs := New(defaultConfig, dev)
+		check(t, fs.Mount())
+		f, err := fs.Open("credentials/wifi")
+		check(t, err)
+		buf := make([]byte, len(secret))
+		n, err := f.Read(buf)
+		check(t, err)
+		check(t, f.Close())
+		if !bytes.Equal(buf[:n], secret) {
+			t.Fatal("contents differ")
+		}
+		check(t, fs.Unmount())
+	})
+
+	t.Run("Erased", func(t *testing.T) {
+		dev, err := NewEncryptedDevice(NewMemoryDevice(defaultConfig), defaultConfig, StaticKey(key))
+		check(t, err)
+		check(t, dev.ProgramBlock(3, 32, bytes.Repeat([]byte{0}, 16)))
+		buf := make([]byte, 64)
+		check(t, dev.ReadBlock(3, 8, buf))
+		want := append(append(bytes.Repeat([]byte{0xff}, 24), make([]byte, 16)...), bytes.Repeat([]byte{0xff}, 24)...)
+		if !bytes.Equal(buf, want) {
+			t.Fatalf("expected erased regions to read as 0xff:\n%x", buf)
+		}
+	})
+
+	t.Run("Keys", func(t *testing.T) {
+		raw := NewMemoryDevice(defaultConfig)
+		locked := errors.New("no key")
+		var provided []byte
+		dev, err := NewEncryptedDevice(raw, defaultConfig, KeyFunc(func() ([]byte, error) {
+			if provided == nil {
+				return nil, locked
+			}
+			return provided, nil
+		}))
+		check(t, err)
+		if err := New(defaultConfig, dev).Format(); err == nil {
+			t.Fatal("expected format to fail without a key")
+		}
+		if err := dev.ReadBlock(0, 0, make([]byte, 16)); !errors.Is(err, ErrKeyLocked) || !strings.Contains(err.Error(), locked.Error()) {
+			t.Fatalf("expected ErrKeyLocked with the cause; was %v", err)
+		}
+		provided = key
+		store(t, dev)
+		held := dev.key
+		dev.Lock()
+		if !bytes.Equal(held, make([]byte, len(key))) || !bytes.Equal(key[:4], []byte{0, 1, 2, 3}) {
+			t.Fatal("expected Lock to zero its copy of the key, and only that")
+		}
+		provided = nil
+		if err := New(defaultConfig, dev).Mount(); err == nil {
+			t.Fatal("expected mount to fail while locked")
+		}
+
+		provided = key
+		if err := New(defaultConfig, dev).Mount(); err != nil {
+			t.Fatal("expected mount to succeed once unlocked")
+		}
+
+		// a different key does not reveal the filesystem
+		wrong, err := NewEncryptedDevice(raw, defaultConfig, StaticKey(make([]byte, 32)))
+		check(t, err)
+		if err := New(defaultConfig, wrong).Mount(); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt with the wrong key; was %v", err)
+		}
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		config := defaultConfig
+		config.ProgSize = 8
+		if _, err := NewEncryptedDevice(NewMemoryDevice(config), config, StaticKey(key)); err != ErrInvalidParam {
+			t.Fatalf("expected ErrInvalidParam; was %v", err)
+		}
+	})
+
+	// IEEE 1619 XTS-AES-128 test vector 1
+	t.Run("XTS", func(t *testing.T) {
+		raw := NewMemoryDevice(defaultConfig)
+		dev, err := NewEncryptedDevice(raw, defaultConfig, StaticKey(make([]byte, 32)))
+		check(t, err)
+		check(t, dev.ProgramBlock(0, 0, make([]byte, 32)))
+		want, _ := hex.DecodeString("917cf69ebd68b2ec9b9fe9a3eadda692cd43d2f59598ed858c02c2652fbf922e")
+		if !bytes.Equal(raw.Bytes()[:32], want) {
+			t.Fatalf("unexpected ciphertext %x", raw.Bytes()[:32])
+		}
+	})
+}
@@ -5,21 +5,39 @@ import (
 )
 
 // MemBlockDevice is a block device implementation backed by a byte slice
+// for each block. Blocks are shared between a device and its clones and
+// snapshots until one of them modifies the block, so that taking a snapshot
+// only costs a slice header per block.
 type MemBlockDevice struct {
 	config     Config
-	memory     []byte
+	blocks     [][]byte
 	blankBlock []byte
+
+	// owned records which blocks are not shared with a clone or snapshot,
+	// and so can be modified in place
+	owned []bool
+}
+
+// MemSnapshot holds the contents of a MemBlockDevice at the time Snapshot
+// was called
+type MemSnapshot struct {
+	blocks [][]byte
 }
 
 func NewMemoryDevice(config Config) *MemBlockDevice {
 	dev := &MemBlockDevice{
 		config:     config,
-		memory:     make([]byte, config.BlockSize*config.BlockCount),
+		blocks:     make([][]byte, config.BlockCount),
 		blankBlock: make([]byte, config.BlockSize),
+		owned:      make([]bool, config.BlockCount),
 	}
 	for i := range dev.blankBlock {
 		dev.blankBlock[i] = 0xff
 	}
+	for i := range dev.blocks {
+		dev.blocks[i] = make([]byte, config.BlockSize)
+		dev.owned[i] = true
+	}
 	for i := uint32(0); i < config.BlockCount; i++ {
 		if err := dev.EraseBlock(i); err != nil {
 			panic(fmt.Sprintf("could not initialize block %d: %s", i, err.Error()))
@@ -29,20 +47,92 @@ func NewMemoryDevice(config Config) *MemBlockDevice {
 }
 
 func (bd *MemBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
-	copy(buf, bd.memory[bd.config.BlockSize*block+offset:])
+	copy(buf, bd.blocks[block][offset:])
 	return nil
 }
 
 func (bd *MemBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
-	copy(bd.memory[bd.config.BlockSize*block+offset:], buf)
+	copy(bd.block(block)[offset:], buf)
 	return nil
 }
 
 func (bd *MemBlockDevice) EraseBlock(block uint32) error {
-	copy(bd.memory[bd.config.BlockSize*block:], bd.blankBlock)
+	copy(bd.block(block), bd.blankBlock)
 	return nil
 }
 
 func (bd *MemBlockDevice) Sync() error {
 	return nil
 }
+
+// block returns block for modification, copying it first if it is shared
+func (bd *MemBlockDevice) block(block uint32) []byte {
+	if !bd.owned[block] {
+		bd.blocks[block] = append([]byte(nil), bd.blocks[block]...)
+		bd.owned[block] = true
+	}
+	return bd.blocks[block]
+}
+
+// share marks every block as shared, before the blocks are handed to a
+// clone or snapshot
+func (bd *MemBlockDevice) share() [][]byte {
+	for i := range bd.owned {
+		bd.owned[i] = false
+	}
+	return append([][]byte(nil), bd.blocks...)
+}
+
+// Clone returns a new device with the same contents as bd. Each block is
+// only copied once either device modifies it.
+func (bd *MemBlockDevice) Clone() *MemBlockDevice {
+	return &MemBlockDevice{
+		config:     bd.config,
+		blocks:     bd.share(),
+		blankBlock: bd.blankBlock,
+		owned:      make([]bool, len(bd.blocks)),
+	}
+}
+
+// Snapshot records the current contents of the device, so that they can be
+// brought back with Restore
+func (bd *MemBlockDevice) Snapshot() *MemSnapshot {
+	return &MemSnapshot{blocks: bd.share()}
+}
+
+// Restore sets the contents of the device back to those recorded by
+// Snapshot. The snapshot can be restored again later. Any filesystem on the
+// device must be unmounted while it is restored.
+func (bd *MemBlockDevice) Restore(s *MemSnapshot) error {
+	if len(s.blocks) != len(bd.blocks) || len(s.blocks) > 0 && len(s.blocks[0]) != len(bd.blankBlock) {
+		return ErrInvalidParam
+	}
+	copy(bd.blocks, s.blocks)
+	for i := range bd.owned {
+		bd.owned[i] = false
+	}
+	return nil
+}
+
+// Bytes returns a copy of the whole contents of the device, as an image
+// which can be written to flash or loaded with LoadImage
+func (bd *MemBlockDevice) Bytes() []byte {
+	image := make([]byte, 0, len(bd.blocks)*len(bd.blankBlock))
+	for _, block := range bd.blocks {
+		image = append(image, block...)
+	}
+	return image
+}
+
+// LoadImage replaces the contents of the device with image, which must be
+// exactly as large as the device
+func (bd *MemBlockDevice) LoadImage(image []byte) error {
+	size := len(bd.blankBlock)
+	if len(image) != len(bd.blocks)*size {
+		return ErrInvalidParam
+	}
+	for i := range bd.blocks {
+		copy(bd.block(uint32(i)), image[i*size:])
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package lfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestMemBlockDevice(t *testing.T) {
+	exists := func(t *testing.T, dev BlockDevice, names ...string) []bool {
+		fs := New(defaultConfig, dev)
+		check(t, fs.Mount())
+		defer fs.Unmount()
+		found := make([]bool, len(names))
+		for i, name := range names {
+			_, err := fs.Stat(name)
+			found[i] = err == nil
+		}
+		return found
+	}
+	create := func(t *testing.T, dev BlockDevice, name string) {
+		fs := New(defaultConfig, dev)
+		check(t, fs.Mount())
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		_, err = f.Write(bytes.Repeat([]byte(name), 200))
+		check(t, err)
+		check(t, f.Close())
+		check(t, fs.Unmount())
+	}
+
+	dev := NewMemoryDevice(defaultConfig)
+	check(t, New(defaultConfig, dev).Format())
+	create(t, dev, "a")
+
+	t.Run("Snapshot", func(t *testing.T) {
+		snap := dev.Snapshot()
+		create(t, dev, "b")
+		if found := exists(t, dev, "a", "b"); !found[0] || !found[1] {
+			t.Fatalf("expected a and b to exist; got %v", found)
+		}
+		check(t, dev.Restore(snap))
+		if found := exists(t, dev, "a", "b"); !found[0] || found[1] {
+			t.Fatalf("expected only a to exist after restoring; got %v", found)
+		}
+		// the snapshot is unaffected by changes made after restoring it
+		create(t, dev, "c")
+		check(t, dev.Restore(snap))
+		if found := exists(t, dev, "a", "c"); !found[0] || found[1] {
+			t.Fatalf("expected only a to exist after restoring again; got %v", found)
+		}
+		if err := NewMemoryDevice(Config{BlockSize: 512, BlockCount: 8}).Restore(snap); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+
+	t.Run("Clone", func(t *testing.T) {
+		clone := dev.Clone()
+		shared := 0
+		for i := range dev.blocks {
+			if &dev.blocks[i][0] == &clone.blocks[i][0] {
+				shared++
+			}
+		}
+		if shared != len(dev.blocks) {
+			t.Errorf("expected all %d blocks to be shared; got %d", len(dev.blocks), shared)
+		}
+		create(t, clone, "d")
+		if found := exists(t, dev, "a", "d"); !found[0] || found[1] {
+			t.Errorf("expected the original to be unaffected by its clone; got %v", found)
+		}
+		if found := exists(t, clone, "a", "d"); !found[0] || !found[1] {
+			t.Errorf("expected a and d in the clone; got %v", found)
+		}
+	})
+
+	t.Run("Image", func(t *testing.T) {
+		image := dev.Bytes()
+		if len(image) != int(defaultConfig.BlockSize*defaultConfig.BlockCount) {
+			t.Fatalf("unexpected image size %d", len(image))
+		}
+		loaded := NewMemoryDevice(defaultConfig)
+		check(t, loaded.LoadImage(image))
+		if !bytes.Equal(loaded.Bytes(), image) {
+			t.Fatal("expected the same image after loading it")
+		}
+		if found := exists(t, loaded, "a"); !found[0] {
+			t.Error("expected a in the loaded image")
+		}
+		if err := loaded.LoadImage(image[1:]); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package lfs
+
+// ReadOnlyBlockDevice wraps another block device and refuses to program or
+// erase it, so that a filesystem on the underlying device can never be
+// modified no matter how it is mounted
+type ReadOnlyBlockDevice struct {
+	dev BlockDevice
+}
+
+func NewReadOnlyDevice(dev BlockDevice) *ReadOnlyBlockDevice {
+	return &ReadOnlyBlockDevice{dev: dev}
+}
+
+func (bd *ReadOnlyBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	return bd.dev.ReadBlock(block, offset, buf)
+}
+
+func (bd *ReadOnlyBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	return ErrReadOnly
+}
+
+func (bd *ReadOnlyBlockDevice) EraseBlock(block uint32) error {
+	return ErrReadOnly
+}
+
+func (bd *ReadOnlyBlockDevice) Sync() error {
+	return bd.dev.Sync()
+}
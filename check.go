@@ -0,0 +1,338 @@
+package lfs
+
+// #include <stdlib.h>
+// #include "./go_lfs.h"
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sort"
+	"unsafe"
+)
+
+// ProblemKind classifies an inconsistency found by Check
+type ProblemKind int
+
+const (
+	ProblemCorrupt         ProblemKind = iota // metadata could not be read, e.g. due to CRC failures
+	ProblemInvalidBlock                       // block address is outside of the device
+	ProblemDoubleReference                    // block is referenced more than once
+	ProblemUnreachable                        // block used by a file is not reached by a traversal
+	ProblemSizeMismatch                       // file contents do not match the size in its directory entry
+	ProblemOrphans                            // orphaned metadata pairs are waiting to be cleaned up
+	ProblemPendingMove                        // an interrupted rename has not been completed
+)
+
+func (kind ProblemKind) String() string {
+	switch kind {
+	case ProblemCorrupt:
+		return "corrupt"
+	case ProblemInvalidBlock:
+		return "invalid block"
+	case ProblemDoubleReference:
+		return "double reference"
+	case ProblemUnreachable:
+		return "unreachable block"
+	case ProblemSizeMismatch:
+		return "size mismatch"
+	case ProblemOrphans:
+		return "orphans"
+	case ProblemPendingMove:
+		return "pending move"
+	default:
+		return "unknown problem"
+	}
+}
+
+// CheckProblem describes a single inconsistency found by Check. Path and
+// Block are only meaningful for problems associated with a file or a block.
+type CheckProblem struct {
+	Kind  ProblemKind
+	Path  string
+	Block uint32
+	Err   error
+}
+
+func (p CheckProblem) String() string {
+	s := p.Kind.String()
+	if p.Path != "" {
+		s += " " + p.Path
+	}
+	switch p.Kind {
+	case ProblemInvalidBlock, ProblemDoubleReference, ProblemUnreachable:
+		s += fmt.Sprintf(" block %d", p.Block)
+	}
+	if p.Err != nil {
+		s += ": " + p.Err.Error()
+	}
+	return s
+}
+
+// CheckReport is the result of a consistency check of a filesystem
+type CheckReport struct {
+	Directories    int   // directories walked, including the root
+	Files          int   // regular files read end-to-end
+	InlineFiles    int   // files stored inline in their directory's metadata
+	BytesRead      int64 // file contents read
+	MetadataBlocks int   // blocks in use that do not hold file contents
+	DataBlocks     int   // blocks holding file contents
+	Orphans        int   // orphaned metadata pairs waiting to be cleaned up
+	PendingMove    bool  // an interrupted rename has not been completed
+
+	Problems []CheckProblem
+}
+
+// OK reports whether the check found no problems
+func (r *CheckReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *CheckReport) problem(kind ProblemKind, path string, block uint32, err error) {
+	r.Problems = append(r.Problems, CheckProblem{Kind: kind, Path: path, Block: block, Err: err})
+}
+
+// Check verifies the consistency of the mounted filesystem. It walks the
+// directory tree, reads every file end-to-end and cross-checks the blocks
+// reached by the directory walk against those reached by Traverse.
+//
+// Check should be run with no files open, as blocks held by open files are
+// likely to be reported as double references. The returned error is only
+// non-nil if the check could not be carried out at all; inconsistencies are
+// reported as problems in the CheckReport.
+func (l *LFS) Check() (*CheckReport, error) {
+	report := &CheckReport{}
+	blockCount := uint32(l.cfg.block_count)
+
+	// count how many times each block is reached by the traversal
+	traversed := map[uint32]int{}
+	err := l.Traverse(func(block uint32) error {
+		traversed[block]++
+		return nil
+	})
+	if err != nil {
+		report.problem(ProblemCorrupt, "", 0, err)
+	}
+
+	// walk the tree, noting which file each data block belongs to
+	owners := map[uint32]string{}
+	if err := l.checkDir(report, "/", owners); err != nil {
+		return nil, err
+	}
+
+	var blocks []uint32
+	for block := range traversed {
+		blocks = append(blocks, block)
+	}
+	sortBlocks(blocks)
+	for _, block := range blocks {
+		if block >= blockCount {
+			report.problem(ProblemInvalidBlock, owners[block], block, nil)
+		} else if traversed[block] > 1 {
+			report.problem(ProblemDoubleReference, owners[block], block, nil)
+		}
+		if _, ok := owners[block]; !ok {
+			report.MetadataBlocks++
+		}
+	}
+	blocks = blocks[:0]
+	for block := range owners {
+		blocks = append(blocks, block)
+	}
+	sortBlocks(blocks)
+	for _, block := range blocks {
+		if _, ok := traversed[block]; !ok && err == nil {
+			report.problem(ProblemUnreachable, owners[block], block, nil)
+		}
+	}
+	report.DataBlocks = len(owners)
+
+	// a non-zero orphan count or pending move is normal after a power loss,
+	// and is fixed the next time the filesystem is written to
+	tag := uint32(l.lfs.gstate.tag)
+	if report.Orphans = int(tag & 0x3ff); report.Orphans > 0 {
+		report.problem(ProblemOrphans, "", 0, nil)
+	}
+	if report.PendingMove = tag&0x70000000 != 0; report.PendingMove {
+		report.problem(ProblemPendingMove, "", 0, nil)
+	}
+
+	return report, nil
+}
+
+// checkDir walks the directory tree below path. Files are checked as littlefs
+// stores them: symbolic links are not followed, the contents of encrypted and
+// compressed files are read as they are, and permissions are not checked.
+func (l *LFS) checkDir(report *CheckReport, path string, owners map[uint32]string) error {
+	dir, err := l.openFile(path, os.O_RDONLY, nil)
+	if err != nil {
+		report.problem(ProblemCorrupt, path, 0, err)
+		return nil
+	}
+	infos, err := dir.Readdir(0)
+	if cerr := dir.Close(); err == nil {
+		err = cerr
+	}
+	report.Directories++
+	if err != nil {
+		report.problem(ProblemCorrupt, path, 0, err)
+	}
+	for _, info := range infos {
+		child := info.Name()
+		if path != "/" {
+			child = path + "/" + child
+		}
+		if info.IsDir() {
+			if err := l.checkDir(report, child, owners); err != nil {
+				return err
+			}
+		} else {
+			l.checkFile(report, child, owners)
+		}
+	}
+	return nil
+}
+
+func (l *LFS) checkFile(report *CheckReport, path string, owners map[uint32]string) {
+	f, err := l.openFile(path, os.O_RDONLY, nil)
+	if err != nil {
+		report.problem(ProblemCorrupt, path, 0, err)
+		return
+	}
+	defer f.Close()
+	report.Files++
+	// the size in the directory entry, rather than the one Readdir reports
+	size := int64(f.fileptr().ctz.size)
+
+	if f.fileptr().flags&C.LFS_F_INLINE != 0 {
+		report.InlineFiles++
+	} else {
+		blocks, err := l.ctzBlocks(uint32(f.fileptr().ctz.head), uint32(f.fileptr().ctz.size))
+		for _, block := range blocks {
+			if owner, ok := owners[block]; ok {
+				report.problem(ProblemDoubleReference, path, block,
+					fmt.Errorf("also used by %s", owner))
+				continue
+			}
+			owners[block] = path
+		}
+		if err == ErrCorrupt {
+			report.problem(ProblemInvalidBlock, path, blocks[len(blocks)-1], err)
+			return
+		} else if err != nil {
+			report.problem(ProblemCorrupt, path, blocks[len(blocks)-1], err)
+			return
+		}
+	}
+
+	buf := make([]byte, uint32(l.cfg.block_size))
+	var n int64
+	for {
+		c, err := f.Read(buf)
+		n += int64(c)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			report.problem(ProblemCorrupt, path, 0, err)
+			break
+		}
+	}
+	report.BytesRead += n
+	if n != size {
+		report.problem(ProblemSizeMismatch, path, 0,
+			fmt.Errorf("read %d bytes, expected %d", n, size))
+	}
+}
+
+// ctzBlocks follows the CTZ skip-list of a file of the given size starting
+// at head, returning every block that holds the file's contents. If an
+// invalid block address is encountered, it is returned as the last block
+// along with an error.
+func (l *LFS) ctzBlocks(head uint32, size uint32) ([]uint32, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	blockSize, blockCount := uint32(l.cfg.block_size), uint32(l.cfg.block_count)
+	var blocks []uint32
+	var ptr [4]byte
+	for index := ctzIndex(blockSize, size-1); ; index-- {
+		blocks = append(blocks, head)
+		if head >= blockCount {
+			return blocks, ErrCorrupt
+		}
+		if index == 0 {
+			return blocks, nil
+		}
+		// the first pointer in each block always points to the previous block
+		if err := l.dev.ReadBlock(head, 0, ptr[:]); err != nil {
+			return blocks, err
+		}
+		head = binary.LittleEndian.Uint32(ptr[:])
+	}
+}
+
+// ctzIndex returns the index of the block in a CTZ skip-list that contains
+// the byte at offset off, as in lfs_ctz_index
+func ctzIndex(blockSize uint32, off uint32) uint32 {
+	b := blockSize - 2*4
+	i := off / b
+	if i == 0 {
+		return 0
+	}
+	return (off - 4*uint32(bits.OnesCount32(i-1)+2)) / b
+}
+
+func sortBlocks(blocks []uint32) {
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+}
+
+// Repair completes any orphan cleanup or rename that was interrupted by a
+// power loss, and then syncs the block device. littlefs would otherwise
+// defer this work until the next time the filesystem is written to.
+func (l *LFS) Repair() (err error) {
+	s := l.begin(OpRepair, "")
+	defer func() { s.end(0, err) }()
+	if l.readonly {
+		return ErrReadOnly
+	}
+	// lfs_mkdir makes the filesystem consistent before it looks up the path,
+	// and since the root always exists it does nothing else
+	cs := cstring("/")
+	defer C.free(unsafe.Pointer(cs))
+	if err := l.callErr(func() C.int { return C.lfs_mkdir(l.lfs, cs) }); err != nil && err != ErrEntryExists {
+		return err
+	}
+	start := l.now()
+	err = l.sync()
+	l.observeBlock(OpBlockSync, 0, 0, start, err)
+	return err
+}
+
+// Fsck mounts the filesystem on blockdev read-only and checks it for
+// consistency; see Check. Nothing is ever written to blockdev.
+func Fsck(config Config, blockdev BlockDevice) (*CheckReport, error) {
+	fs := New(config, blockdev)
+	if err := fs.MountReadOnly(); err != nil {
+		return nil, err
+	}
+	defer fs.Unmount()
+	return fs.Check()
+}
+
+// FsckRepair mounts the filesystem on blockdev, completes any pending orphan
+// cleanup or interrupted rename with Repair, and then checks it for
+// consistency; see Check.
+func FsckRepair(config Config, blockdev BlockDevice) (*CheckReport, error) {
+	fs := New(config, blockdev)
+	if err := fs.Mount(); err != nil {
+		return nil, err
+	}
+	defer fs.Unmount()
+	if err := fs.Repair(); err != nil {
+		return nil, err
+	}
+	return fs.Check()
+}
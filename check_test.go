@@ -0,0 +1,184 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	t.Run("Clean", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("logs"))
+		check(t, fs.Mkdir("logs/old"))
+		writeFileTest(t, fs, 8, "logs/inline")
+		writeFileTest(t, fs, 8192, "logs/old/medium")
+		writeFileTest(t, fs, 65536, "large")
+
+		report, err := fs.Check()
+		check(t, err)
+		if !report.OK() {
+			t.Fatalf("expected no problems; got %v", report.Problems)
+		}
+		if report.Directories != 3 || report.Files != 3 || report.InlineFiles != 1 {
+			t.Fatalf("unexpected counts: %+v", report)
+		}
+		if report.BytesRead != 8+8192+65536 {
+			t.Fatalf("expected to read all file contents; read %d bytes", report.BytesRead)
+		}
+		size, err := fs.Size()
+		check(t, err)
+		if report.MetadataBlocks+report.DataBlocks != size {
+			t.Fatalf("expected %d blocks in use; found %d metadata and %d data blocks",
+				size, report.MetadataBlocks, report.DataBlocks)
+		}
+	})
+
+	t.Run("Special", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("private"))
+		writeFileTest(t, fs, 8192, "private/target")
+		check(t, fs.Symlink("private/target", "link"))
+		check(t, fs.Chmod("private", 0700))
+		f, err := fs.OpenEncrypted("secret", os.O_WRONLY|os.O_CREATE, make([]byte, 32))
+		check(t, err)
+		if _, err := f.Write(make([]byte, 3000)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, f.Close())
+		c, err := fs.OpenCompressed("log", os.O_WRONLY|os.O_CREATE, Deflate)
+		check(t, err)
+		if _, err := c.Write(make([]byte, 3000)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, c.Close())
+
+		// links are not followed, and files are read as they are stored
+		// whatever the credential
+		for _, cred := range []*Credential{nil, {Uid: 1000, Gid: 1000}} {
+			fs.SetCredential(cred)
+			report, err := fs.Check()
+			check(t, err)
+			if !report.OK() {
+				t.Fatalf("expected no problems; got %v", report.Problems)
+			}
+			if report.Files != 4 {
+				t.Fatalf("unexpected counts: %+v", report)
+			}
+		}
+		fs.SetCredential(nil)
+	})
+
+	t.Run("CorruptMetadata", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		check(t, fs.Mkdir("config"))
+		dir, err := fs.Open("config")
+		check(t, err)
+		pair := dir.dirptr().head
+		check(t, dir.Close())
+		unmount()
+
+		for _, block := range pair {
+			check(t, bd.ProgramBlock(uint32(block), 0, zeroBlock))
+		}
+		if _, err := Fsck(defaultConfig, bd); err != ErrCorrupt {
+			t.Fatalf("expected ErrCorrupt; was %v", err)
+		}
+	})
+
+	t.Run("CorruptSkipList", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		writeFileTest(t, fs, 8192, "firmware")
+		f, err := fs.Open("firmware")
+		check(t, err)
+		head := uint32(f.fileptr().ctz.head)
+		check(t, f.Close())
+		unmount()
+
+		// point the last block of the file off the end of the device
+		var ptr [4]byte
+		binary.LittleEndian.PutUint32(ptr[:], defaultConfig.BlockCount+10)
+		check(t, bd.ProgramBlock(head, 0, ptr[:]))
+
+		report, err := Fsck(defaultConfig, bd)
+		check(t, err)
+		found := false
+		for _, problem := range report.Problems {
+			if problem.Kind == ProblemInvalidBlock && problem.Path == "firmware" {
+				found = problem.Block == defaultConfig.BlockCount+10
+			}
+		}
+		if !found {
+			t.Fatalf("expected invalid block in firmware; got %v", report.Problems)
+		}
+	})
+
+	t.Run("RepairOrphans", func(t *testing.T) {
+		// cut the power at every point while removing a directory until the
+		// filesystem is left with an orphan
+		for limit := 0; ; limit++ {
+			fs, bd, unmount := createTestFS(t, defaultConfig)
+			check(t, fs.Mkdir("tmp"))
+			writeFileTest(t, fs, 32, "tmp/scratch")
+			check(t, fs.Remove("tmp/scratch"))
+			unmount()
+
+			dev := &powerCutBlockDevice{BlockDevice: bd, limit: limit}
+			fs = New(defaultConfig, dev)
+			check(t, fs.Mount())
+			fs.Remove("tmp")
+			fs.Unmount()
+			if !dev.cut() {
+				t.Skip("power cut never left an orphan behind")
+			}
+
+			report, err := Fsck(defaultConfig, bd)
+			if err != nil || report.Orphans == 0 {
+				continue
+			}
+			if report.OK() {
+				t.Fatal("expected orphans to be reported as a problem")
+			}
+			report, err = FsckRepair(defaultConfig, bd)
+			check(t, err)
+			if !report.OK() {
+				t.Fatalf("expected repair to fix orphans; got %v", report.Problems)
+			}
+			fs = New(defaultConfig, bd)
+			check(t, fs.Mount())
+			defer fs.Unmount()
+			if _, err := fs.Stat("tmp"); err == nil {
+				t.Fatal("expected tmp to be removed")
+			}
+			return
+		}
+	})
+
+	t.Run("RepairEvents", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		var ops []Op
+		fs.SetObserver(ObserverFunc(func(e Event) {
+			if e.Op == OpRepair || e.Parent == OpRepair && e.Op == OpBlockSync {
+				ops = append(ops, e.Op)
+			}
+		}))
+		check(t, fs.Repair())
+		if len(ops) != 2 || ops[0] != OpBlockSync || ops[1] != OpRepair {
+			t.Fatalf("expected the sync to be reported within the repair; got %v", ops)
+		}
+	})
+
+	t.Run("ReadOnlyRepair", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		unmount()
+		check(t, fs.MountReadOnly())
+		defer fs.Unmount()
+		if err := fs.Repair(); !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("expected permission error; was %v", err)
+		}
+	})
+}
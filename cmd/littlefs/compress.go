@@ -0,0 +1,64 @@
+//go:build !tinygo
+// +build !tinygo
+
+package main
+
+import (
+	"compress/flate"
+	"flag"
+	"fmt"
+	"os"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+func compress(argv []string) error {
+	return convert("compress", argv)
+}
+
+func decompress(argv []string) error {
+	return convert("decompress", argv)
+}
+
+// convert compresses or decompresses files of an image in place
+func convert(name string, argv []string) error {
+	fset := flag.NewFlagSet(name, flag.ExitOnError)
+	blockSize, blockCount := imageFlags(fset)
+	progSize := fset.Uint("prog-size", 16, "size of a program operation in bytes")
+	level := fset.Int("level", flate.DefaultCompression, "DEFLATE compression level")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: littlefs %s [flags] image file...\n", name)
+		fset.PrintDefaults()
+	}
+	fset.Parse(argv)
+	if *blockSize == 0 || *progSize == 0 {
+		fmt.Fprintf(fset.Output(), "-block-size and -prog-size must not be zero\n")
+		fset.Usage()
+		os.Exit(2)
+	}
+	if fset.NArg() < 2 {
+		fset.Usage()
+		os.Exit(2)
+	}
+
+	fs, unmount, err := mountImage(fset.Arg(0), *blockSize, *blockCount, *progSize)
+	if err != nil {
+		return err
+	}
+	codec := lfs.DeflateCodec(*level)
+	for _, path := range fset.Args()[1:] {
+		if name == "compress" {
+			err = fs.CompressFile(path, codec)
+		} else {
+			err = fs.DecompressFile(path, codec)
+		}
+		if err != nil {
+			err = fmt.Errorf("%s: %w", path, err)
+			break
+		}
+	}
+	if uerr := unmount(); err == nil {
+		err = uerr
+	}
+	return err
+}
@@ -0,0 +1,76 @@
+//go:build !tinygo
+// +build !tinygo
+
+package main
+
+import (
+	"os"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+// imageDevice is a block device backed by an image file
+type imageDevice struct {
+	file      *os.File
+	blockSize uint32
+}
+
+func (bd *imageDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	_, err := bd.file.ReadAt(buf, int64(block)*int64(bd.blockSize)+int64(offset))
+	return err
+}
+
+func (bd *imageDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	_, err := bd.file.WriteAt(buf, int64(block)*int64(bd.blockSize)+int64(offset))
+	return err
+}
+
+func (bd *imageDevice) EraseBlock(block uint32) error {
+	buf := make([]byte, bd.blockSize)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	return bd.ProgramBlock(block, 0, buf)
+}
+
+func (bd *imageDevice) Sync() error {
+	return bd.file.Sync()
+}
+
+// mountImage mounts the image at path for writing; the returned function
+// unmounts it and closes the image
+func mountImage(path string, blockSize, blockCount, progSize uint) (*lfs.LFS, func() error, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if blockCount == 0 {
+		st, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		blockCount = uint(st.Size()) / blockSize
+	}
+	config := lfs.Config{
+		ReadSize:      uint32(progSize),
+		ProgSize:      uint32(progSize),
+		BlockSize:     uint32(blockSize),
+		BlockCount:    uint32(blockCount),
+		CacheSize:     uint32(progSize),
+		LookaheadSize: 32,
+		BlockCycles:   -1,
+	}
+	fs := lfs.New(config, &imageDevice{file: file, blockSize: uint32(blockSize)})
+	if err := fs.Mount(); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return fs, func() error {
+		err := fs.Unmount()
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,81 @@
+//go:build !tinygo
+// +build !tinygo
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/bgould/go-littlefs/reader"
+)
+
+// inspect dumps the metadata pairs of an image and, optionally, the CTZ
+// skip-list of a file
+func inspect(argv []string) error {
+	fset := flag.NewFlagSet("inspect", flag.ExitOnError)
+	blockSize, blockCount := imageFlags(fset)
+	file := fset.String("file", "", "also show the blocks holding the contents of this file")
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "usage: littlefs inspect [flags] image\n")
+		fset.PrintDefaults()
+	}
+	fset.Parse(argv)
+	if *blockSize == 0 {
+		fmt.Fprintf(fset.Output(), "-block-size must not be zero\n")
+		fset.Usage()
+		os.Exit(2)
+	}
+	if fset.NArg() != 1 {
+		fset.Usage()
+		os.Exit(2)
+	}
+
+	image, err := os.Open(fset.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	st, err := image.Stat()
+	if err != nil {
+		return err
+	}
+	config := reader.Config{BlockSize: uint32(*blockSize), BlockCount: uint32(*blockCount)}
+	if config.BlockCount == 0 {
+		config.BlockCount = uint32(st.Size() / int64(config.BlockSize))
+	}
+
+	ins, err := reader.InspectImage(image, config)
+	if err != nil {
+		return err
+	}
+	if err := ins.Dump(os.Stdout); err != nil {
+		return err
+	}
+	if *file == "" {
+		return nil
+	}
+
+	fsys, err := reader.MountImage(image, config)
+	if err != nil {
+		return err
+	}
+	layout, err := fsys.Layout(*file)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nfile %s size=%d entry={0x%x, 0x%x} id=%d\n",
+		*file, layout.Size, layout.Pair[0], layout.Pair[1], layout.ID)
+	if layout.Inline {
+		fmt.Printf("  inline\n")
+	}
+	for _, b := range layout.Blocks {
+		fmt.Printf("  %6d: block 0x%x", b.Index, b.Block)
+		for i, p := range b.Pointers {
+			fmt.Printf(" -%d:0x%x", 1<<i, p)
+		}
+		fmt.Printf("\n")
+	}
+	return nil
+}
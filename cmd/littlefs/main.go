@@ -0,0 +1,55 @@
+//go:build !tinygo
+// +build !tinygo
+
+// Command littlefs is a host tool for examining and modifying littlefs
+// images.
+//
+//	littlefs inspect -block-size 4096 [-file path] image.bin
+//	littlefs compress -block-size 4096 image.bin path...
+//	littlefs decompress -block-size 4096 image.bin path...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+var commands = map[string]cmdfunc{
+	"compress":   compress,
+	"decompress": decompress,
+	"inspect":    inspect,
+}
+
+type cmdfunc func(argv []string) error
+
+func main() {
+	if len(os.Args) < 2 || commands[os.Args[1]] == nil {
+		usage()
+		os.Exit(2)
+	}
+	if err := commands[os.Args[1]](os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "littlefs %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "usage: littlefs <command> [arguments]\n\ncommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s\n", name)
+	}
+}
+
+// imageFlags registers the flags describing the geometry of an image
+func imageFlags(fset *flag.FlagSet) (blockSize, blockCount *uint) {
+	blockSize = fset.Uint("block-size", 4096, "size of an erasable block in bytes")
+	blockCount = fset.Uint("block-count", 0, "number of blocks; defaults to the image size divided by the block size")
+	return
+}
@@ -0,0 +1,198 @@
+package lfs
+
+import (
+	"context"
+)
+
+// ContextBlockDevice is implemented by block devices that can abandon a slow
+// operation when the context of the filesystem operation that caused it is
+// done. When the filesystem is used through one of the *Context methods, the
+// context is passed to these methods in place of the ones of BlockDevice.
+type ContextBlockDevice interface {
+	BlockDevice
+	ReadBlockContext(ctx context.Context, block uint32, offset uint32, buf []byte) error
+	ProgramBlockContext(ctx context.Context, block uint32, offset uint32, buf []byte) error
+	EraseBlockContext(ctx context.Context, block uint32) error
+	SyncContext(ctx context.Context) error
+}
+
+// withContext runs fn, which calls into littlefs, such that the block device
+// callbacks fail once ctx is done. littlefs treats the failure like any other
+// device error and stops where it is; since a partially written commit is
+// ignored just as it would be after a power loss, the filesystem remains
+// consistent. If the operation failed because ctx is done, the error of the
+// context is returned rather than ErrIO.
+func (l *LFS) withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.runContext(ctx, fn)
+}
+
+// runContext is like withContext, but runs fn even if ctx is already done
+func (l *LFS) runContext(ctx context.Context, fn func() error) error {
+	prev, prevErr := l.ctx, l.ctxErr
+	l.ctx, l.ctxErr = ctx, nil
+	defer func() { l.ctx, l.ctxErr = prev, prevErr }()
+	if err := fn(); err != nil {
+		if l.ctxErr != nil {
+			return l.ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+// done checks the context of the operation in progress before a block device
+// callback, recording its error if it is done
+func (l *LFS) done() error {
+	if l.ctx == nil {
+		return nil
+	}
+	if err := l.ctx.Err(); err != nil {
+		l.ctxErr = err
+		return err
+	}
+	return nil
+}
+
+// failed records the error of the context if a ContextBlockDevice gave up on
+// an operation because it is done
+func (l *LFS) failed(err error) error {
+	if err != nil && l.ctx.Err() != nil {
+		l.ctxErr = l.ctx.Err()
+	}
+	return err
+}
+
+// readBlock, programBlock, eraseBlock and sync pass the block device
+// callbacks of littlefs on to the block device, aborting them if the context
+// of the operation in progress is done
+
+func (l *LFS) readBlock(block uint32, offset uint32, buf []byte) error {
+	if err := l.done(); err != nil {
+		return err
+	}
+	if dev, ok := l.dev.(ContextBlockDevice); ok && l.ctx != nil {
+		return l.failed(dev.ReadBlockContext(l.ctx, block, offset, buf))
+	}
+	return l.dev.ReadBlock(block, offset, buf)
+}
+
+func (l *LFS) programBlock(block uint32, offset uint32, buf []byte) error {
+	if err := l.done(); err != nil {
+		return err
+	}
+	if dev, ok := l.dev.(ContextBlockDevice); ok && l.ctx != nil {
+		return l.failed(dev.ProgramBlockContext(l.ctx, block, offset, buf))
+	}
+	return l.dev.ProgramBlock(block, offset, buf)
+}
+
+func (l *LFS) eraseBlock(block uint32) error {
+	if err := l.done(); err != nil {
+		return err
+	}
+	if dev, ok := l.dev.(ContextBlockDevice); ok && l.ctx != nil {
+		return l.failed(dev.EraseBlockContext(l.ctx, block))
+	}
+	return l.dev.EraseBlock(block)
+}
+
+func (l *LFS) sync() error {
+	if err := l.done(); err != nil {
+		return err
+	}
+	if dev, ok := l.dev.(ContextBlockDevice); ok && l.ctx != nil {
+		return l.failed(dev.SyncContext(l.ctx))
+	}
+	return l.dev.Sync()
+}
+
+// FormatContext is like Format, but gives up when ctx is done
+func (l *LFS) FormatContext(ctx context.Context) error {
+	return l.withContext(ctx, l.Format)
+}
+
+// MountContext is like Mount, but gives up when ctx is done
+func (l *LFS) MountContext(ctx context.Context) error {
+	return l.withContext(ctx, l.Mount)
+}
+
+// RemoveContext is like Remove, but gives up when ctx is done
+func (l *LFS) RemoveContext(ctx context.Context, path string) error {
+	return l.withContext(ctx, func() error {
+		return l.Remove(path)
+	})
+}
+
+// RenameContext is like Rename, but gives up when ctx is done
+func (l *LFS) RenameContext(ctx context.Context, oldPath string, newPath string) error {
+	return l.withContext(ctx, func() error {
+		return l.Rename(oldPath, newPath)
+	})
+}
+
+// MkdirContext is like Mkdir, but gives up when ctx is done
+func (l *LFS) MkdirContext(ctx context.Context, path string) error {
+	return l.withContext(ctx, func() error {
+		return l.Mkdir(path)
+	})
+}
+
+// StatContext is like Stat, but gives up when ctx is done
+func (l *LFS) StatContext(ctx context.Context, path string) (info *Info, err error) {
+	err = l.withContext(ctx, func() (err error) {
+		info, err = l.Stat(path)
+		return err
+	})
+	return info, err
+}
+
+// OpenFileContext is like OpenFile, but gives up when ctx is done. The
+// context only applies to opening the file, not to later operations on it.
+func (l *LFS) OpenFileContext(ctx context.Context, path string, flags int) (f *File, err error) {
+	err = l.withContext(ctx, func() (err error) {
+		f, err = l.OpenFile(path, flags)
+		return err
+	})
+	return f, err
+}
+
+// ReadContext is like Read, but gives up when ctx is done
+func (f *File) ReadContext(ctx context.Context, buf []byte) (n int, err error) {
+	err = f.lfs.withContext(ctx, func() (err error) {
+		n, err = f.Read(buf)
+		return err
+	})
+	return n, err
+}
+
+// WriteContext is like Write, but gives up when ctx is done. Data written
+// before giving up may or may not be in the file, and the file must be
+// closed, as after any other error.
+func (f *File) WriteContext(ctx context.Context, buf []byte) (n int, err error) {
+	err = f.lfs.withContext(ctx, func() (err error) {
+		n, err = f.Write(buf)
+		return err
+	})
+	return n, err
+}
+
+// SyncContext is like Sync, but gives up when ctx is done
+func (f *File) SyncContext(ctx context.Context) error {
+	return f.lfs.withContext(ctx, f.Sync)
+}
+
+// TruncateContext is like Truncate, but gives up when ctx is done
+func (f *File) TruncateContext(ctx context.Context, size uint32) error {
+	return f.lfs.withContext(ctx, func() error {
+		return f.Truncate(size)
+	})
+}
+
+// CloseContext is like Close, but gives up writing out pending data when ctx
+// is done. The file is closed regardless.
+func (f *File) CloseContext(ctx context.Context) error {
+	return f.lfs.runContext(ctx, f.Close)
+}
@@ -0,0 +1,199 @@
+package lfs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	t.Run("Done", func(t *testing.T) {
+		dev := &countingBlockDevice{BlockDevice: NewMemoryDevice(defaultConfig)}
+		fs := New(defaultConfig, dev)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := fs.FormatContext(ctx); err != context.Canceled {
+			t.Fatalf("expected context.Canceled; was %v", err)
+		}
+		if dev.programs != 0 || dev.erases != 0 {
+			t.Fatalf("expected no writes; had %d programs and %d erases", dev.programs, dev.erases)
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		fs.dev = &slowBlockDevice{BlockDevice: bd, delay: time.Millisecond}
+		f, err := fs.OpenFile("slow", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		buf := make([]byte, 512)
+		for err == nil {
+			_, err = f.WriteContext(ctx, buf)
+		}
+		if err != context.DeadlineExceeded {
+			t.Fatalf("expected context.DeadlineExceeded; was %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("took %v to give up", elapsed)
+		}
+		f.Close()
+	})
+
+	// cancelling at every point of writing a file must leave the filesystem
+	// consistent, with the file either as it was or as it was written
+	t.Run("Consistency", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		writeFileTest(t, fs, 1500, "file")
+		unmount()
+
+		for after := 1; ; after++ {
+			ctx, cancel := context.WithCancel(context.Background())
+			dev := &cancellingBlockDevice{BlockDevice: bd, cancel: cancel, after: after}
+			fs := New(defaultConfig, dev)
+			check(t, fs.Mount())
+			err := writeFileContext(ctx, fs, "file", 2048)
+			if err == nil {
+				check(t, fs.Unmount())
+				break
+			}
+			if err != context.Canceled {
+				t.Fatalf("after %d programs: expected context.Canceled; was %v", after, err)
+			}
+			report, err := fs.Check()
+			check(t, err)
+			if !report.OK() {
+				t.Fatalf("after %d programs: %v", after, report.Problems)
+			}
+			check(t, fs.Unmount())
+
+			fs = New(defaultConfig, bd)
+			check(t, fs.Mount())
+			info, err := fs.Stat("file")
+			check(t, err)
+			if info.Size() != 1500 && info.Size() != 2048 {
+				t.Fatalf("after %d programs: unexpected size %d", after, info.Size())
+			}
+			readFileTest(t, fs, int(info.Size()), "file")
+			// restore the original contents for the next iteration
+			check(t, fs.Remove("file"))
+			writeFileTest(t, fs, 1500, "file")
+			check(t, fs.Unmount())
+		}
+	})
+
+	t.Run("ContextBlockDevice", func(t *testing.T) {
+		type key struct{}
+		dev := &contextBlockDevice{BlockDevice: NewMemoryDevice(defaultConfig)}
+		fs := New(defaultConfig, dev)
+		ctx := context.WithValue(context.Background(), key{}, "format")
+		check(t, fs.FormatContext(ctx))
+		if dev.ctx == nil || dev.ctx.Value(key{}) != "format" {
+			t.Fatal("expected the context to be passed to the block device")
+		}
+		check(t, fs.Mount())
+		defer fs.Unmount()
+
+		dev.err = errors.New("device gave up")
+		ctx, cancel := context.WithCancel(context.Background())
+		dev.cancel = cancel
+		if err := fs.MkdirContext(ctx, "dir"); err != context.Canceled {
+			t.Fatalf("expected context.Canceled; was %v", err)
+		}
+		dev.err = nil
+		check(t, fs.Mkdir("dir"))
+	})
+}
+
+func writeFileContext(ctx context.Context, fs *LFS, name string, size int) error {
+	f, err := fs.OpenFileContext(ctx, name, os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 32)
+	for i := 0; i < size; i += len(buf) {
+		for j := range buf {
+			buf[j] = byte(i + j)
+		}
+		if _, err := f.WriteContext(ctx, buf); err != nil {
+			f.CloseContext(ctx)
+			return err
+		}
+	}
+	return f.CloseContext(ctx)
+}
+
+// slowBlockDevice sleeps before every erase, like a slow flash chip
+type slowBlockDevice struct {
+	BlockDevice
+	delay time.Duration
+}
+
+func (bd *slowBlockDevice) EraseBlock(block uint32) error {
+	time.Sleep(bd.delay)
+	return bd.BlockDevice.EraseBlock(block)
+}
+
+// cancellingBlockDevice cancels a context once the first after programs and
+// erases have been performed
+type cancellingBlockDevice struct {
+	BlockDevice
+	cancel func()
+	after  int
+	count  int
+}
+
+func (bd *cancellingBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if bd.count++; bd.count == bd.after {
+		bd.cancel()
+	}
+	return bd.BlockDevice.ProgramBlock(block, offset, buf)
+}
+
+func (bd *cancellingBlockDevice) EraseBlock(block uint32) error {
+	if bd.count++; bd.count == bd.after {
+		bd.cancel()
+	}
+	return bd.BlockDevice.EraseBlock(block)
+}
+
+// contextBlockDevice records the context it is passed; if err is set, it
+// cancels the context and fails the first program or erase
+type contextBlockDevice struct {
+	BlockDevice
+	ctx    context.Context
+	cancel func()
+	err    error
+}
+
+func (bd *contextBlockDevice) ReadBlockContext(ctx context.Context, block uint32, offset uint32, buf []byte) error {
+	bd.ctx = ctx
+	return bd.ReadBlock(block, offset, buf)
+}
+
+func (bd *contextBlockDevice) ProgramBlockContext(ctx context.Context, block uint32, offset uint32, buf []byte) error {
+	bd.ctx = ctx
+	if bd.err != nil {
+		bd.cancel()
+		return bd.err
+	}
+	return bd.ProgramBlock(block, offset, buf)
+}
+
+func (bd *contextBlockDevice) EraseBlockContext(ctx context.Context, block uint32) error {
+	bd.ctx = ctx
+	if bd.err != nil {
+		bd.cancel()
+		return bd.err
+	}
+	return bd.EraseBlock(block)
+}
+
+func (bd *contextBlockDevice) SyncContext(ctx context.Context) error {
+	bd.ctx = ctx
+	return bd.Sync()
+}
@@ -0,0 +1,249 @@
+package lfs
+
+import (
+	"io"
+	"os"
+)
+
+// chunkedFile implements reading, writing and seeking for files whose
+// contents are transformed a chunk at a time before they are stored, such as
+// those opened with OpenEncrypted and OpenCompressed. The current chunk is
+// kept in memory, and handed to the chunkStore when another chunk is needed
+// or the file is synced.
+type chunkedFile struct {
+	f        *File
+	attr     *fileAttr
+	store    chunkStore
+	writable bool
+	append   bool
+
+	chunkSize uint32
+	size      int64
+	pos       int64
+
+	// chunk holds the contents of chunk index, which has changes that have
+	// not been stored yet if dirty is set
+	chunk []byte
+	index int64
+	dirty bool
+}
+
+// chunkStore transforms the chunks of a chunkedFile to and from the
+// underlying file
+type chunkStore interface {
+	// loadChunk appends the n bytes of chunk index to dst
+	loadChunk(dst []byte, index int64, n int64) ([]byte, error)
+
+	// storeChunk replaces chunk index, or adds it after the last one
+	storeChunk(index int64, data []byte) error
+
+	// encodeHeader updates the header attribute before it is committed
+	encodeHeader()
+}
+
+// open opens the underlying file with attr as its header attribute. The
+// underlying file is always readable, since writes read the rest of their
+// chunk and littlefs only reads the attributes of files opened for reading;
+// appending is done here, since chunks are not written in order.
+func (c *chunkedFile) open(l *LFS, path string, flags int, attr *fileAttr) error {
+	c.attr = attr
+	c.writable = flags&(os.O_WRONLY|os.O_RDWR) != 0
+	c.append = flags&os.O_APPEND != 0
+	c.index = -1
+	if c.writable {
+		flags = flags&^(os.O_WRONLY|os.O_APPEND) | os.O_RDWR
+	}
+	path, err := l.resolve(path, followLast(flags))
+	if err != nil {
+		return err
+	}
+	f, err := l.openResolved(path, flags, []*fileAttr{attr})
+	if err != nil {
+		return err
+	}
+	if f.IsDir() {
+		f.Close()
+		return ErrIsDir
+	}
+	c.f = f
+	return nil
+}
+
+// Name returns the name of the file as it was opened
+func (c *chunkedFile) Name() string {
+	return c.f.Name()
+}
+
+// Size returns the size of the contents of the file
+func (c *chunkedFile) Size() int64 {
+	return c.size
+}
+
+func (c *chunkedFile) Read(buf []byte) (n int, err error) {
+	for len(buf) > 0 && c.pos < c.size {
+		if err := c.load(c.pos / int64(c.chunkSize)); err != nil {
+			return n, err
+		}
+		m := copy(buf, c.chunk[c.pos%int64(c.chunkSize):])
+		buf = buf[m:]
+		c.pos += int64(m)
+		n += m
+	}
+	if n == 0 && len(buf) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (c *chunkedFile) Write(buf []byte) (n int, err error) {
+	if !c.writable {
+		return 0, ErrInvalidParam
+	}
+	if c.append {
+		c.pos = c.size
+	}
+	// fill any gap left by seeking past the end with zeros
+	for c.size < c.pos {
+		gap := min(c.pos-c.size, int64(c.chunkSize)-c.size%int64(c.chunkSize))
+		if err := c.write(make([]byte, gap), c.size); err != nil {
+			return 0, err
+		}
+	}
+	for len(buf) > 0 {
+		m := min(len(buf), int(int64(c.chunkSize)-c.pos%int64(c.chunkSize)))
+		if err := c.write(buf[:m], c.pos); err != nil {
+			return n, err
+		}
+		buf = buf[m:]
+		c.pos += int64(m)
+		n += m
+	}
+	return n, nil
+}
+
+// write copies buf, which must not cross a chunk boundary, into the file at
+// off
+func (c *chunkedFile) write(buf []byte, off int64) error {
+	if err := c.load(off / int64(c.chunkSize)); err != nil {
+		return err
+	}
+	start := int(off % int64(c.chunkSize))
+	if end := start + len(buf); end > len(c.chunk) {
+		c.chunk = c.chunk[:end]
+	}
+	copy(c.chunk[start:], buf)
+	c.dirty = true
+	if end := off + int64(len(buf)); end > c.size {
+		c.size = end
+	}
+	return nil
+}
+
+// load makes chunk index the current one, storing the previous one first if
+// it has changed
+func (c *chunkedFile) load(index int64) error {
+	if index == c.index {
+		return nil
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if c.chunk == nil {
+		c.chunk = make([]byte, 0, c.chunkSize)
+	}
+	c.chunk = c.chunk[:0]
+	c.index = index
+	start := index * int64(c.chunkSize)
+	if start >= c.size {
+		return nil
+	}
+	chunk, err := c.store.loadChunk(c.chunk, index, min(c.size-start, int64(c.chunkSize)))
+	if err != nil {
+		c.index = -1
+		return err
+	}
+	c.chunk = chunk
+	return nil
+}
+
+// flush stores the current chunk if it has changed
+func (c *chunkedFile) flush() error {
+	if !c.dirty {
+		return nil
+	}
+	if err := c.store.storeChunk(c.index, c.chunk); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Seek changes the position of the file; seeking past the end is allowed,
+// and the gap is filled with zeros when the file is next written
+func (c *chunkedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += c.pos
+	case io.SeekEnd:
+		offset += c.size
+	case io.SeekStart:
+	default:
+		return c.pos, ErrInvalidParam
+	}
+	if offset < 0 {
+		return c.pos, ErrInvalidParam
+	}
+	c.pos = offset
+	return offset, nil
+}
+
+// Sync stores any buffered changes and commits them to storage along with
+// the header of the file
+func (c *chunkedFile) Sync() error {
+	if !c.writable {
+		return nil
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	c.store.encodeHeader()
+	return c.f.Sync()
+}
+
+// Close commits any buffered changes, like Sync, and closes the file
+func (c *chunkedFile) Close() error {
+	if c.writable {
+		if err := c.flush(); err != nil {
+			c.f.Close()
+			return err
+		}
+		c.store.encodeHeader()
+	}
+	return c.f.Close()
+}
+
+// readAt reads exactly len(buf) bytes of the underlying file at off
+func (c *chunkedFile) readAt(buf []byte, off int64) error {
+	if len(buf) == 0 {
+		return nil
+	}
+	if _, err := c.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(c.f, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrCorrupt
+		}
+		return err
+	}
+	return nil
+}
+
+// writeAt writes buf to the underlying file at off
+func (c *chunkedFile) writeAt(buf []byte, off int64) error {
+	if _, err := c.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := c.f.Write(buf)
+	return err
+}
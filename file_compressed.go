@@ -0,0 +1,313 @@
+package lfs
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// ErrNotCompressed is returned by OpenCompressed for an existing file which
+// was not written with OpenCompressed
+var ErrNotCompressed = errors.New("littlefs: file is not compressed")
+
+// Codec compresses the chunks of files opened with OpenCompressed
+type Codec interface {
+	// ID identifies the codec in the header of the files it compressed; it
+	// must not be zero
+	ID() uint8
+
+	// Compress appends the compressed form of src to dst
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed form of src to dst
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+// Deflate is a Codec using DEFLATE at the default compression level
+var Deflate Codec = DeflateCodec(flate.DefaultCompression)
+
+// DeflateCodec returns a Codec using DEFLATE at the given compression level,
+// as understood by compress/flate. Files compressed at any level can be read
+// with any other.
+func DeflateCodec(level int) Codec {
+	return deflateCodec{level: level}
+}
+
+type deflateCodec struct {
+	level int
+}
+
+func (deflateCodec) ID() uint8 {
+	return 1
+}
+
+func (c deflateCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (deflateCodec) Decompress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	r := flate.NewReader(bytes.NewReader(src))
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, ErrCorrupt
+	}
+	return buf.Bytes(), r.Close()
+}
+
+// A compressed file starts with a header stored in its AttrCompression
+// attribute, which is committed atomically with the contents of the file:
+//
+//	version   uint8    compressedVersion
+//	codec     uint8    ID of the Codec
+//	reserved  [2]byte
+//	chunkSize uint32   uncompressed size of each chunk
+//	size      uint64   uncompressed size of the whole file
+//
+// All integers are little-endian. The contents of the file are a sequence of
+// chunks, each of which is a uint32 giving the length of its data, with the
+// top bit set if the data is stored as it is because it did not compress,
+// followed by the data. Only the last chunk may hold less than chunkSize
+// bytes once uncompressed.
+const (
+	compressedVersion    = 1
+	compressedHeaderSize = 16
+	compressedChunkSize  = 4096
+	compressedFrameSize  = 4
+	compressedStored     = 1 << 31
+)
+
+// CompressedFile is a file opened with OpenCompressed, whose contents are
+// compressed a chunk at a time, so that it can be read and written at any
+// position without decompressing the whole file. Rewriting a chunk that is
+// not the last may move the chunks after it, if its compressed size changes.
+//
+// Changes are buffered a chunk at a time, and become visible on the device,
+// together with the new header, when the file is synced or closed.
+type CompressedFile struct {
+	chunkedFile
+	codec Codec
+
+	// offsets holds the offset of each chunk in the underlying file, followed
+	// by the size of the underlying file
+	offsets []int64
+}
+
+// OpenCompressed opens the named file like OpenFile, compressing everything
+// written to it with codec. Stat and Readdir report the uncompressed size of
+// the file.
+//
+// Opening an existing file fails with ErrNotCompressed if it was not written
+// with OpenCompressed, and with ErrInvalidParam if it was compressed with a
+// different codec.
+func (l *LFS) OpenCompressed(path string, flags int, codec Codec) (*CompressedFile, error) {
+	cf := &CompressedFile{codec: codec}
+	cf.store = cf
+	attr := &fileAttr{typ: AttrCompression, buf: make([]byte, compressedHeaderSize)}
+	if err := cf.open(l, path, flags, attr); err != nil {
+		return nil, err
+	}
+	if err := cf.init(flags&os.O_TRUNC != 0); err != nil {
+		cf.f.Close()
+		return nil, err
+	}
+	return cf, nil
+}
+
+// init decodes the header of the file and finds its chunks, or starts a new
+// header if the file is empty and opened for writing
+func (cf *CompressedFile) init(trunc bool) error {
+	raw, err := cf.f.Size()
+	if err != nil {
+		return err
+	}
+	hdr := cf.attr.buf
+	if hdr[0] == 0 || trunc {
+		if raw != 0 || !cf.writable {
+			return ErrNotCompressed
+		}
+		cf.chunkSize = compressedChunkSize
+		cf.offsets = []int64{0}
+		return nil
+	}
+	if hdr[0] != compressedVersion {
+		return ErrNotCompressed
+	}
+	if hdr[1] != cf.codec.ID() {
+		return ErrInvalidParam
+	}
+	cf.chunkSize = binary.LittleEndian.Uint32(hdr[4:])
+	cf.size = int64(binary.LittleEndian.Uint64(hdr[8:]))
+	if cf.chunkSize == 0 {
+		return ErrCorrupt
+	}
+	chunks := (cf.size + int64(cf.chunkSize) - 1) / int64(cf.chunkSize)
+	cf.offsets = make([]int64, 1, chunks+1)
+	var frame [compressedFrameSize]byte
+	for off := int64(0); int64(len(cf.offsets)) <= chunks; {
+		if err := cf.readAt(frame[:], off); err != nil {
+			return err
+		}
+		off += compressedFrameSize + int64(binary.LittleEndian.Uint32(frame[:])&^compressedStored)
+		cf.offsets = append(cf.offsets, off)
+	}
+	if cf.offsets[chunks] != raw {
+		return ErrCorrupt
+	}
+	return nil
+}
+
+func (cf *CompressedFile) loadChunk(dst []byte, index int64, n int64) ([]byte, error) {
+	frame := make([]byte, cf.offsets[index+1]-cf.offsets[index])
+	if err := cf.readAt(frame, cf.offsets[index]); err != nil {
+		return nil, err
+	}
+	data := frame[compressedFrameSize:]
+	var chunk []byte
+	if binary.LittleEndian.Uint32(frame)&compressedStored != 0 {
+		chunk = append(dst, data...)
+	} else {
+		var err error
+		if chunk, err = cf.codec.Decompress(dst, data); err != nil {
+			return nil, err
+		}
+	}
+	if int64(len(chunk)-len(dst)) != n {
+		return nil, ErrCorrupt
+	}
+	return chunk, nil
+}
+
+func (cf *CompressedFile) storeChunk(index int64, data []byte) error {
+	frame, err := cf.codec.Compress(make([]byte, compressedFrameSize, compressedFrameSize+len(data)), data)
+	if err != nil {
+		return err
+	}
+	length := uint32(len(frame) - compressedFrameSize)
+	if len(frame) >= len(data)+compressedFrameSize {
+		frame = append(frame[:compressedFrameSize], data...)
+		length = uint32(len(data)) | compressedStored
+	}
+	binary.LittleEndian.PutUint32(frame, length)
+
+	start := cf.offsets[index]
+	if index == int64(len(cf.offsets))-1 {
+		// a new chunk at the end
+		cf.offsets = append(cf.offsets, start+int64(len(frame)))
+		return cf.writeAt(frame, start)
+	}
+	end := cf.offsets[index+1]
+	delta := start + int64(len(frame)) - end
+	if delta == 0 {
+		return cf.writeAt(frame, start)
+	}
+	// the chunks after this one have to move
+	last := cf.offsets[len(cf.offsets)-1]
+	tail := make([]byte, last-end)
+	if err := cf.readAt(tail, end); err != nil {
+		return err
+	}
+	if err := cf.writeAt(append(frame, tail...), start); err != nil {
+		return err
+	}
+	if delta < 0 {
+		if err := cf.f.Truncate(uint32(last + delta)); err != nil {
+			return err
+		}
+	}
+	for i := index + 1; i < int64(len(cf.offsets)); i++ {
+		cf.offsets[i] += delta
+	}
+	return nil
+}
+
+func (cf *CompressedFile) encodeHeader() {
+	hdr := cf.attr.buf
+	hdr[0] = compressedVersion
+	hdr[1] = cf.codec.ID()
+	binary.LittleEndian.PutUint32(hdr[4:], cf.chunkSize)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(cf.size))
+}
+
+// compressedSize returns the uncompressed size of path, if it was written
+// with OpenCompressed
+func (l *LFS) compressedSize(path string) (uint32, bool) {
+	var hdr [compressedHeaderSize]byte
+	if n, err := l.Getattr(path, AttrCompression, hdr[:]); err != nil || n != len(hdr) || hdr[0] != compressedVersion {
+		return 0, false
+	}
+	return uint32(binary.LittleEndian.Uint64(hdr[8:])), true
+}
+
+// CompressFile compresses the existing file at path in place with codec. The
+// compressed copy is written alongside the file and then renamed over it, so
+// a power loss leaves either the original or the compressed file. Files that
+// are already compressed are left as they are.
+func (l *LFS) CompressFile(path string, codec Codec) error {
+	if _, ok := l.compressedSize(path); ok {
+		return nil
+	}
+	src, err := l.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + compressTempSuffix
+	dst, err := l.OpenCompressed(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, codec)
+	if err != nil {
+		return err
+	}
+	return l.replaceWith(path, tmp, dst, src)
+}
+
+// DecompressFile replaces the file at path, which was written with
+// OpenCompressed using codec, with its uncompressed contents, in the same way
+// as CompressFile
+func (l *LFS) DecompressFile(path string, codec Codec) error {
+	src, err := l.OpenCompressed(path, os.O_RDONLY, codec)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + compressTempSuffix
+	dst, err := l.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	return l.replaceWith(path, tmp, dst, src)
+}
+
+// compressTempSuffix names the copy made by CompressFile and DecompressFile
+const compressTempSuffix = ".lfsz~"
+
+// replaceWith copies src to dst, which is the file tmp, and renames tmp over
+// path with the permissions of path; tmp is removed if anything fails
+func (l *LFS) replaceWith(path, tmp string, dst io.WriteCloser, src io.Reader) error {
+	_, err := io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = l.keepPermissions(path, tmp)
+	}
+	if err == nil {
+		err = l.Rename(tmp, path)
+	}
+	if err != nil {
+		l.Remove(tmp)
+	}
+	return err
+}
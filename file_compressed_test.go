@@ -0,0 +1,171 @@
+package lfs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestCompressedFile(t *testing.T) {
+	fs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	var text bytes.Buffer
+	for i := 0; text.Len() < 20000; i++ {
+		fmt.Fprintf(&text, "line %d of a rather repetitive log file\n", i)
+	}
+	data := text.Bytes()
+
+	writeCompressed := func(t *testing.T, name string, data []byte) {
+		f, err := fs.OpenCompressed(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, Deflate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readCompressed := func(t *testing.T, name string) []byte {
+		f, err := fs.OpenCompressed(name, os.O_RDONLY, Deflate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	rawSize := func(t *testing.T, name string) int64 {
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		size, err := f.Size()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return size
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		writeCompressed(t, "log", data)
+		if !bytes.Equal(readCompressed(t, "log"), data) {
+			t.Fatal("contents differ after reading back")
+		}
+		if raw := rawSize(t, "log"); raw >= int64(len(data))/2 {
+			t.Errorf("expected %d bytes to compress better than %d", len(data), raw)
+		}
+		info, err := fs.Stat("log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Errorf("expected Stat to report %d bytes; got %d", len(data), info.Size())
+		}
+	})
+
+	t.Run("Incompressible", func(t *testing.T) {
+		random := make([]byte, 5000)
+		rand.New(rand.NewSource(1)).Read(random)
+		writeCompressed(t, "random", random)
+		if !bytes.Equal(readCompressed(t, "random"), random) {
+			t.Fatal("contents differ after reading back")
+		}
+		// each chunk is stored with only its length in front
+		if raw := rawSize(t, "random"); raw != int64(len(random))+2*compressedFrameSize {
+			t.Errorf("expected %d bytes to be stored in %d; got %d",
+				len(random), len(random)+2*compressedFrameSize, raw)
+		}
+	})
+
+	t.Run("Short", func(t *testing.T) {
+		for _, short := range [][]byte{{'x'}, []byte("abc")} {
+			writeCompressed(t, "short", short)
+			if got := readCompressed(t, "short"); !bytes.Equal(got, short) {
+				t.Errorf("expected %q; got %q", short, got)
+			}
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		writeCompressed(t, "seek", data)
+		f, err := fs.OpenCompressed("seek", os.O_RDWR, Deflate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]byte(nil), data...)
+		// rewrite the first chunk with something that compresses differently,
+		// so that the chunks after it have to move
+		noise := make([]byte, 1000)
+		rand.New(rand.NewSource(2)).Read(noise)
+		if _, err := f.Seek(100, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		f.Write(noise)
+		copy(want[100:], noise)
+		if _, err := f.Seek(10000, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 100)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, want[10000:10100]) {
+			t.Error("unexpected contents read back before closing")
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readCompressed(t, "seek"), want) {
+			t.Error("unexpected contents read back after closing")
+		}
+	})
+
+	t.Run("InPlace", func(t *testing.T) {
+		f, err := fs.OpenFile("plain", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(data)
+		f.Close()
+		if err := fs.CompressFile("plain", Deflate); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(readCompressed(t, "plain"), data) {
+			t.Fatal("contents differ after compressing")
+		}
+		if err := fs.DecompressFile("plain", Deflate); err != nil {
+			t.Fatal(err)
+		}
+		if raw := rawSize(t, "plain"); raw != int64(len(data)) {
+			t.Errorf("expected %d bytes after decompressing; got %d", len(data), raw)
+		}
+		if err := fs.DecompressFile("plain", Deflate); err != ErrNotCompressed {
+			t.Errorf("expected ErrNotCompressed; got %v", err)
+		}
+		if _, err := fs.Stat("plain" + compressTempSuffix); err != ErrNoEntry {
+			t.Errorf("expected temporary file to be removed; got %v", err)
+		}
+	})
+
+	t.Run("Codec", func(t *testing.T) {
+		if _, err := fs.OpenCompressed("log", os.O_RDONLY, otherCodec{}); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+}
+
+type otherCodec struct{ deflateCodec }
+
+func (otherCodec) ID() uint8 {
+	return 2
+}
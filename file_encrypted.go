@@ -0,0 +1,256 @@
+package lfs
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// ErrTampered is returned by an EncryptedFile whose contents or header do
+	// not authenticate, because they were modified outside of OpenEncrypted
+	// or the wrong key was given
+	ErrTampered = errors.New("littlefs: encrypted file has been tampered with")
+
+	// ErrNotEncrypted is returned by OpenEncrypted for an existing file which
+	// was not written with OpenEncrypted
+	ErrNotEncrypted = errors.New("littlefs: file is not encrypted")
+)
+
+// An encrypted file starts with a header stored in its AttrEncryption
+// attribute, which is committed atomically with the contents of the file:
+//
+//	version   uint8    encryptedVersion
+//	reserved  [3]byte
+//	chunkSize uint32   size of the plaintext of each chunk
+//	size      uint64   size of the plaintext of the whole file
+//	nonce     [16]byte random, chosen when the file is created or truncated
+//	mac       [32]byte HMAC-SHA256 of everything before it, followed by the
+//	                   tags of all the chunks
+//
+// All integers are little-endian. The contents of the file are a sequence of
+// chunks, each of which is the 12 byte nonce it was sealed with followed by
+// the AES-GCM ciphertext and tag of chunkSize bytes of plaintext; only the
+// last chunk may be shorter. The index of the chunk and the nonce of the file
+// are authenticated along with each chunk, so chunks cannot be moved around
+// within or between files, and the tags of the chunks along with the header,
+// so a chunk cannot be replaced by an older copy of itself either. The tags
+// are read from every chunk when the file is opened.
+const (
+	encryptedVersion    = 2
+	encryptedHeaderSize = 64
+	encryptedChunkSize  = 1024
+	encryptedNonceSize  = 12
+	encryptedTagSize    = 16
+	encryptedOverhead   = encryptedNonceSize + encryptedTagSize
+)
+
+// EncryptedFile is a file opened with OpenEncrypted, whose contents are
+// encrypted and authenticated with AES-GCM. Each chunk of the file is sealed
+// separately, so that it can be read and written at any position without
+// processing the whole file.
+//
+// Changes are buffered a chunk at a time, and become visible on the device,
+// together with the new header, when the file is synced or closed.
+type EncryptedFile struct {
+	chunkedFile
+	aead   cipher.AEAD
+	macKey []byte
+	nonce  [16]byte
+
+	// tags holds the tag of each chunk, which the MAC of the header covers
+	tags []byte
+}
+
+// OpenEncrypted opens the named file like OpenFile, encrypting everything
+// written to it with key and authenticating everything read from it. The key
+// must be 16, 24, or 32 bytes long; keys for each file are derived from it,
+// so the same key can be used for many files.
+//
+// Opening an existing file fails with ErrNotEncrypted if it was not written
+// with OpenEncrypted, and with ErrTampered if its header and the tags of its
+// chunks do not authenticate with key.
+func (l *LFS) OpenEncrypted(path string, flags int, key []byte) (*EncryptedFile, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, ErrInvalidParam
+	}
+	ef := &EncryptedFile{}
+	ef.store = ef
+	attr := &fileAttr{typ: AttrEncryption, buf: make([]byte, encryptedHeaderSize)}
+	if err := ef.open(l, path, flags, attr); err != nil {
+		return nil, err
+	}
+	if err := ef.init(key, flags&os.O_TRUNC != 0); err != nil {
+		ef.f.Close()
+		return nil, err
+	}
+	return ef, nil
+}
+
+// init decodes and checks the header of the file, or starts a new one if the
+// file is empty and opened for writing
+func (ef *EncryptedFile) init(key []byte, trunc bool) error {
+	raw, err := ef.f.Size()
+	if err != nil {
+		return err
+	}
+	hdr := ef.attr.buf
+	if hdr[0] == 0 || trunc {
+		if raw != 0 || !ef.writable {
+			return ErrNotEncrypted
+		}
+		ef.chunkSize = encryptedChunkSize
+		if _, err := io.ReadFull(rand.Reader, ef.nonce[:]); err != nil {
+			return err
+		}
+		return ef.keys(key)
+	}
+	if hdr[0] != encryptedVersion {
+		return ErrNotEncrypted
+	}
+	ef.chunkSize = binary.LittleEndian.Uint32(hdr[4:])
+	ef.size = int64(binary.LittleEndian.Uint64(hdr[8:]))
+	copy(ef.nonce[:], hdr[16:32])
+	if err := ef.keys(key); err != nil {
+		return err
+	}
+	if ef.chunkSize == 0 || ef.diskSize(ef.size) != raw {
+		return ErrTampered
+	}
+	if err := ef.loadTags(); err != nil {
+		return err
+	}
+	if !hmac.Equal(ef.mac(), hdr[32:]) {
+		return ErrTampered
+	}
+	return nil
+}
+
+// loadTags reads the tag at the end of each chunk
+func (ef *EncryptedFile) loadTags() error {
+	chunk := int64(ef.chunkSize)
+	chunks := (ef.size + chunk - 1) / chunk
+	ef.tags = make([]byte, chunks*encryptedTagSize)
+	for i := int64(0); i < chunks; i++ {
+		n := min(ef.size-i*chunk, chunk)
+		tag := ef.tags[i*encryptedTagSize:][:encryptedTagSize]
+		if err := ef.readAt(tag, i*(chunk+encryptedOverhead)+encryptedNonceSize+n); err != nil {
+			if err == ErrCorrupt {
+				return ErrTampered
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// keys derives the keys of the file from key and the nonce of the file
+func (ef *EncryptedFile) keys(key []byte) error {
+	derive := func(label string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte("littlefs encrypted file " + label))
+		h.Write(ef.nonce[:])
+		return h.Sum(nil)[:len(key)]
+	}
+	block, err := aes.NewCipher(derive("data"))
+	if err != nil {
+		return err
+	}
+	if ef.aead, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+	ef.macKey = derive("header")
+	return nil
+}
+
+// mac returns the MAC of the header as it is currently encoded and of the
+// tags of the chunks
+func (ef *EncryptedFile) mac() []byte {
+	h := hmac.New(sha256.New, ef.macKey)
+	h.Write(ef.attr.buf[:32])
+	h.Write(ef.tags)
+	return h.Sum(nil)
+}
+
+// diskSize returns the size of the underlying file holding size bytes of
+// plaintext
+func (ef *EncryptedFile) diskSize(size int64) int64 {
+	chunk := int64(ef.chunkSize)
+	n := size / chunk * (chunk + encryptedOverhead)
+	if rem := size % chunk; rem != 0 {
+		n += rem + encryptedOverhead
+	}
+	return n
+}
+
+func (ef *EncryptedFile) loadChunk(dst []byte, index int64, n int64) ([]byte, error) {
+	sealed := make([]byte, n+encryptedOverhead)
+	if err := ef.readAt(sealed, index*int64(ef.chunkSize+encryptedOverhead)); err != nil {
+		if err == ErrCorrupt {
+			return nil, ErrTampered
+		}
+		return nil, err
+	}
+	nonce, ciphertext := sealed[:encryptedNonceSize], sealed[encryptedNonceSize:]
+	tag := ciphertext[len(ciphertext)-encryptedTagSize:]
+	if !hmac.Equal(tag, ef.tags[index*encryptedTagSize:][:encryptedTagSize]) {
+		return nil, ErrTampered
+	}
+	chunk, err := ef.aead.Open(dst, nonce, ciphertext, ef.additionalData(index))
+	if err != nil {
+		return nil, ErrTampered
+	}
+	return chunk, nil
+}
+
+func (ef *EncryptedFile) storeChunk(index int64, data []byte) error {
+	sealed := make([]byte, encryptedNonceSize, len(data)+encryptedOverhead)
+	if _, err := io.ReadFull(rand.Reader, sealed); err != nil {
+		return err
+	}
+	sealed = ef.aead.Seal(sealed, sealed, data, ef.additionalData(index))
+	if err := ef.writeAt(sealed, index*int64(ef.chunkSize+encryptedOverhead)); err != nil {
+		return err
+	}
+	tag := sealed[len(sealed)-encryptedTagSize:]
+	if off := index * encryptedTagSize; off < int64(len(ef.tags)) {
+		copy(ef.tags[off:], tag)
+	} else {
+		ef.tags = append(ef.tags, tag...)
+	}
+	return nil
+}
+
+func (ef *EncryptedFile) additionalData(index int64) []byte {
+	ad := make([]byte, len(ef.nonce)+8)
+	copy(ad, ef.nonce[:])
+	binary.LittleEndian.PutUint64(ad[len(ef.nonce):], uint64(index))
+	return ad
+}
+
+func (ef *EncryptedFile) encodeHeader() {
+	hdr := ef.attr.buf
+	hdr[0] = encryptedVersion
+	binary.LittleEndian.PutUint32(hdr[4:], ef.chunkSize)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(ef.size))
+	copy(hdr[16:32], ef.nonce[:])
+	copy(hdr[32:], ef.mac())
+}
+
+// encryptedSize returns the size of the plaintext of path, if it was written
+// with OpenEncrypted. The header is not authenticated, since no key is known.
+func (l *LFS) encryptedSize(path string) (uint32, bool) {
+	var hdr [encryptedHeaderSize]byte
+	if n, err := l.Getattr(path, AttrEncryption, hdr[:]); err != nil || n != len(hdr) || hdr[0] != encryptedVersion {
+		return 0, false
+	}
+	return uint32(binary.LittleEndian.Uint64(hdr[8:])), true
+}
@@ -0,0 +1,237 @@
+package lfs
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestEncryptedFile(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	fs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+
+	data := make([]byte, 5000)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	writeEncrypted := func(t *testing.T, name string, data []byte) {
+		f, err := fs.OpenEncrypted(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	readEncrypted := func(t *testing.T, name string) ([]byte, error) {
+		f, err := fs.OpenEncrypted(name, os.O_RDONLY, key)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		writeEncrypted(t, "secret", data)
+		got, err := readEncrypted(t, "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatal("contents differ after reading back")
+		}
+		info, err := fs.Stat("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(data)) {
+			t.Errorf("expected Stat to report %d bytes; got %d", len(data), info.Size())
+		}
+		dir, err := fs.Open("/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dir.Close()
+		infos, err := dir.Readdir(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, info := range infos {
+			if info.Name() == "secret" && info.Size() != int64(len(data)) {
+				t.Errorf("expected Readdir to report %d bytes; got %d", len(data), info.Size())
+			}
+		}
+	})
+
+	t.Run("NoPlaintext", func(t *testing.T) {
+		f, err := fs.Open("secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		raw, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, data[:64]) {
+			t.Error("found plaintext in the underlying file")
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		writeEncrypted(t, "seek", data)
+		f, err := fs.OpenEncrypted("seek", os.O_RDWR, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := append([]byte(nil), data...)
+		// overwrite across a chunk boundary, then extend past the end
+		if _, err := f.Seek(1000, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("0123456789abcdefghijklmnopqrstuvwxyz"))
+		copy(want[1000:], "0123456789abcdefghijklmnopqrstuvwxyz")
+		if _, err := f.Seek(100, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("tail"))
+		want = append(want, make([]byte, 100)...)
+		want = append(want, "tail"...)
+		if f.Size() != int64(len(want)) {
+			t.Errorf("expected size %d; got %d", len(want), f.Size())
+		}
+		if _, err := f.Seek(990, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 50)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, want[990:1040]) {
+			t.Error("unexpected contents read back before closing")
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readEncrypted(t, "seek")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Error("unexpected contents read back after closing")
+		}
+	})
+
+	t.Run("Append", func(t *testing.T) {
+		writeEncrypted(t, "log", []byte("hello, "))
+		f, err := fs.OpenEncrypted("log", os.O_WRONLY|os.O_APPEND, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("world"))
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readEncrypted(t, "log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "hello, world" {
+			t.Errorf("unexpected contents %q", got)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		writeEncrypted(t, "tampered", data)
+		f, err := fs.OpenFile("tampered", os.O_RDWR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 1)
+		f.Seek(2000, io.SeekStart)
+		f.Read(b)
+		b[0] ^= 1
+		f.Seek(2000, io.SeekStart)
+		f.Write(b)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readEncrypted(t, "tampered"); err != ErrTampered {
+			t.Errorf("expected ErrTampered; got %v", err)
+		}
+
+		// a different header is detected as soon as the file is opened
+		writeEncrypted(t, "tampered", data)
+		hdr := make([]byte, encryptedHeaderSize)
+		if _, err := fs.Getattr("tampered", AttrEncryption, hdr); err != nil {
+			t.Fatal(err)
+		}
+		hdr[8]--
+		if err := fs.Setattr("tampered", AttrEncryption, hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.OpenEncrypted("tampered", os.O_RDONLY, key); err != ErrTampered {
+			t.Errorf("expected ErrTampered; got %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		writeEncrypted(t, "rollback", data)
+		chunk := make([]byte, encryptedChunkSize+encryptedOverhead)
+		raw := func(flags int, fn func(f *File) error) {
+			t.Helper()
+			f, err := fs.OpenFile("rollback", flags)
+			check(t, err)
+			if _, err := f.Seek(int64(len(chunk)), io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			check(t, fn(f))
+			check(t, f.Close())
+		}
+		raw(os.O_RDONLY, func(f *File) error {
+			_, err := io.ReadFull(f, chunk)
+			return err
+		})
+
+		f, err := fs.OpenEncrypted("rollback", os.O_RDWR, key)
+		check(t, err)
+		if _, err := f.Seek(encryptedChunkSize, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		check(t, f.Close())
+
+		// putting the old copy of the chunk back is detected
+		raw(os.O_WRONLY, func(f *File) error {
+			_, err := f.Write(chunk)
+			return err
+		})
+		if _, err := fs.OpenEncrypted("rollback", os.O_RDONLY, key); err != ErrTampered {
+			t.Errorf("expected ErrTampered; got %v", err)
+		}
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		if _, err := fs.OpenEncrypted("secret", os.O_RDONLY, make([]byte, 32)); err != ErrTampered {
+			t.Errorf("expected ErrTampered; got %v", err)
+		}
+		if _, err := fs.OpenEncrypted("secret", os.O_RDONLY, key[:7]); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+
+	t.Run("NotEncrypted", func(t *testing.T) {
+		writeFileTest(t, fs, 100, "plain")
+		if _, err := fs.OpenEncrypted("plain", os.O_RDWR, key); err != ErrNotEncrypted {
+			t.Errorf("expected ErrNotEncrypted; got %v", err)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package lfs
+
+import (
+	"io"
+	"os"
+)
+
+// atomicSuffix names the temporary file written by WriteFileAtomic
+const atomicSuffix = ".atomic~"
+
+// ReadFile returns the whole contents of the named file
+func (l *LFS) ReadFile(path string) ([]byte, error) {
+	f, err := l.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readContents(f)
+}
+
+// readContents returns the contents of f, which must not have been read from yet
+func readContents(f *File) ([]byte, error) {
+	if f.IsDir() {
+		return nil, ErrIsDir
+	}
+	size, err := f.Size()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if size > 0 {
+		if _, err := io.ReadFull(f, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// WriteFile replaces the contents of the named file with data, creating the
+// file if necessary. If data cannot be written completely, for instance
+// because there is no space left, littlefs does not commit any of it, and a
+// file that was created by WriteFile is removed again.
+func (l *LFS) WriteFile(path string, data []byte) error {
+	return l.writeFile(path, data, os.O_TRUNC)
+}
+
+// WriteFileAtomic replaces the contents of the named file with data, so that
+// after a power loss or error the file holds either its old contents or data,
+// and never anything in between. The data is written to a temporary file in
+// the same directory, which is synced and then renamed over the file, taking
+// over its permissions. If the file is versioned, see SetVersions, its old
+// contents are kept as a revision.
+func (l *LFS) WriteFileAtomic(path string, data []byte) error {
+	tmp := path + atomicSuffix
+	f, err := l.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	err = writeAndClose(f, data)
+	if err == nil {
+		err = l.keepPermissions(path, tmp)
+	}
+	if err == nil {
+		err = l.saveRevision(path, tmp)
+	}
+	if err == nil {
+		err = l.Rename(tmp, path)
+	}
+	if err != nil {
+		l.Remove(tmp)
+	}
+	return err
+}
+
+// AppendFile appends data to the named file, creating it if necessary. If
+// not all of data can be written, the file is left as it was, as for
+// WriteFile.
+func (l *LFS) AppendFile(path string, data []byte) error {
+	return l.writeFile(path, data, os.O_APPEND)
+}
+
+// writeFile writes data to the named file opened with the extra flags,
+// removing the file if it was created and writing fails
+func (l *LFS) writeFile(path string, data []byte, flags int) error {
+	_, statErr := l.Stat(path)
+	f, err := l.OpenFile(path, os.O_WRONLY|os.O_CREATE|flags)
+	if err != nil {
+		return err
+	}
+	if err := writeAndClose(f, data); err != nil {
+		if statErr == ErrNoEntry {
+			l.Remove(path)
+		}
+		return err
+	}
+	return nil
+}
+
+// writeAndClose writes data to f, syncs it and closes it; f is closed even
+// if writing fails
+func writeAndClose(f *File, data []byte) error {
+	if len(data) > 0 {
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
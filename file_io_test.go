@@ -0,0 +1,118 @@
+package lfs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFileIO(t *testing.T) {
+	t.Run("ReadWrite", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("file", []byte("hello")))
+		check(t, fs.AppendFile("file", []byte(", world")))
+		check(t, fs.AppendFile("new", []byte("appended")))
+		data, err := fs.ReadFile("file")
+		check(t, err)
+		if string(data) != "hello, world" {
+			t.Errorf("unexpected contents %q", data)
+		}
+		data, err = fs.ReadFile("new")
+		check(t, err)
+		if string(data) != "appended" {
+			t.Errorf("unexpected contents %q", data)
+		}
+		check(t, fs.WriteFileAtomic("file", []byte("replaced")))
+		data, err = fs.ReadFile("file")
+		check(t, err)
+		if string(data) != "replaced" {
+			t.Errorf("unexpected contents %q", data)
+		}
+		if _, err := fs.Stat("file" + atomicSuffix); err != ErrNoEntry {
+			t.Errorf("expected the temporary file to be gone; got %v", err)
+		}
+		check(t, fs.WriteFile("empty", nil))
+		if data, err := fs.ReadFile("empty"); err != nil || len(data) != 0 {
+			t.Errorf("expected an empty file; got %q, %v", data, err)
+		}
+		if _, err := fs.ReadFile("missing"); err != ErrNoEntry {
+			t.Errorf("expected ErrNoEntry; got %v", err)
+		}
+	})
+
+	t.Run("NoSpace", func(t *testing.T) {
+		config := defaultConfig
+		config.BlockCount = 32
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		old := bytes.Repeat([]byte("old"), 1000)
+		huge := make([]byte, config.BlockSize*config.BlockCount)
+		check(t, fs.WriteFile("file", old))
+
+		for name, write := range map[string]func(string, []byte) error{
+			"WriteFile":       fs.WriteFile,
+			"WriteFileAtomic": fs.WriteFileAtomic,
+			"AppendFile":      fs.AppendFile,
+		} {
+			if err := write("file", huge); err != ErrNoSpace {
+				t.Errorf("%s: expected ErrNoSpace; got %v", name, err)
+			}
+			if data, err := fs.ReadFile("file"); err != nil || !bytes.Equal(data, old) {
+				t.Errorf("%s: expected the old contents to be kept; got %d bytes, %v", name, len(data), err)
+			}
+			if err := write("new", huge); err != ErrNoSpace {
+				t.Errorf("%s: expected ErrNoSpace; got %v", name, err)
+			}
+			if _, err := fs.Stat("new"); err != ErrNoEntry {
+				t.Errorf("%s: expected a new file to be removed; got %v", name, err)
+			}
+		}
+		if _, err := fs.Stat("file" + atomicSuffix); err != ErrNoEntry {
+			t.Errorf("expected the temporary file to be removed; got %v", err)
+		}
+		// the space is available again
+		check(t, fs.WriteFileAtomic("file", old[:2000]))
+	})
+
+	t.Run("PowerCut", func(t *testing.T) {
+		old := bytes.Repeat([]byte("old contents "), 100)
+		data := bytes.Repeat([]byte("new contents "), 150)
+		base := NewMemoryDevice(defaultConfig)
+		fs := New(defaultConfig, base)
+		check(t, fs.Format())
+		check(t, fs.Mount())
+		check(t, fs.WriteFile("file", old))
+		check(t, fs.Unmount())
+
+		for limit := 0; ; limit++ {
+			dev := base.Clone()
+			cut := &powerCutBlockDevice{BlockDevice: dev, limit: limit, halt: true}
+			untilPowerCut(func() {
+				fs := New(defaultConfig, cut)
+				if fs.Mount() == nil {
+					fs.WriteFileAtomic("file", data)
+				}
+			})
+
+			fs := New(defaultConfig, dev)
+			check(t, fs.Mount())
+			got, err := fs.ReadFile("file")
+			check(t, err)
+			if !bytes.Equal(got, old) && !bytes.Equal(got, data) {
+				t.Fatalf("limit %d: file has neither its old nor its new contents", limit)
+			}
+			// a temporary file left behind is replaced by the next write
+			check(t, fs.WriteFileAtomic("file", old))
+			if _, err := fs.Stat("file" + atomicSuffix); err != ErrNoEntry {
+				t.Fatalf("limit %d: expected no temporary file; got %v", limit, err)
+			}
+			check(t, fs.Unmount())
+			if !cut.cut() {
+				if !bytes.Equal(got, data) {
+					t.Fatal("expected the new contents without a power cut")
+				}
+				break
+			}
+		}
+	})
+}
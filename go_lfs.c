@@ -1,3 +1,5 @@
+#include <stdarg.h>
+#include <stdio.h>
 #include "go_lfs.h"
 
 struct lfs* go_lfs_new_lfs() {
@@ -16,6 +18,23 @@ lfs_file_t* go_lfs_new_lfs_file() {
     return malloc(sizeof(lfs_file_t));
 }
 
+struct lfs_attr* go_lfs_new_lfs_attrs(lfs_size_t count) {
+    return calloc(count, sizeof(struct lfs_attr));
+}
+
+struct lfs_file_config* go_lfs_new_lfs_file_config(struct lfs_attr *attrs, lfs_size_t count) {
+    struct lfs_file_config *cfg = calloc(1, sizeof(struct lfs_file_config));
+    cfg->attrs = attrs;
+    cfg->attr_count = count;
+    return cfg;
+}
+
+void go_lfs_set_attr(struct lfs_attr *attrs, lfs_size_t i, uint8_t type, void *buffer, lfs_size_t size) {
+    attrs[i].type = type;
+    attrs[i].buffer = buffer;
+    attrs[i].size = size;
+}
+
 struct lfs_config* go_lfs_set_callbacks(struct lfs_config *cfg) {
     cfg->read  = go_lfs_c_cb_read;
     cfg->prog  = go_lfs_c_cb_prog;
@@ -39,3 +58,30 @@ int go_lfs_c_cb_erase(const struct lfs_config *c, lfs_block_t block) {
 int go_lfs_c_cb_sync(const struct lfs_config *c) {
 	return go_lfs_block_device_sync(c->context);
 }
+
+int go_lfs_c_cb_traverse(void *data, lfs_block_t block) {
+	return go_lfs_traverse_block(data, block);
+}
+
+int go_lfs_fs_traverse(lfs_t *lfs, void *data) {
+	return lfs_fs_traverse(lfs, go_lfs_c_cb_traverse, data);
+}
+
+void go_lfs_log(const void *lfs, int level, int line, const char *fmt, ...) {
+    char msg[GO_LFS_LOG_MAX];
+    va_list args;
+    va_start(args, fmt);
+    int n = vsnprintf(msg, sizeof(msg), fmt, args);
+    va_end(args);
+    if (n < 0) {
+        return;
+    }
+    if (n >= (int)sizeof(msg)) {
+        n = sizeof(msg) - 1;
+    }
+    go_lfs_log_message(((const lfs_t*)lfs)->cfg->context, level, line, msg, n);
+}
+
+void go_lfs_assert(const void *lfs, const char *file, int line, const char *expr) {
+    go_lfs_assert_failed(((const lfs_t*)lfs)->cfg->context, (char*)file, line, (char*)expr);
+}
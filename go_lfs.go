@@ -1,14 +1,18 @@
 package lfs
 
+// #cgo CFLAGS: -DLFS_GO_LOG
 // #include <string.h>
 // #include <stdlib.h>
 // #include "./go_lfs.h"
 import "C"
 
 import (
+	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"os"
+	"path"
 	"time"
 	"unsafe"
 
@@ -18,36 +22,57 @@ import (
 const (
 	Version = C.LFS_VERSION
 
-	ErrOK                 = C.LFS_ERR_OK          // No error
-	ErrIO           Error = C.LFS_ERR_IO          // Error during device operation
-	ErrCorrupt      Error = C.LFS_ERR_CORRUPT     // Corrupted
-	ErrNoEntry      Error = C.LFS_ERR_NOENT       // No directory entry
-	ErrEntryExists  Error = C.LFS_ERR_EXIST       // Entry already exists
-	ErrNotDir       Error = C.LFS_ERR_NOTDIR      // Entry is not a dir
-	ErrIsDir        Error = C.LFS_ERR_ISDIR       // Entry is a dir
-	ErrDirNotEmpty  Error = C.LFS_ERR_NOTEMPTY    // Dir is not empty
-	ErrBadFileNum   Error = C.LFS_ERR_BADF        // Bad file number
-	ErrFileTooLarge Error = C.LFS_ERR_FBIG        // File too large
-	ErrInvalidParam Error = C.LFS_ERR_INVAL       // Invalid parameter
-	ErrNoSpace      Error = C.LFS_ERR_NOSPC       // No space left on device
-	ErrNoMemory     Error = C.LFS_ERR_NOMEM       // No more memory available
-	ErrNoAttr       Error = C.LFS_ERR_NOATTR      // No data/attr available
-	ErrNameTooLong  Error = C.LFS_ERR_NAMETOOLONG // File name too long
+	ErrOK                  = C.LFS_ERR_OK          // No error
+	ErrIO            Error = C.LFS_ERR_IO          // Error during device operation
+	ErrCorrupt       Error = C.LFS_ERR_CORRUPT     // Corrupted
+	ErrNoEntry       Error = C.LFS_ERR_NOENT       // No directory entry
+	ErrEntryExists   Error = C.LFS_ERR_EXIST       // Entry already exists
+	ErrNotDir        Error = C.LFS_ERR_NOTDIR      // Entry is not a dir
+	ErrIsDir         Error = C.LFS_ERR_ISDIR       // Entry is a dir
+	ErrDirNotEmpty   Error = C.LFS_ERR_NOTEMPTY    // Dir is not empty
+	ErrBadFileNum    Error = C.LFS_ERR_BADF        // Bad file number
+	ErrFileTooLarge  Error = C.LFS_ERR_FBIG        // File too large
+	ErrInvalidParam  Error = C.LFS_ERR_INVAL       // Invalid parameter
+	ErrNoSpace       Error = C.LFS_ERR_NOSPC       // No space left on device
+	ErrNoMemory      Error = C.LFS_ERR_NOMEM       // No more memory available
+	ErrNoAttr        Error = C.LFS_ERR_NOATTR      // No data/attr available
+	ErrNameTooLong   Error = C.LFS_ERR_NAMETOOLONG // File name too long
+	ErrReadOnly      Error = -30                   // Read-only filesystem
+	ErrPermission    Error = -13                   // Permission denied
+	ErrQuotaExceeded Error = -122                  // Disk quota exceeded
 
 	fileTypeReg fileType = C.LFS_TYPE_REG
 	fileTypeDir fileType = C.LFS_TYPE_DIR
 )
 
+// writeFlags are the flags that cause OpenFile to modify the filesystem
+const writeFlags = os.O_WRONLY | os.O_RDWR | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+
+// Custom attribute types used by this package to store its own metadata;
+// applications should use other types for their attributes
+const (
+	AttrEncryption  uint8 = 0xe0 // header of a file opened with OpenEncrypted
+	AttrCompression uint8 = 0xe1 // header of a file opened with OpenCompressed
+	AttrTransaction uint8 = 0xe2 // progress of a transaction being committed
+	AttrVersioning  uint8 = 0xe3 // revisions kept of a file, see SetVersions
+	AttrRevision    uint8 = 0xe4 // sequence number and time of a revision
+	AttrSymlink     uint8 = 0xe5 // marks a file holding a symbolic link
+	AttrPermissions uint8 = 0xe6 // mode, uid and gid of a file, see Chmod
+	AttrReservation uint8 = 0xe7 // space reserved for files, see Reserve
+	AttrQuota       uint8 = 0xe8 // limits of a directory, see SetQuota
+)
+
 func translateFlags(osFlags int) C.int {
 	var result C.int
-	if osFlags&os.O_RDONLY > 0 {
-		result |= C.LFS_O_RDONLY
-	}
-	if osFlags&os.O_WRONLY > 0 {
+	// os.O_RDONLY is zero, so the access mode has to be matched exactly rather
+	// than tested bit by bit
+	switch osFlags & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
 		result |= C.LFS_O_WRONLY
-	}
-	if osFlags&os.O_RDWR > 0 {
+	case os.O_RDWR:
 		result |= C.LFS_O_RDWR
+	default:
+		result |= C.LFS_O_RDONLY
 	}
 	if osFlags&os.O_CREATE > 0 {
 		result |= C.LFS_O_CREAT
@@ -98,11 +123,27 @@ func (err Error) Error() string {
 		return "littlefs: No data/attr available"
 	case ErrNameTooLong:
 		return "littlefs: File name too long"
+	case ErrReadOnly:
+		return "littlefs: Read-only filesystem"
+	case ErrPermission:
+		return "littlefs: Permission denied"
+	case ErrQuotaExceeded:
+		return "littlefs: Disk quota exceeded"
 	default:
 		return "littlefs: Unknown error"
 	}
 }
 
+// Is allows errors.Is to match littlefs errors against their os equivalents
+func (err Error) Is(target error) bool {
+	switch target {
+	case os.ErrPermission:
+		return err == ErrReadOnly || err == ErrPermission
+	default:
+		return false
+	}
+}
+
 type Config struct {
 	ReadSize      uint32
 	ProgSize      uint32
@@ -117,12 +158,59 @@ type LFS struct {
 	ptr unsafe.Pointer
 	lfs *C.struct_lfs
 	cfg *C.struct_lfs_config
+	dev BlockDevice
+
+	// readonly is set while the filesystem is mounted with MountReadOnly
+	readonly bool
+
+	// logger receives the diagnostics of littlefs, see SetLogger
+	logger *slog.Logger
+
+	// asserted is the assertion which failed in littlefs, after which the
+	// state of littlefs cannot be trusted until it is mounted again
+	asserted *AssertionError
+
+	// observer receives an Event for each operation, see SetObserver; op is
+	// the public operation in progress
+	observer Observer
+	op       Op
+
+	// ctx is the context of the operation in progress, if it was started by
+	// one of the *Context methods; ctxErr is set once a callback fails
+	// because ctx is done
+	ctx    context.Context
+	ctxErr error
+
+	// tx is the transaction in progress, see Begin
+	tx *Tx
+
+	// cred is checked against the permissions of files opened, see
+	// SetCredential
+	cred *Credential
+
+	// secureDelete is set to erase the blocks freed by operations, see
+	// SetSecureDelete
+	secureDelete bool
+
+	// reservations maps the paths of files to the number of bytes reserved
+	// for them, see Reserve
+	reservations map[string]int64
+
+	// watermarks are checked after operations which write to the block
+	// device, see SetWatermarks
+	watermarks watermarkState
 }
 
 type Info struct {
 	ftyp fileType
 	size uint32
 	name string
+
+	// link is set for a symbolic link described by Lstat or Readdir
+	link bool
+
+	// perm is kept in the AttrPermissions attribute
+	perm permissions
 }
 
 func (info *Info) Name() string {
@@ -137,15 +225,34 @@ func (info *Info) IsDir() bool {
 	return info.ftyp == fileTypeDir
 }
 
+// Sys returns a *Stat_t
 func (info *Info) Sys() interface{} {
-	return nil
+	mode := info.perm.mode
+	switch {
+	case info.IsDir():
+		mode |= modeTypeDir
+	case info.link:
+		mode |= modeTypeSymlink
+	default:
+		mode |= modeTypeRegular
+	}
+	return &Stat_t{
+		Mode:  mode,
+		Nlink: 1,
+		Uid:   info.perm.uid,
+		Gid:   info.perm.gid,
+		Size:  info.Size(),
+	}
 }
 
 func (info *Info) Mode() os.FileMode {
-	v := os.FileMode(0777)
+	v := fileMode(info.perm.mode)
 	if info.IsDir() {
 		v |= os.ModeDir
 	}
+	if info.link {
+		v |= os.ModeSymlink
+	}
 	return v
 }
 
@@ -157,9 +264,11 @@ func New(config Config, blockdev BlockDevice) *LFS {
 	lfs := &LFS{
 		lfs: C.go_lfs_new_lfs(),
 		cfg: C.go_lfs_new_lfs_config(),
+		dev: blockdev,
 	}
+	lfs.ptr = gopointer.Save(lfs) // save this to prevent GC until Close() is called?
 	*lfs.cfg = C.struct_lfs_config{
-		context:        gopointer.Save(blockdev),
+		context:        lfs.ptr,
 		read_size:      C.lfs_size_t(config.ReadSize),
 		prog_size:      C.lfs_size_t(config.ProgSize),
 		block_size:     C.lfs_size_t(config.BlockSize),
@@ -169,87 +278,365 @@ func New(config Config, blockdev BlockDevice) *LFS {
 		block_cycles:   C.int32_t(config.BlockCycles),
 	}
 	C.go_lfs_set_callbacks(lfs.cfg)
-	lfs.ptr = gopointer.Save(lfs) // save this to prevent GC until Close() is called?
+	// littlefs only sets this in lfs_format and lfs_mount, but the log hooks
+	// need it to find the LFS struct before then
+	lfs.lfs.cfg = lfs.cfg
 	return lfs
 }
 
-func (l *LFS) Mount() error {
-	return errval(C.lfs_mount(l.lfs, l.cfg))
+func (l *LFS) Mount() (err error) {
+	s := l.begin(OpMount, "")
+	defer func() { s.end(0, err) }()
+	l.readonly = false
+	l.asserted = nil
+	if err := l.callErr(func() C.int { return C.lfs_mount(l.lfs, l.cfg) }); err != nil {
+		return err
+	}
+	// finish a transaction that was interrupted by a power loss
+	if err := l.recoverTx(); err != nil {
+		l.call(func() C.int { return C.lfs_unmount(l.lfs) })
+		return err
+	}
+	if err := l.loadReservations(); err != nil {
+		l.call(func() C.int { return C.lfs_unmount(l.lfs) })
+		return err
+	}
+	return nil
+}
+
+// MountReadOnly mounts the filesystem such that any operation which would
+// modify it fails with ErrReadOnly. While mounted this way, no program or
+// erase operation is ever passed through to the block device, so a
+// transaction interrupted by a power loss is not finished as it is by Mount.
+func (l *LFS) MountReadOnly() (err error) {
+	s := l.begin(OpMount, "")
+	defer func() { s.end(0, err) }()
+	l.asserted = nil
+	if err := l.callErr(func() C.int { return C.lfs_mount(l.lfs, l.cfg) }); err != nil {
+		return err
+	}
+	l.readonly = true
+	return nil
 }
 
-func (l *LFS) Format() error {
-	return errval(C.lfs_format(l.lfs, l.cfg))
+// ReadOnly reports whether the filesystem is mounted read-only
+func (l *LFS) ReadOnly() bool {
+	return l.readonly
+}
+
+func (l *LFS) Format() (err error) {
+	s := l.begin(OpFormat, "")
+	defer func() { s.end(0, err) }()
+	if l.readonly {
+		return ErrReadOnly
+	}
+	l.asserted = nil
+	return l.callErr(func() C.int { return C.lfs_format(l.lfs, l.cfg) })
 }
 
-func (l *LFS) Unmount() error {
-	return errval(C.lfs_unmount(l.lfs))
+func (l *LFS) Unmount() (err error) {
+	s := l.begin(OpUnmount, "")
+	defer func() { s.end(0, err) }()
+	l.readonly = false
+	// unmounting only frees the buffers of littlefs, which is safe even
+	// after a failed assertion
+	l.asserted = nil
+	return l.callErr(func() C.int { return C.lfs_unmount(l.lfs) })
 }
 
 func (l *LFS) Remove(path string) error {
+	return l.remove(path, false)
+}
+
+// remove removes the named file; if secure is set, the blocks it frees are
+// erased, and ErrNotErased is returned if its contents were inlined in its
+// directory
+func (l *LFS) remove(path string, secure bool) (err error) {
+	s := l.begin(OpRemove, path)
+	defer func() { s.end(0, err) }()
+	if l.readonly {
+		return ErrReadOnly
+	}
+	path, err = l.resolve(path, false)
+	if err != nil {
+		return err
+	}
+	inlined := false
+	if secure && !l.secureDelete {
+		l.secureDelete = true
+		defer func() { l.secureDelete = false }()
+	}
+	if secure {
+		if inlined, err = l.inlined(path); err != nil {
+			return err
+		}
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
-	return errval(C.lfs_remove(l.lfs, cs))
+	err = l.scrub(func() error {
+		return l.callErr(func() C.int { return C.lfs_remove(l.lfs, cs) })
+	})
+	if err == nil && inlined {
+		return ErrNotErased
+	}
+	return err
 }
 
-func (l *LFS) Rename(oldPath string, newPath string) error {
+func (l *LFS) Rename(oldPath string, newPath string) (err error) {
+	s := l.begin(OpRename, oldPath)
+	defer func() { s.end(0, err) }()
+	if l.readonly {
+		return ErrReadOnly
+	}
+	if oldPath, err = l.resolve(oldPath, false); err != nil {
+		return err
+	}
+	if newPath, err = l.resolve(newPath, false); err != nil {
+		return err
+	}
 	cs1, cs2 := cstring(oldPath), cstring(newPath)
 	defer C.free(unsafe.Pointer(cs1))
 	defer C.free(unsafe.Pointer(cs2))
-	return errval(C.lfs_rename(l.lfs, cs1, cs2))
+	return l.scrub(func() error {
+		return l.callErr(func() C.int { return C.lfs_rename(l.lfs, cs1, cs2) })
+	})
 }
 
-func (l *LFS) Stat(path string) (*Info, error) {
-	cs := cstring(path)
+// Stat describes the named file, following symbolic links
+func (l *LFS) Stat(path string) (_ *Info, err error) {
+	s := l.begin(OpStat, path)
+	defer func() { s.end(0, err) }()
+	resolved, err := l.resolve(path, true)
+	if err != nil {
+		return nil, err
+	}
+	return l.stat(path, resolved)
+}
+
+// stat describes the named file, which littlefs knows as resolved
+func (l *LFS) stat(name string, resolved string) (*Info, error) {
+	cs := cstring(resolved)
 	defer C.free(unsafe.Pointer(cs))
 	info := C.struct_lfs_info{}
-	if err := errval(C.lfs_stat(l.lfs, cs, &info)); err != nil {
+	if err := l.callErr(func() C.int { return C.lfs_stat(l.lfs, cs, &info) }); err != nil {
 		return nil, err
 	}
-	return &Info{
+	fi := &Info{
 		ftyp: fileType(info._type),
 		size: uint32(info.size),
 		name: gostring(&info.name[0]),
-	}, nil
+	}
+	if resolved != name {
+		// the name of a link rather than of the file it points to
+		fi.name = path.Base(name)
+	}
+	l.extendInfo(resolved, fi)
+	return fi, nil
+}
+
+// extendInfo adds the permissions of a file, marks symbolic links, and
+// replaces the size of any other regular file with the size of its contents
+// as they are seen through OpenEncrypted or OpenCompressed
+func (l *LFS) extendInfo(path string, info *Info) {
+	info.perm = defaultPermissions
+	if perm, err := l.permissions(path); err == nil {
+		info.perm = perm
+	}
+	if info.ftyp != fileTypeReg {
+		return
+	}
+	if ok, _ := l.isSymlink(path); ok {
+		info.link = true
+	} else if size, ok := l.encryptedSize(path); ok {
+		info.size = size
+	} else if size, ok := l.compressedSize(path); ok {
+		info.size = size
+	}
 }
 
-func (l *LFS) Mkdir(path string) error {
+func (l *LFS) Mkdir(path string) (err error) {
+	s := l.begin(OpMkdir, path)
+	defer func() { s.end(0, err) }()
+	if l.readonly {
+		return ErrReadOnly
+	}
+	path, err = l.resolve(path, false)
+	if err != nil {
+		return err
+	}
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
-	return errval(C.lfs_mkdir(l.lfs, cs))
+	return l.callErr(func() C.int { return C.lfs_mkdir(l.lfs, cs) })
+}
+
+// Getattr reads the custom attribute of type attr attached to path into buf.
+// If buf is smaller than the attribute, the attribute is truncated.
+//
+// Returns the size of the attribute on disk, irrespective of the size of buf,
+// or ErrNoAttr if the attribute does not exist.
+func (l *LFS) Getattr(path string, attr uint8, buf []byte) (int, error) {
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	errno, err := l.call(func() C.int {
+		return C.int(C.lfs_getattr(l.lfs, cs, C.uint8_t(attr), bufptr(buf), C.lfs_size_t(len(buf))))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno < 0 {
+		return 0, errval(errno)
+	}
+	return int(errno), nil
+}
+
+// Setattr attaches buf as the custom attribute of type attr to path, creating
+// the attribute if it does not exist yet
+func (l *LFS) Setattr(path string, attr uint8, buf []byte) error {
+	if l.readonly {
+		return ErrReadOnly
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	return l.callErr(func() C.int { return C.lfs_setattr(l.lfs, cs, C.uint8_t(attr), bufptr(buf), C.lfs_size_t(len(buf))) })
+}
+
+// Removeattr removes the custom attribute of type attr from path; if there is
+// no such attribute, nothing happens
+func (l *LFS) Removeattr(path string, attr uint8) error {
+	if l.readonly {
+		return ErrReadOnly
+	}
+	cs := cstring(path)
+	defer C.free(unsafe.Pointer(cs))
+	return l.callErr(func() C.int { return C.lfs_removeattr(l.lfs, cs, C.uint8_t(attr)) })
 }
 
 func (l *LFS) Open(path string) (*File, error) {
 	return l.OpenFile(path, os.O_RDONLY)
 }
 
-func (l *LFS) OpenFile(path string, flags int) (*File, error) {
+// OpenFile opens the named file, following symbolic links
+func (l *LFS) OpenFile(path string, flags int) (_ *File, err error) {
+	s := l.begin(OpOpenFile, path)
+	defer func() { s.end(0, err) }()
+	resolved, err := l.resolve(path, followLast(flags))
+	if err != nil {
+		return nil, err
+	}
+	f, err := l.openResolved(resolved, flags, nil)
+	if err != nil {
+		return nil, err
+	}
+	f.name = path
+	return f, nil
+}
+
+// openResolved opens the named file, which must already be resolved, with
+// attrs as openFile does. The credential set with SetCredential has to allow
+// opening the file, and owns it if it is created; creating the file and
+// writing to it are held to the quotas of the directories above it, see
+// SetQuota; and a versioned file opened for writing saves its contents as a
+// revision before they are first changed, see SetVersions.
+func (l *LFS) openResolved(name string, flags int, attrs []*fileAttr) (*File, error) {
+	var create bool
+	if l.cred != nil {
+		var err error
+		if create, err = l.checkOpen(name, flags); err != nil {
+			return nil, err
+		}
+	}
+	if flags&os.O_CREATE != 0 && !l.readonly {
+		if err := l.checkCreate(name); err != nil {
+			return nil, err
+		}
+	}
+	f, err := l.openFile(name, flags, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if create {
+		perm := permissions{mode: modeCreate, uid: l.cred.Uid, gid: l.cred.Gid}
+		if err := l.Setattr(name, AttrPermissions, perm.encode()); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if f.writable() {
+		if f.quotas, err = l.quotaDirs(name); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err := f.trackRevisions(flags); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// fileAttr is a custom attribute attached to a file opened by openFile. If
+// the file is opened for reading, littlefs fills the attribute in from disk
+// when it is opened, or with zeros if there is none; if it is opened for
+// writing, the attribute is written atomically with the contents of the file
+// on every sync.
+type fileAttr struct {
+	typ uint8
+	buf []byte
+
+	// cbuf is the copy of buf which littlefs refers to while the file is open
+	cbuf unsafe.Pointer
+}
+
+func (l *LFS) openFile(path string, flags int, attrs []*fileAttr) (*File, error) {
+	if l.readonly && flags&writeFlags != 0 {
+		return nil, ErrReadOnly
+	}
 
 	cs := cstring(path)
 	defer C.free(unsafe.Pointer(cs))
-	file := &File{lfs: l, name: path}
+	file := &File{lfs: l, name: path, path: path}
 
 	var ftype fileType
 	info := C.struct_lfs_info{}
-	if err := errval(C.lfs_stat(l.lfs, cs, &info)); err == nil {
+	if err := l.callErr(func() C.int { return C.lfs_stat(l.lfs, cs, &info) }); err == nil {
 		ftype = fileType(info._type)
 	}
 
-	var errno C.int
+	var err error
 	if ftype == fileTypeDir {
 		file.typ = fileTypeDir
 		file.hndl = unsafe.Pointer(C.go_lfs_new_lfs_dir())
-		errno = C.lfs_dir_open(l.lfs, file.dirptr(), cs)
+		err = l.callErr(func() C.int { return C.lfs_dir_open(l.lfs, file.dirptr(), cs) })
+	} else if len(attrs) > 0 {
+		file.typ = fileTypeReg
+		file.hndl = unsafe.Pointer(C.go_lfs_new_lfs_file())
+		file.attrs = attrs
+		cattrs := C.go_lfs_new_lfs_attrs(C.lfs_size_t(len(attrs)))
+		for i, attr := range attrs {
+			attr.cbuf = C.calloc(1, C.size_t(len(attr.buf)))
+			C.go_lfs_set_attr(cattrs, C.lfs_size_t(i), C.uint8_t(attr.typ), attr.cbuf, C.lfs_size_t(len(attr.buf)))
+		}
+		file.cfg = C.go_lfs_new_lfs_file_config(cattrs, C.lfs_size_t(len(attrs)))
+		err = l.callErr(func() C.int {
+			return C.lfs_file_opencfg(l.lfs, file.fileptr(), cs, C.int(translateFlags(flags)), file.cfg)
+		})
+		if err == nil {
+			file.loadAttrs()
+		}
 	} else {
 		file.typ = fileTypeReg
 		file.hndl = unsafe.Pointer(C.go_lfs_new_lfs_file())
-		errno = C.lfs_file_open(l.lfs, file.fileptr(), cs, C.int(translateFlags(flags)))
+		err = l.callErr(func() C.int {
+			return C.lfs_file_open(l.lfs, file.fileptr(), cs, C.int(translateFlags(flags)))
+		})
 	}
 
-	if err := errval(errno); err != nil {
+	if err != nil {
 		if file.hndl != nil {
 			C.free(file.hndl)
 			file.hndl = nil
 		}
+		file.freeAttrs()
 		return nil, err
 	}
 
@@ -263,18 +650,62 @@ func (l *LFS) OpenFile(path string, flags int) (*File, error) {
 //
 // Returns the number of allocated blocks, or a negative error code on failure.
 func (l *LFS) Size() (n int, err error) {
-	errno := C.int(C.lfs_fs_size(l.lfs))
+	errno, err := l.call(func() C.int { return C.int(C.lfs_fs_size(l.lfs)) })
+	if err != nil {
+		return 0, err
+	}
 	if errno < 0 {
 		return 0, errval(errno)
 	}
 	return int(errno), nil
 }
 
+// Traverse calls fn for every block currently in use by the filesystem,
+// including blocks held by open files. A block may be reported more than once
+// if files share COW structures. If fn returns an error, the traversal stops
+// and that error is returned.
+func (l *LFS) Traverse(fn func(block uint32) error) error {
+	t := &traversal{fn: fn}
+	ptr := gopointer.Save(t)
+	defer gopointer.Unref(ptr)
+	err := l.callErr(func() C.int { return C.go_lfs_fs_traverse(l.lfs, ptr) })
+	if t.err != nil {
+		return t.err
+	}
+	return err
+}
+
+// traversal holds the state of a call to Traverse while it is in progress
+type traversal struct {
+	fn  func(block uint32) error
+	err error
+}
+
 type File struct {
 	lfs  *LFS
 	typ  fileType
 	hndl unsafe.Pointer
 	name string
+
+	// path is the path littlefs knows the file by, once symbolic links in
+	// name are followed
+	path string
+
+	// quotas are the directories above a file opened for writing which have
+	// a quota, see SetQuota
+	quotas []string
+
+	// privileged is set by SetPrivileged
+	privileged bool
+
+	// revise is set while the contents of a versioned file opened for
+	// writing still have to be saved as a revision before they change
+	revise bool
+
+	// cfg is the lfs_file_config holding the attributes the file was opened
+	// with by openFile
+	cfg   *C.struct_lfs_file_config
+	attrs []*fileAttr
 }
 
 func (f *File) dirptr() *C.struct_lfs_dir {
@@ -285,23 +716,89 @@ func (f *File) fileptr() *C.struct_lfs_file {
 	return (*C.struct_lfs_file)(f.hndl)
 }
 
+// inline reports whether the file is inlined in the metadata of its directory
+func (f *File) inline() bool {
+	return f.fileptr().flags&C.LFS_F_INLINE != 0
+}
+
+// allocates reports whether writing n bytes at pos makes littlefs allocate
+// blocks for the file: an inline file does once it outgrows its directory
+// entry, and any other one when it is first written to after opening,
+// seeking or syncing, and whenever the block being written fills up
+func (f *File) allocates(pos int64, n int64) bool {
+	if f.inline() {
+		return pos+n > f.lfs.inlineMax()
+	}
+	file := f.fileptr()
+	if file.flags&C.LFS_F_WRITING == 0 {
+		return true
+	}
+	return int64(file.off)+n > int64(f.lfs.cfg.block_size)
+}
+
+// appending reports whether the file was opened with os.O_APPEND
+func (f *File) appending() bool {
+	return f.fileptr().flags&C.LFS_O_APPEND != 0
+}
+
+// writable reports whether the file is a regular file opened for writing
+func (f *File) writable() bool {
+	return f.typ == fileTypeReg && f.fileptr().flags&C.LFS_O_WRONLY != 0
+}
+
 // Name returns the name of the file as presented to OpenFile
 func (f *File) Name() string {
 	return f.name
 }
 
+// loadAttrs copies the attributes read by littlefs into their Go buffers
+func (f *File) loadAttrs() {
+	for _, attr := range f.attrs {
+		copy(attr.buf, (*[1 << 28]byte)(attr.cbuf)[:len(attr.buf):len(attr.buf)])
+	}
+}
+
+// storeAttrs copies the attributes from their Go buffers for littlefs to
+// write on the next sync
+func (f *File) storeAttrs() {
+	for _, attr := range f.attrs {
+		copy((*[1 << 28]byte)(attr.cbuf)[:len(attr.buf):len(attr.buf)], attr.buf)
+	}
+}
+
+// freeAttrs releases the C memory held for the attributes once the file is
+// closed
+func (f *File) freeAttrs() {
+	if f.cfg == nil {
+		return
+	}
+	for _, attr := range f.attrs {
+		C.free(attr.cbuf)
+		attr.cbuf = nil
+	}
+	C.free(unsafe.Pointer(f.cfg.attrs))
+	C.free(unsafe.Pointer(f.cfg))
+	f.cfg = nil
+}
+
 // Close the file; any pending writes are written out to storage
-func (f *File) Close() error {
+func (f *File) Close() (err error) {
+	s := f.lfs.begin(OpClose, f.name)
+	defer func() { s.end(0, err) }()
 	if f.hndl != nil {
 		defer func() {
 			C.free(f.hndl)
 			f.hndl = nil
+			f.freeAttrs()
 		}()
+		f.storeAttrs()
 		switch f.typ {
 		case fileTypeReg:
-			return errval(C.lfs_file_close(f.lfs.lfs, f.fileptr()))
+			return f.scrub(func() error {
+				return f.lfs.callErr(func() C.int { return C.lfs_file_close(f.lfs.lfs, f.fileptr()) })
+			})
 		case fileTypeDir:
-			return errval(C.lfs_dir_close(f.lfs.lfs, f.dirptr()))
+			return f.lfs.callErr(func() C.int { return C.lfs_dir_close(f.lfs.lfs, f.dirptr()) })
 		default:
 			panic("lfs: unknown typ for file handle")
 		}
@@ -310,12 +807,19 @@ func (f *File) Close() error {
 }
 
 func (f *File) Read(buf []byte) (n int, err error) {
+	s := f.lfs.begin(OpRead, f.name)
+	defer func() { s.end(n, err) }()
 	if f.IsDir() {
 		return 0, ErrIsDir
 	}
 	bufptr := unsafe.Pointer(&buf[0])
 	buflen := C.lfs_size_t(len(buf))
-	errno := C.int(C.lfs_file_read(f.lfs.lfs, f.fileptr(), bufptr, buflen))
+	errno, err := f.lfs.call(func() C.int {
+		return C.int(C.lfs_file_read(f.lfs.lfs, f.fileptr(), bufptr, buflen))
+	})
+	if err != nil {
+		return 0, err
+	}
 	if errno > 0 {
 		return int(errno), nil
 	} else if errno == 0 {
@@ -328,7 +832,12 @@ func (f *File) Read(buf []byte) (n int, err error) {
 
 // Seek changes the position of the file
 func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
-	errno := C.int(C.lfs_file_seek(f.lfs.lfs, f.fileptr(), C.lfs_soff_t(offset), C.int(whence)))
+	errno, err := f.lfs.call(func() C.int {
+		return C.int(C.lfs_file_seek(f.lfs.lfs, f.fileptr(), C.lfs_soff_t(offset), C.int(whence)))
+	})
+	if err != nil {
+		return -1, err
+	}
 	if errno < 0 {
 		return -1, errval(errno)
 	}
@@ -337,7 +846,10 @@ func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
 
 // Tell returns the position of the file
 func (f *File) Tell() (ret int64, err error) {
-	errno := C.int(C.lfs_file_tell(f.lfs.lfs, f.fileptr()))
+	errno, err := f.lfs.call(func() C.int { return C.int(C.lfs_file_tell(f.lfs.lfs, f.fileptr())) })
+	if err != nil {
+		return -1, err
+	}
 	if errno < 0 {
 		return -1, errval(errno)
 	}
@@ -346,12 +858,15 @@ func (f *File) Tell() (ret int64, err error) {
 
 // Rewind changes the position of the file to the beginning of the file
 func (f *File) Rewind() (err error) {
-	return errval(C.lfs_file_rewind(f.lfs.lfs, f.fileptr()))
+	return f.lfs.callErr(func() C.int { return C.lfs_file_rewind(f.lfs.lfs, f.fileptr()) })
 }
 
 // Size returns the size of the file
 func (f *File) Size() (int64, error) {
-	errno := C.int(C.lfs_file_size(f.lfs.lfs, f.fileptr()))
+	errno, err := f.lfs.call(func() C.int { return C.int(C.lfs_file_size(f.lfs.lfs, f.fileptr())) })
+	if err != nil {
+		return -1, err
+	}
 	if errno < 0 {
 		return -1, errval(errno)
 	}
@@ -359,23 +874,66 @@ func (f *File) Size() (int64, error) {
 }
 
 // Sync synchronizes to storage so that any pending writes are written out.
-func (f *File) Sync() error {
-	return errval(C.lfs_file_sync(f.lfs.lfs, f.fileptr()))
+func (f *File) Sync() (err error) {
+	s := f.lfs.begin(OpSync, f.name)
+	defer func() { s.end(0, err) }()
+	if f.lfs.readonly {
+		return ErrReadOnly
+	}
+	f.storeAttrs()
+	return f.scrub(func() error {
+		return f.lfs.callErr(func() C.int { return C.lfs_file_sync(f.lfs.lfs, f.fileptr()) })
+	})
 }
 
 // Truncate the size of the file to the specified size
-func (f *File) Truncate(size uint32) error {
-	return errval(C.lfs_file_truncate(f.lfs.lfs, f.fileptr(), C.lfs_off_t(size)))
+func (f *File) Truncate(size uint32) (err error) {
+	s := f.lfs.begin(OpTruncate, f.name)
+	defer func() { s.end(0, err) }()
+	if f.lfs.readonly {
+		return ErrReadOnly
+	}
+	if err := f.checkTruncate(int64(size)); err != nil {
+		return err
+	}
+	if err := f.checkQuotaGrowth(int64(size)); err != nil {
+		return err
+	}
+	if err := f.saveRevision(); err != nil {
+		return err
+	}
+	return f.scrub(func() error {
+		return f.lfs.callErr(func() C.int { return C.lfs_file_truncate(f.lfs.lfs, f.fileptr(), C.lfs_off_t(size)) })
+	})
 }
 
 func (f *File) Write(buf []byte) (n int, err error) {
+	s := f.lfs.begin(OpWrite, f.name)
+	defer func() { s.end(n, err) }()
+	if f.lfs.readonly {
+		return 0, ErrReadOnly
+	}
+	if err := f.checkSpace(int64(len(buf))); err != nil {
+		return 0, err
+	}
+	if err := f.checkQuotaWrite(int64(len(buf))); err != nil {
+		return 0, err
+	}
+	if err := f.saveRevision(); err != nil {
+		return 0, err
+	}
 	bufptr := unsafe.Pointer(&buf[0])
 	buflen := C.lfs_size_t(len(buf))
-	errno := C.lfs_file_write(f.lfs.lfs, f.fileptr(), bufptr, buflen)
+	errno, err := f.lfs.call(func() C.int {
+		return C.int(C.lfs_file_write(f.lfs.lfs, f.fileptr(), bufptr, buflen))
+	})
+	if err != nil {
+		return 0, err
+	}
 	if errno > 0 {
 		return int(errno), nil
 	} else {
-		return 0, errval(C.int(errno))
+		return 0, errval(errno)
 	}
 }
 
@@ -392,24 +950,37 @@ func (f *File) Readdir(n int) (infos []os.FileInfo, err error) {
 	}
 	for {
 		var info C.struct_lfs_info
-		i := C.lfs_dir_read(f.lfs.lfs, f.dirptr(), &info)
+		var i C.int
+		if i, err = f.lfs.call(func() C.int { return C.lfs_dir_read(f.lfs.lfs, f.dirptr(), &info) }); err != nil {
+			return
+		}
 		if i == 0 {
 			return
 		}
 		if i < 0 {
-			err = errval(C.int(i))
+			err = errval(i)
 			return
 		}
 		name := gostring(&info.name[0])
 		if name == "." || name == ".." {
 			continue // littlefs returns . and .., but Readdir() in Go does not
 		}
-		infos = append(infos, &Info{
+		fi := &Info{
 			ftyp: fileType(info._type),
 			size: uint32(info.size),
 			name: name,
-		})
+		}
+		f.lfs.extendInfo(path.Join(f.path, name), fi)
+		infos = append(infos, fi)
+	}
+}
+
+// bufptr returns a pointer to the first byte of buf, or nil if it is empty
+func bufptr(buf []byte) unsafe.Pointer {
+	if len(buf) == 0 {
+		return nil
 	}
+	return unsafe.Pointer(&buf[0])
 }
 
 // would be nice to use C.CString instead, but TinyGo doesn't seem to support
@@ -11,6 +11,9 @@ extern int go_lfs_block_device_read(void*, lfs_block_t, lfs_off_t, void*, lfs_si
 extern int go_lfs_block_device_prog(void*, lfs_block_t, lfs_off_t, const void*, lfs_size_t);
 extern int go_lfs_block_device_erase(void*, lfs_block_t);
 extern int go_lfs_block_device_sync(void*);
+extern int go_lfs_traverse_block(void*, lfs_block_t);
+extern void go_lfs_log_message(void*, int, int, char*, int);
+extern void go_lfs_assert_failed(void*, char*, int, char*);
 
 // These are the global C callbacks. Pointers to these functions are passed to
 // the LittleFS library as the block device callbacks, and they in turn call
@@ -20,6 +23,7 @@ int go_lfs_c_cb_read(const struct lfs_config *c, lfs_block_t block, lfs_off_t of
 int go_lfs_c_cb_prog(const struct lfs_config *c, lfs_block_t block, lfs_off_t off, const void *buffer, lfs_size_t size);
 int go_lfs_c_cb_erase(const struct lfs_config *c, lfs_block_t block);
 int go_lfs_c_cb_sync(const struct lfs_config *c);
+int go_lfs_c_cb_traverse(void *data, lfs_block_t block);
 
 // Helper functions used to allocate new LFS objects, needed because TinyGo
 // does not support sizeof() yet
@@ -27,7 +31,19 @@ struct lfs* go_lfs_new_lfs(void);
 struct lfs_config* go_lfs_new_lfs_config(void);
 lfs_dir_t* go_lfs_new_lfs_dir(void);
 lfs_file_t* go_lfs_new_lfs_file(void);
+struct lfs_attr* go_lfs_new_lfs_attrs(lfs_size_t count);
+struct lfs_file_config* go_lfs_new_lfs_file_config(struct lfs_attr *attrs, lfs_size_t count);
+
+// Helper function to fill in an element of an array of custom attributes
+void go_lfs_set_attr(struct lfs_attr *attrs, lfs_size_t i, uint8_t type, void *buffer, lfs_size_t size);
 
 // Helper function to set the function pointers to the global callbacks on a
 // provided LFS config struct
 struct lfs_config* go_lfs_set_callbacks(struct lfs_config *cfg);
+
+// Maximum length of a formatted log message, longer messages are truncated
+#define GO_LFS_LOG_MAX 256
+
+// Helper function to call lfs_fs_traverse with the global traverse callback;
+// data is a pointer to the saved Go traversal state
+int go_lfs_fs_traverse(lfs_t *lfs, void *data);
@@ -25,12 +25,16 @@ func go_lfs_block_device_read(ctx unsafe.Pointer, block uint32, offset uint32, b
 	if debug {
 		fmt.Printf("go_lfs_block_device_read: %v, %v, %v, %v, %v\n", ctx, block, offset, buf, size)
 	}
+	l := restore(ctx)
 	buffer := (*[1 << 28]byte)(buf)[:size:size]
-	if err := restore(ctx).ReadBlock(block, offset, buffer); err != nil {
+	start := l.now()
+	err := l.readBlock(block, offset, buffer)
+	l.observeBlock(OpBlockRead, block, size, start, err)
+	if err != nil {
 		if debug {
 			println("read error:", err)
 		}
-		return int(ErrIO)
+		return errno(err)
 	}
 	return ErrOK
 }
@@ -40,12 +44,20 @@ func go_lfs_block_device_prog(ctx unsafe.Pointer, block uint32, offset uint32, b
 	if debug {
 		fmt.Printf("go_lfs_block_device_prog: %v, %v, %v, %v, %v\n", ctx, block, offset, buf, size)
 	}
+	l := restore(ctx)
+	if l.readonly {
+		return int(ErrReadOnly)
+	}
 	buffer := (*[1 << 28]byte)(buf)[:size:size]
-	if err := restore(ctx).ProgramBlock(block, offset, buffer); err != nil {
+	l.watermarks.programmed = true
+	start := l.now()
+	err := l.programBlock(block, offset, buffer)
+	l.observeBlock(OpBlockProgram, block, size, start, err)
+	if err != nil {
 		if debug {
 			println("program error:", err)
 		}
-		return int(ErrIO)
+		return errno(err)
 	}
 	return ErrOK
 }
@@ -55,11 +67,19 @@ func go_lfs_block_device_erase(ctx unsafe.Pointer, block uint32) int {
 	if debug {
 		fmt.Printf("go_lfs_block_device_erase: %v, %v\n", ctx, block)
 	}
-	if err := restore(ctx).EraseBlock(block); err != nil {
+	l := restore(ctx)
+	if l.readonly {
+		return int(ErrReadOnly)
+	}
+	l.watermarks.programmed = true
+	start := l.now()
+	err := l.eraseBlock(block)
+	l.observeBlock(OpBlockErase, block, int(l.cfg.block_size), start, err)
+	if err != nil {
 		if debug {
 			println("erase error:", err)
 		}
-		return int(ErrIO)
+		return errno(err)
 	}
 	return ErrOK
 }
@@ -69,15 +89,39 @@ func go_lfs_block_device_sync(ctx unsafe.Pointer) int {
 	if debug {
 		fmt.Printf("go_lfs_block_device_sync: %v\n", ctx)
 	}
-	if err := restore(ctx).Sync(); err != nil {
+	l := restore(ctx)
+	start := l.now()
+	err := l.sync()
+	l.observeBlock(OpBlockSync, 0, 0, start, err)
+	if err != nil {
 		if debug {
 			println("sync error:", err)
 		}
+		return errno(err)
+	}
+	return ErrOK
+}
+
+//export go_lfs_traverse_block
+func go_lfs_traverse_block(data unsafe.Pointer, block uint32) int {
+	t := gopointer.Restore(data).(*traversal)
+	if t.err = t.fn(block); t.err != nil {
 		return int(ErrIO)
 	}
 	return ErrOK
 }
 
-func restore(ptr unsafe.Pointer) BlockDevice {
-	return gopointer.Restore(ptr).(BlockDevice)
+func restore(ptr unsafe.Pointer) *LFS {
+	return gopointer.Restore(ptr).(*LFS)
+}
+
+// errno converts an error returned by a block device into an error code for
+// littlefs. Errors which are already littlefs errors (such as ErrCorrupt,
+// which tells littlefs that a block has gone bad) are passed through as-is;
+// anything else is reported as ErrIO.
+func errno(err error) int {
+	if e, ok := err.(Error); ok {
+		return int(e)
+	}
+	return int(ErrIO)
 }
@@ -0,0 +1,104 @@
+package lfs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"unsafe"
+)
+
+import "C"
+
+// LevelTrace is the level of the messages logged on entry to and exit from
+// each littlefs operation. They are only compiled in when building with the
+// lfs_trace build tag, and are very verbose.
+const LevelTrace = slog.LevelDebug - 4
+
+// logLevels maps the GO_LFS_LOG_* levels in lfs_util.h to slog levels
+var logLevels = [...]slog.Level{
+	LevelTrace,
+	slog.LevelDebug,
+	slog.LevelWarn,
+	slog.LevelError,
+}
+
+// SetLogger sets the logger that receives the diagnostics printed by
+// littlefs, such as bad blocks being relocated or a mount failing because
+// the superblock is unsupported. A nil logger discards them, which is the
+// default.
+func (l *LFS) SetLogger(logger *slog.Logger) {
+	l.logger = logger
+}
+
+// AssertionError is returned when one of the internal assertions in littlefs
+// fails, which indicates a bug or an invalid configuration. The call into
+// littlefs that failed is abandoned halfway through, so every operation on
+// the filesystem fails with the same error until it is mounted or formatted
+// again, or unmounted.
+type AssertionError struct {
+	File string
+	Line int
+	Expr string
+}
+
+func (err *AssertionError) Error() string {
+	return fmt.Sprintf("littlefs: assertion failed at %s:%d: %s", err.File, err.Line, err.Expr)
+}
+
+// call calls fn, which calls into littlefs, and returns what it returns. A
+// failed assertion panics through littlefs, and is recovered here and returned
+// as an error; it is returned by any later call as well.
+func (l *LFS) call(fn func() C.int) (_ C.int, err error) {
+	if l.asserted != nil {
+		return 0, l.asserted
+	}
+	defer l.recoverAssertion(&err)
+	return fn(), nil
+}
+
+// callErr is like call for the functions of littlefs which only return an
+// error code
+func (l *LFS) callErr(fn func() C.int) error {
+	errno, err := l.call(fn)
+	if err != nil {
+		return err
+	}
+	return errval(errno)
+}
+
+// recoverAssertion stores an AssertionError recovered from a panic in err, and
+// marks the filesystem as unusable until it is mounted again
+func (l *LFS) recoverAssertion(err *error) {
+	if r := recover(); r != nil {
+		aerr, ok := r.(*AssertionError)
+		if !ok {
+			panic(r)
+		}
+		l.asserted = aerr
+		*err = aerr
+	}
+}
+
+//export go_lfs_log_message
+func go_lfs_log_message(ctx unsafe.Pointer, level C.int, line C.int, msg *C.char, size C.int) {
+	l := restore(ctx)
+	if l.logger == nil || int(level) >= len(logLevels) {
+		return
+	}
+	lvl := logLevels[level]
+	if !l.logger.Enabled(context.Background(), lvl) {
+		return
+	}
+	buf := (*[1 << 28]byte)(unsafe.Pointer(msg))[:size:size]
+	l.logger.LogAttrs(context.Background(), lvl, string(buf),
+		slog.String("file", "lfs.c"), slog.Int("line", int(line)))
+}
+
+//export go_lfs_assert_failed
+func go_lfs_assert_failed(ctx unsafe.Pointer, file *C.char, line C.int, expr *C.char) {
+	err := &AssertionError{File: gostring(file), Line: int(line), Expr: gostring(expr)}
+	if l := restore(ctx); l.logger != nil {
+		l.logger.Error(err.Error())
+	}
+	panic(err)
+}
@@ -0,0 +1,99 @@
+package lfs
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: LevelTrace}))
+
+	// every program to an odd block fails, which littlefs logs as it
+	// relocates the data
+	dev := &badBlockDevice{BlockDevice: NewMemoryDevice(defaultConfig)}
+	fs := New(defaultConfig, dev)
+	fs.SetLogger(logger)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	dev.bad = func(block uint32) bool { return block%2 == 1 }
+	writeFileTest(t, fs, 4096, "file")
+	check(t, fs.Unmount())
+	if !strings.Contains(buf.String(), "level=DEBUG msg=\"Bad block at") {
+		t.Fatalf("expected bad blocks to be logged; got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "file=lfs.c line=") {
+		t.Fatalf("expected source location; got:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	fs = New(defaultConfig, NewMemoryDevice(defaultConfig))
+	fs.SetLogger(logger)
+	if err := fs.Mount(); err == nil {
+		t.Fatal("expected mounting a blank device to fail")
+	}
+	if !strings.Contains(buf.String(), "level=ERROR msg=\"Corrupted dir pair at 0 1\"") {
+		t.Fatalf("expected corrupt superblock to be logged; got:\n%s", buf.String())
+	}
+
+	// without a logger the messages are discarded
+	fs.SetLogger(nil)
+	if err := fs.Mount(); err == nil {
+		t.Fatal("expected mounting a blank device to fail")
+	}
+}
+
+func TestAssertion(t *testing.T) {
+	config := defaultConfig
+	config.CacheSize = 24 // not a multiple of the read size
+	fs := New(config, NewMemoryDevice(config))
+	err := fs.Format()
+	var aerr *AssertionError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("expected assertion error from format; got %v", err)
+	}
+	if aerr.File != "lfs.c" || aerr.Line == 0 || aerr.Expr != "lfs->cfg->cache_size % lfs->cfg->read_size == 0" {
+		t.Fatalf("unexpected assertion error: %#v", aerr)
+	}
+
+	// failed assertions are returned by any other operation too, and the
+	// filesystem cannot be used until it is mounted again
+	fs, _, unmount := createTestFS(t, defaultConfig)
+	defer unmount()
+	check(t, fs.WriteFile("file", []byte("contents")))
+	f, err := fs.OpenFile("file", os.O_WRONLY)
+	check(t, err)
+	// reading a file opened for writing only is a programming error that
+	// the wrapper leaves for littlefs to catch
+	if _, err := f.Read(make([]byte, 1)); !errors.As(err, &aerr) {
+		t.Fatalf("expected assertion error from read; got %v", err)
+	}
+	if _, err := fs.Stat("file"); err != aerr {
+		t.Errorf("expected the assertion error until mounted again; got %v", err)
+	}
+	if err := f.Close(); err != aerr {
+		t.Errorf("expected the assertion error until mounted again; got %v", err)
+	}
+	check(t, fs.Mount())
+	if data, err := fs.ReadFile("file"); err != nil || string(data) != "contents" {
+		t.Errorf("expected the file to be readable once mounted again; got %q, %v", data, err)
+	}
+}
+
+// badBlockDevice fails programs to the blocks for which bad returns true, as
+// a worn out flash block would
+type badBlockDevice struct {
+	BlockDevice
+	bad func(block uint32) bool
+}
+
+func (bd *badBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if bd.bad != nil && bd.bad(block) {
+		return ErrCorrupt
+	}
+	return bd.BlockDevice.ProgramBlock(block, offset, buf)
+}
@@ -1,6 +1,7 @@
 package lfs
 
 import (
+	"errors"
 	"io"
 	"math/rand"
 	"os"
@@ -239,6 +240,157 @@ func TestDirectories(t *testing.T) {
 	})
 }
 
+func TestReadOnly(t *testing.T) {
+	fs, bd, unmount := createTestFS(t, defaultConfig)
+	check(t, fs.Mkdir("factory"))
+	writeFileTest(t, fs, 1024, "factory/calibration")
+	unmount()
+
+	dev := &countingBlockDevice{BlockDevice: bd}
+	fs = New(defaultConfig, dev)
+	check(t, fs.MountReadOnly())
+	if !fs.ReadOnly() {
+		t.Fatal("expected filesystem to report being read-only")
+	}
+
+	expectReadOnly := func(t *testing.T, err error) {
+		if err != ErrReadOnly {
+			t.Fatalf("expected ErrReadOnly; was %v", err)
+		}
+		if !errors.Is(err, os.ErrPermission) {
+			t.Fatalf("expected %v to be a permission error", err)
+		}
+	}
+
+	t.Run("Reads", func(t *testing.T) {
+		readFileTest(t, fs, 1024, "factory/calibration")
+		dir, err := fs.Open("factory")
+		check(t, err)
+		infos, err := dir.Readdir(0)
+		check(t, err)
+		if len(infos) != 1 {
+			t.Fatalf("expected 1 directory entry; was %d", len(infos))
+		}
+		check(t, dir.Close())
+		if _, err := fs.Size(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("OpenForWriting", func(t *testing.T) {
+		for _, flags := range []int{
+			os.O_WRONLY,
+			os.O_RDWR,
+			os.O_RDONLY | os.O_CREATE,
+			os.O_RDONLY | os.O_TRUNC,
+			os.O_RDONLY | os.O_APPEND,
+			os.O_WRONLY | os.O_CREATE | os.O_EXCL,
+		} {
+			_, err := fs.OpenFile("factory/calibration", flags)
+			expectReadOnly(t, err)
+		}
+	})
+
+	t.Run("FileMutations", func(t *testing.T) {
+		f, err := fs.Open("factory/calibration")
+		check(t, err)
+		defer f.Close()
+		_, err = f.Write([]byte("oops"))
+		expectReadOnly(t, err)
+		expectReadOnly(t, f.Truncate(0))
+		expectReadOnly(t, f.Sync())
+	})
+
+	t.Run("FilesystemMutations", func(t *testing.T) {
+		expectReadOnly(t, fs.Mkdir("logs"))
+		expectReadOnly(t, fs.Remove("factory/calibration"))
+		expectReadOnly(t, fs.Rename("factory", "recovery"))
+		expectReadOnly(t, fs.Format())
+	})
+
+	check(t, fs.Unmount())
+	if dev.programs != 0 || dev.erases != 0 {
+		t.Fatalf("expected no writes; had %d programs and %d erases", dev.programs, dev.erases)
+	}
+
+	t.Run("Remount", func(t *testing.T) {
+		check(t, fs.Mount())
+		if fs.ReadOnly() {
+			t.Fatal("expected filesystem to be writable after regular mount")
+		}
+		check(t, fs.Mkdir("logs"))
+		check(t, fs.Unmount())
+	})
+}
+
+func TestReadOnlyBlockDevice(t *testing.T) {
+	fs, bd, unmount := createTestFS(t, defaultConfig)
+	writeFileTest(t, fs, 1024, "calibration")
+	unmount()
+
+	dev := &countingBlockDevice{BlockDevice: bd}
+	fs = New(defaultConfig, NewReadOnlyDevice(dev))
+	check(t, fs.Mount())
+	readFileTest(t, fs, 1024, "calibration")
+	if err := fs.Mkdir("logs"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected permission error from mkdir; was %v", err)
+	}
+	// depending on how much is buffered, the error may be reported by any of
+	// open, write or close
+	f, err := fs.OpenFile("calibration", os.O_WRONLY|os.O_APPEND)
+	if err == nil {
+		if _, err = f.Write(make([]byte, 1024)); err == nil {
+			err = f.Close()
+		} else {
+			f.Close()
+		}
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("expected permission error from append; was %v", err)
+	}
+	check(t, fs.Unmount())
+	if dev.programs != 0 || dev.erases != 0 {
+		t.Fatalf("expected no writes; had %d programs and %d erases", dev.programs, dev.erases)
+	}
+}
+
+func TestReadOnlyBlockDevicePowerCut(t *testing.T) {
+	base := NewMemoryDevice(defaultConfig)
+	fs := New(defaultConfig, base)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	check(t, fs.Mkdir("dir"))
+	check(t, fs.WriteFile("dir/file", []byte("contents")))
+	check(t, fs.Unmount())
+
+	// a power loss while renaming between directories, or while creating
+	// or removing one, leaves a move or an orphan for littlefs to deal with
+	// on the next change, which Mount must not make
+	for limit := 0; ; limit++ {
+		dev := base.Clone()
+		cut := &powerCutBlockDevice{BlockDevice: dev, limit: limit, halt: true}
+		untilPowerCut(func() {
+			fs := New(defaultConfig, cut)
+			if fs.Mount() != nil {
+				return
+			}
+			fs.Rename("dir/file", "file")
+			fs.Mkdir("dir/sub")
+			fs.Rename("file", "dir/sub/file")
+			fs.Remove("dir/sub/file")
+			fs.Remove("dir/sub")
+		})
+		fs := New(defaultConfig, NewReadOnlyDevice(dev))
+		if err := fs.Mount(); err != nil {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		check(t, fs.Unmount())
+		if !cut.cut() {
+			break
+		}
+	}
+}
+
 func createTestFS(t *testing.T, config Config) (*LFS, BlockDevice, func()) {
 	// create/format/mount the filesystem
 	bd := NewMemoryDevice(config)
@@ -320,6 +472,75 @@ func readFileTest(t *testing.T, lfs *LFS, size int, name string) {
 	}
 }
 
+// countingBlockDevice counts the operations that modify the device it wraps
+type countingBlockDevice struct {
+	BlockDevice
+	programs int
+	erases   int
+}
+
+func (bd *countingBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	bd.programs++
+	return bd.BlockDevice.ProgramBlock(block, offset, buf)
+}
+
+func (bd *countingBlockDevice) EraseBlock(block uint32) error {
+	bd.erases++
+	return bd.BlockDevice.EraseBlock(block)
+}
+
+// powerCutBlockDevice simulates a power loss by silently dropping every
+// program and erase after the first limit of them have been performed, or by
+// panicking with powerCut if halt is set, as if the CPU had stopped
+type powerCutBlockDevice struct {
+	BlockDevice
+	limit int
+	count int
+	halt  bool
+}
+
+// powerCut is the value a halting powerCutBlockDevice panics with
+type powerCut struct{}
+
+func (bd *powerCutBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if bd.count++; bd.count > bd.limit {
+		return bd.lost()
+	}
+	return bd.BlockDevice.ProgramBlock(block, offset, buf)
+}
+
+func (bd *powerCutBlockDevice) EraseBlock(block uint32) error {
+	if bd.count++; bd.count > bd.limit {
+		return bd.lost()
+	}
+	return bd.BlockDevice.EraseBlock(block)
+}
+
+func (bd *powerCutBlockDevice) lost() error {
+	if bd.halt {
+		panic(powerCut{})
+	}
+	return nil
+}
+
+// cut reports whether the simulated power loss has happened
+func (bd *powerCutBlockDevice) cut() bool {
+	return bd.count > bd.limit
+}
+
+// untilPowerCut runs fn, stopping it when a halting powerCutBlockDevice
+// loses power
+func untilPowerCut(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(powerCut); !ok {
+				panic(r)
+			}
+		}
+	}()
+	fn()
+}
+
 func check(t *testing.T, err error) {
 	if err != nil {
 		t.Fatal(err)
@@ -0,0 +1,10 @@
+//go:build lfs_trace
+// +build lfs_trace
+
+package lfs
+
+// Building with the lfs_trace tag compiles in the LFS_TRACE messages of
+// littlefs, which are logged at LevelTrace
+
+// #cgo CFLAGS: -DLFS_YES_TRACE
+import "C"
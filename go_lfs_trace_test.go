@@ -0,0 +1,21 @@
+//go:build lfs_trace
+// +build lfs_trace
+
+package lfs
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var buf bytes.Buffer
+	fs := New(defaultConfig, NewMemoryDevice(defaultConfig))
+	fs.SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: LevelTrace})))
+	check(t, fs.Format())
+	if !strings.Contains(buf.String(), "level=DEBUG-4 msg=\"lfs_format(") {
+		t.Fatalf("expected format to be traced; got:\n%s", buf.String())
+	}
+}
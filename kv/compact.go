@@ -0,0 +1,130 @@
+package kv
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"path"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+// The file of a compact store holds its keys in order, each as:
+//
+//	keylen uint8
+//	key    [keylen]byte
+//	vallen uint32
+//	value  [vallen]byte
+//
+// followed by the CRC-32 (IEEE) of everything before it. All integers are
+// little-endian.
+const (
+	compactName = "data"
+	compactTemp = "data.tmp"
+)
+
+// compact keeps all the keys in memory, and in a single file which is
+// replaced on every change
+type compact struct {
+	fs     *lfs.LFS
+	dir    string
+	values map[string][]byte
+}
+
+func openCompact(fs *lfs.LFS, dir string) (*compact, error) {
+	c := &compact{fs: fs, dir: dir, values: map[string][]byte{}}
+	if err := fs.Remove(path.Join(dir, compactTemp)); err != nil && err != lfs.ErrNoEntry {
+		return nil, err
+	}
+	data, err := fs.ReadFile(path.Join(dir, compactName))
+	if err == lfs.ErrNoEntry {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.decode(data) {
+		return nil, lfs.ErrCorrupt
+	}
+	return c, nil
+}
+
+func (c *compact) get(key string) ([]byte, error) {
+	value, ok := c.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (c *compact) keys(prefix string) ([]string, error) {
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	return filterKeys(keys, prefix), nil
+}
+
+func (c *compact) apply(ops []op) error {
+	values := make(map[string][]byte, len(c.values)+len(ops))
+	for key, value := range c.values {
+		values[key] = value
+	}
+	for _, op := range ops {
+		if op.value == nil {
+			delete(values, op.key)
+		} else {
+			values[op.key] = op.value
+		}
+	}
+	tmp := path.Join(c.dir, compactTemp)
+	if err := c.fs.WriteFile(tmp, encodeCompact(values)); err != nil {
+		c.fs.Remove(tmp)
+		return err
+	}
+	if err := c.fs.Rename(tmp, path.Join(c.dir, compactName)); err != nil {
+		return err
+	}
+	c.values = values
+	return nil
+}
+
+func encodeCompact(values map[string][]byte) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	var data []byte
+	for _, key := range filterKeys(keys, "") {
+		data = append(data, byte(len(key)))
+		data = append(data, key...)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(values[key])))
+		data = append(data, values[key]...)
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+func (c *compact) decode(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	body := data[:len(data)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[len(body):]) {
+		return false
+	}
+	for len(body) > 0 {
+		n := int(body[0])
+		if len(body) < 1+n+4 {
+			return false
+		}
+		key := string(body[1 : 1+n])
+		body = body[1+n:]
+		size := binary.LittleEndian.Uint32(body)
+		body = body[4:]
+		if uint32(len(body)) < size {
+			return false
+		}
+		c.values[key] = body[:size:size]
+		body = body[size:]
+	}
+	return true
+}
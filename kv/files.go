@@ -0,0 +1,230 @@
+package kv
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"hash/crc32"
+	"path"
+	"strconv"
+	"strings"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+// Names of the files kept alongside the keys; keys are hex encoded, so they
+// never start with a dot
+const (
+	journalName = ".journal"
+	journalTemp = ".journal.tmp"
+	tempPrefix  = ".t"
+)
+
+// The journal of a batch lists its operations in the order of their keys,
+// each as:
+//
+//	kind   byte  'p' to put or 'd' to delete
+//	keylen uint8
+//	key    [keylen]byte
+//
+// followed by the CRC-32 (IEEE) of everything before it, as a little-endian
+// uint32. The new value of the i-th operation, if it is a put, is in the
+// temporary file named tempPrefix followed by i.
+const (
+	journalPut    = 'p'
+	journalDelete = 'd'
+)
+
+// files keeps each key in a file of its own
+type files struct {
+	fs  *lfs.LFS
+	dir string
+}
+
+func openFiles(fs *lfs.LFS, dir string) (*files, error) {
+	f := &files{fs: fs, dir: dir}
+	return f, f.recover()
+}
+
+func (f *files) path(name string) string {
+	return path.Join(f.dir, name)
+}
+
+func (f *files) keyPath(key string) string {
+	return f.path(hex.EncodeToString([]byte(key)))
+}
+
+func tempName(i int) string {
+	return tempPrefix + strconv.Itoa(i)
+}
+
+func (f *files) get(key string) ([]byte, error) {
+	value, err := f.fs.ReadFile(f.keyPath(key))
+	if err == lfs.ErrNoEntry {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (f *files) keys(prefix string) ([]string, error) {
+	dir, err := f.fs.Open(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	infos, err := dir.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		key, err := hex.DecodeString(info.Name())
+		if err != nil {
+			continue
+		}
+		keys = append(keys, string(key))
+	}
+	return filterKeys(keys, prefix), nil
+}
+
+func (f *files) apply(ops []op) error {
+	// a single change is atomic by itself
+	if len(ops) == 1 {
+		if ops[0].value == nil {
+			return f.remove(f.keyPath(ops[0].key))
+		}
+		if err := f.fs.WriteFile(f.path(tempName(0)), ops[0].value); err != nil {
+			f.fs.Remove(f.path(tempName(0)))
+			return err
+		}
+		return f.fs.Rename(f.path(tempName(0)), f.keyPath(ops[0].key))
+	}
+
+	var journal []byte
+	for i, op := range ops {
+		kind := byte(journalDelete)
+		if op.value != nil {
+			kind = journalPut
+			if err := f.fs.WriteFile(f.path(tempName(i)), op.value); err != nil {
+				f.cleanup()
+				return err
+			}
+		}
+		journal = append(journal, kind, byte(len(op.key)))
+		journal = append(journal, op.key...)
+	}
+	journal = binary.LittleEndian.AppendUint32(journal, crc32.ChecksumIEEE(journal))
+	if err := f.fs.WriteFile(f.path(journalTemp), journal); err != nil {
+		f.cleanup()
+		return err
+	}
+	// the batch is committed once the journal has its name
+	if err := f.fs.Rename(f.path(journalTemp), f.path(journalName)); err != nil {
+		f.cleanup()
+		return err
+	}
+	if err := f.replay(ops); err != nil {
+		return err
+	}
+	return f.fs.Remove(f.path(journalName))
+}
+
+// replay makes the changes of a committed batch. Changes which were already
+// made are skipped, so a batch can be replayed again if it is interrupted.
+func (f *files) replay(ops []op) error {
+	for i, op := range ops {
+		if op.value == nil {
+			if err := f.remove(f.keyPath(op.key)); err != nil {
+				return err
+			}
+			continue
+		}
+		err := f.fs.Rename(f.path(tempName(i)), f.keyPath(op.key))
+		if err != nil && err != lfs.ErrNoEntry {
+			return err
+		}
+	}
+	return nil
+}
+
+// recover finishes a batch that was committed but not completely made, and
+// removes the temporary files of one that was not committed
+func (f *files) recover() error {
+	journal, err := f.fs.ReadFile(f.path(journalName))
+	switch err {
+	case nil:
+		ops, ok := decodeJournal(journal)
+		if !ok {
+			return lfs.ErrCorrupt
+		}
+		if err := f.replay(ops); err != nil {
+			return err
+		}
+		if err := f.fs.Remove(f.path(journalName)); err != nil {
+			return err
+		}
+	case lfs.ErrNoEntry:
+	default:
+		return err
+	}
+	return f.cleanup()
+}
+
+// cleanup removes any temporary files
+func (f *files) cleanup() error {
+	dir, err := f.fs.Open(f.dir)
+	if err != nil {
+		return err
+	}
+	infos, err := dir.Readdir(0)
+	dir.Close()
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if name := info.Name(); strings.HasPrefix(name, tempPrefix) || name == journalTemp {
+			if err := f.remove(f.path(name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *files) remove(name string) error {
+	if err := f.fs.Remove(name); err != nil && err != lfs.ErrNoEntry {
+		return err
+	}
+	return nil
+}
+
+// decodeJournal returns the operations listed in a journal; the values of
+// the puts are not known, so they are left empty rather than nil
+func decodeJournal(journal []byte) ([]op, bool) {
+	if len(journal) < 4 {
+		return nil, false
+	}
+	body := journal[:len(journal)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(journal[len(body):]) {
+		return nil, false
+	}
+	var ops []op
+	for len(body) > 0 {
+		if len(body) < 2 || len(body) < 2+int(body[1]) {
+			return nil, false
+		}
+		o := op{key: string(body[2 : 2+body[1]])}
+		switch body[0] {
+		case journalPut:
+			o.value = []byte{}
+		case journalDelete:
+		default:
+			return nil, false
+		}
+		ops = append(ops, o)
+		body = body[2+body[1]:]
+	}
+	return ops, true
+}
@@ -0,0 +1,350 @@
+// Package kv is a key-value store kept in a directory of a littlefs
+// filesystem.
+//
+//	s, err := kv.Open(fs, "/config", kv.Options{})
+//	s.PutString("wifi/ssid", "home")
+//	s.PutInt("boot/count", 3)
+//	err = s.Batch().PutString("wifi/ssid", "work").Delete("wifi/psk").Commit()
+//
+// By default each key is kept in a file of its own, named after the key, and
+// replaced by writing a temporary file and renaming it over the old one, so
+// that a power loss leaves either the old or the new value. A Batch changes
+// several keys at once: the new values are written to temporary files, and
+// an intent journal listing the renames and removals is committed before any
+// of them is made, so that an interrupted batch is finished when the store is
+// next opened.
+//
+// In compact mode all the keys are packed into a single file, which is
+// rewritten on every change. This takes much less space when there are many
+// small values, at the cost of rewriting every value on each change.
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+var (
+	// ErrNotFound is returned for a key which is not in the store
+	ErrNotFound = errors.New("kv: key not found")
+
+	// ErrType is returned by the typed getters for a value of another type
+	ErrType = errors.New("kv: value has another type")
+
+	// ErrInvalidKey is returned for a key which is empty or longer than
+	// MaxKeyLen
+	ErrInvalidKey = errors.New("kv: invalid key")
+)
+
+// MaxKeyLen is the longest key that can be stored; keys are hex encoded to
+// name files, which must fit in the longest name littlefs allows
+const MaxKeyLen = 120
+
+// Type is the type of a stored value
+type Type uint8
+
+const (
+	TypeBytes Type = iota + 1
+	TypeString
+	TypeInt
+	TypeUint
+	TypeFloat
+	TypeBool
+)
+
+// Options configures a Store
+type Options struct {
+	// Compact packs all the keys into a single file. A store must always be
+	// opened in the same mode.
+	Compact bool
+}
+
+// Store is a key-value store. It is not safe for concurrent use.
+type Store struct {
+	b backend
+}
+
+// backend is the way the keys of a store are kept
+type backend interface {
+	// get returns the encoded value of key
+	get(key string) ([]byte, error)
+
+	// keys returns the keys starting with prefix, in order
+	keys(prefix string) ([]string, error)
+
+	// apply makes all the changes in ops, which has at most one operation
+	// for each key, or none of them
+	apply(ops []op) error
+}
+
+// op is a change to one key; a nil value deletes the key
+type op struct {
+	key   string
+	value []byte
+}
+
+// Open opens the store in dir, creating the directory if it does not exist.
+// Any batch that was interrupted by a power loss is finished first.
+func Open(fs *lfs.LFS, dir string, opts Options) (*Store, error) {
+	if err := fs.Mkdir(dir); err != nil && err != lfs.ErrEntryExists {
+		return nil, err
+	}
+	var b backend
+	var err error
+	if opts.Compact {
+		b, err = openCompact(fs, dir)
+	} else {
+		b, err = openFiles(fs, dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Store{b: b}, nil
+}
+
+func checkKey(key string) error {
+	if len(key) == 0 || len(key) > MaxKeyLen {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Get returns the value of key, whatever its type
+func (s *Store) Get(key string) ([]byte, error) {
+	_, value, err := s.get(key)
+	return value, err
+}
+
+// TypeOf returns the type of the value of key
+func (s *Store) TypeOf(key string) (Type, error) {
+	t, _, err := s.get(key)
+	return t, err
+}
+
+func (s *Store) get(key string) (Type, []byte, error) {
+	if err := checkKey(key); err != nil {
+		return 0, nil, err
+	}
+	enc, err := s.b.get(key)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(enc) == 0 {
+		return 0, nil, lfs.ErrCorrupt
+	}
+	return Type(enc[0]), enc[1:], nil
+}
+
+// getType returns the value of key, which must be of type t and size bytes
+// long, unless size is negative
+func (s *Store) getType(key string, t Type, size int) ([]byte, error) {
+	vt, value, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if vt != t {
+		return nil, ErrType
+	}
+	if size >= 0 && len(value) != size {
+		return nil, lfs.ErrCorrupt
+	}
+	return value, nil
+}
+
+func (s *Store) GetString(key string) (string, error) {
+	value, err := s.getType(key, TypeString, -1)
+	return string(value), err
+}
+
+func (s *Store) GetInt(key string) (int64, error) {
+	value, err := s.getType(key, TypeInt, 8)
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(value)), nil
+}
+
+func (s *Store) GetUint(key string) (uint64, error) {
+	value, err := s.getType(key, TypeUint, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(value), nil
+}
+
+func (s *Store) GetFloat(key string) (float64, error) {
+	value, err := s.getType(key, TypeFloat, 8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(value)), nil
+}
+
+func (s *Store) GetBool(key string) (bool, error) {
+	value, err := s.getType(key, TypeBool, 1)
+	if err != nil {
+		return false, err
+	}
+	return value[0] != 0, nil
+}
+
+// Put sets key to value, as TypeBytes
+func (s *Store) Put(key string, value []byte) error {
+	return s.Batch().Put(key, value).Commit()
+}
+
+func (s *Store) PutString(key string, value string) error {
+	return s.Batch().PutString(key, value).Commit()
+}
+
+func (s *Store) PutInt(key string, value int64) error {
+	return s.Batch().PutInt(key, value).Commit()
+}
+
+func (s *Store) PutUint(key string, value uint64) error {
+	return s.Batch().PutUint(key, value).Commit()
+}
+
+func (s *Store) PutFloat(key string, value float64) error {
+	return s.Batch().PutFloat(key, value).Commit()
+}
+
+func (s *Store) PutBool(key string, value bool) error {
+	return s.Batch().PutBool(key, value).Commit()
+}
+
+// Delete removes key from the store; it is not an error if there is no such
+// key
+func (s *Store) Delete(key string) error {
+	return s.Batch().Delete(key).Commit()
+}
+
+// List returns the keys starting with prefix, in order
+func (s *Store) List(prefix string) ([]string, error) {
+	return s.b.keys(prefix)
+}
+
+// Iterate calls fn with each key starting with prefix and its value, in the
+// order of the keys. If fn returns an error, the iteration stops and that
+// error is returned.
+func (s *Store) Iterate(prefix string, fn func(key string, value []byte) error) error {
+	keys, err := s.b.keys(prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Batch collects changes to be made to the store together by Commit
+type Batch struct {
+	s   *Store
+	ops map[string][]byte
+	err error
+}
+
+// Batch starts a new set of changes
+func (s *Store) Batch() *Batch {
+	return &Batch{s: s, ops: map[string][]byte{}}
+}
+
+func (b *Batch) put(key string, t Type, value []byte) *Batch {
+	if err := checkKey(key); err != nil {
+		b.err = err
+		return b
+	}
+	b.ops[key] = append([]byte{byte(t)}, value...)
+	return b
+}
+
+// Put sets key to value, as TypeBytes
+func (b *Batch) Put(key string, value []byte) *Batch {
+	return b.put(key, TypeBytes, value)
+}
+
+func (b *Batch) PutString(key string, value string) *Batch {
+	return b.put(key, TypeString, []byte(value))
+}
+
+func (b *Batch) PutInt(key string, value int64) *Batch {
+	return b.PutUint(key, uint64(value)).retype(key, TypeInt)
+}
+
+func (b *Batch) PutUint(key string, value uint64) *Batch {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], value)
+	return b.put(key, TypeUint, buf[:])
+}
+
+func (b *Batch) PutFloat(key string, value float64) *Batch {
+	return b.PutUint(key, math.Float64bits(value)).retype(key, TypeFloat)
+}
+
+func (b *Batch) PutBool(key string, value bool) *Batch {
+	if value {
+		return b.put(key, TypeBool, []byte{1})
+	}
+	return b.put(key, TypeBool, []byte{0})
+}
+
+// retype changes the type of a value that was just put
+func (b *Batch) retype(key string, t Type) *Batch {
+	if value, ok := b.ops[key]; ok {
+		value[0] = byte(t)
+	}
+	return b
+}
+
+// Delete removes key from the store
+func (b *Batch) Delete(key string) *Batch {
+	if err := checkKey(key); err != nil {
+		b.err = err
+		return b
+	}
+	b.ops[key] = nil
+	return b
+}
+
+// Commit makes all the changes of the batch, or none of them if it fails.
+// The last change to each key in the batch is the one that is made.
+func (b *Batch) Commit() error {
+	if b.err != nil {
+		return b.err
+	}
+	ops := make([]op, 0, len(b.ops))
+	for key, value := range b.ops {
+		ops = append(ops, op{key: key, value: value})
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].key < ops[j].key
+	})
+	return b.s.b.apply(ops)
+}
+
+// filterKeys returns the sorted keys starting with prefix
+func filterKeys(keys []string, prefix string) []string {
+	var matched []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, key)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
@@ -0,0 +1,238 @@
+package kv_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	lfs "github.com/bgould/go-littlefs"
+	"github.com/bgould/go-littlefs/kv"
+)
+
+var config = lfs.Config{
+	ReadSize:      16,
+	ProgSize:      16,
+	BlockSize:     512,
+	BlockCount:    256,
+	CacheSize:     64,
+	LookaheadSize: 16,
+	BlockCycles:   500,
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+var modes = []struct {
+	name string
+	opts kv.Options
+}{
+	{"Files", kv.Options{}},
+	{"Compact", kv.Options{Compact: true}},
+}
+
+func TestStore(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			fs := lfs.New(config, lfs.NewMemoryDevice(config))
+			check(t, fs.Format())
+			check(t, fs.Mount())
+			defer fs.Unmount()
+			s, err := kv.Open(fs, "store", mode.opts)
+			check(t, err)
+
+			check(t, s.Put("raw", []byte{1, 2, 3}))
+			check(t, s.PutString("wifi/ssid", "home"))
+			check(t, s.PutString("wifi/psk", "secret"))
+			check(t, s.PutInt("boot/count", -3))
+			check(t, s.PutUint("boot/time", 1<<40))
+			check(t, s.PutFloat("temp", 21.5))
+			check(t, s.PutBool("enabled", true))
+
+			// reopening reads everything back from storage
+			s, err = kv.Open(fs, "store", mode.opts)
+			check(t, err)
+			if v, err := s.Get("raw"); err != nil || !reflect.DeepEqual(v, []byte{1, 2, 3}) {
+				t.Errorf("raw: got %v, %v", v, err)
+			}
+			if v, err := s.GetString("wifi/ssid"); err != nil || v != "home" {
+				t.Errorf("wifi/ssid: got %q, %v", v, err)
+			}
+			if v, err := s.GetInt("boot/count"); err != nil || v != -3 {
+				t.Errorf("boot/count: got %d, %v", v, err)
+			}
+			if v, err := s.GetUint("boot/time"); err != nil || v != 1<<40 {
+				t.Errorf("boot/time: got %d, %v", v, err)
+			}
+			if v, err := s.GetFloat("temp"); err != nil || v != 21.5 {
+				t.Errorf("temp: got %v, %v", v, err)
+			}
+			if v, err := s.GetBool("enabled"); err != nil || !v {
+				t.Errorf("enabled: got %v, %v", v, err)
+			}
+			if _, err := s.GetInt("wifi/ssid"); err != kv.ErrType {
+				t.Errorf("expected ErrType; got %v", err)
+			}
+			if typ, err := s.TypeOf("temp"); err != nil || typ != kv.TypeFloat {
+				t.Errorf("expected TypeFloat; got %v, %v", typ, err)
+			}
+			if _, err := s.Get("missing"); err != kv.ErrNotFound {
+				t.Errorf("expected ErrNotFound; got %v", err)
+			}
+			if err := s.Put("", nil); err != kv.ErrInvalidKey {
+				t.Errorf("expected ErrInvalidKey; got %v", err)
+			}
+
+			keys, err := s.List("wifi/")
+			check(t, err)
+			if !reflect.DeepEqual(keys, []string{"wifi/psk", "wifi/ssid"}) {
+				t.Errorf("unexpected keys %q", keys)
+			}
+			var iterated []string
+			check(t, s.Iterate("boot/", func(key string, value []byte) error {
+				iterated = append(iterated, key)
+				return nil
+			}))
+			if !reflect.DeepEqual(iterated, []string{"boot/count", "boot/time"}) {
+				t.Errorf("unexpected keys %q", iterated)
+			}
+
+			check(t, s.Batch().
+				PutString("wifi/ssid", "work").
+				Delete("wifi/psk").
+				PutInt("boot/count", 4).
+				Commit())
+			check(t, s.Delete("raw"))
+			check(t, s.Delete("raw"))
+			keys, err = s.List("")
+			check(t, err)
+			if !reflect.DeepEqual(keys, []string{"boot/count", "boot/time", "enabled", "temp", "wifi/ssid"}) {
+				t.Errorf("unexpected keys %q", keys)
+			}
+			if v, _ := s.GetString("wifi/ssid"); v != "work" {
+				t.Errorf("expected batch to change wifi/ssid; got %q", v)
+			}
+
+			// nothing but the keys, or the packed file, is left behind
+			dir, err := fs.Open("store")
+			check(t, err)
+			infos, err := dir.Readdir(0)
+			check(t, err)
+			check(t, dir.Close())
+			want := len(keys)
+			if mode.opts.Compact {
+				want = 1
+			}
+			if len(infos) != want {
+				t.Errorf("expected %d files in the store; got %d", want, len(infos))
+			}
+		})
+	}
+}
+
+// powerCut is the value cutDevice panics with
+type powerCut struct{}
+
+// cutDevice simulates a power loss by stopping the program, like the CPU
+// would, once limit programs and erases have been performed
+type cutDevice struct {
+	lfs.BlockDevice
+	limit int
+	count int
+}
+
+func (bd *cutDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if bd.count++; bd.count > bd.limit {
+		panic(powerCut{})
+	}
+	return bd.BlockDevice.ProgramBlock(block, offset, buf)
+}
+
+func (bd *cutDevice) EraseBlock(block uint32) error {
+	if bd.count++; bd.count > bd.limit {
+		panic(powerCut{})
+	}
+	return bd.BlockDevice.EraseBlock(block)
+}
+
+func TestPowerCut(t *testing.T) {
+	const keys = 8
+	for _, mode := range modes {
+		t.Run(mode.name, func(t *testing.T) {
+			base := lfs.NewMemoryDevice(config)
+			fs := lfs.New(config, base)
+			check(t, fs.Format())
+			check(t, fs.Mount())
+			s, err := kv.Open(fs, "store", mode.opts)
+			check(t, err)
+			b := s.Batch()
+			for i := 0; i < keys; i++ {
+				b.PutString(fmt.Sprintf("key%d", i), "old")
+			}
+			check(t, b.Commit())
+			check(t, fs.Unmount())
+
+			for limit := 0; ; limit++ {
+				dev := base.Clone()
+				cut := &cutDevice{BlockDevice: dev, limit: limit}
+				func() {
+					defer func() {
+						if r := recover(); r != nil {
+							if _, ok := r.(powerCut); !ok {
+								panic(r)
+							}
+						}
+					}()
+					fs := lfs.New(config, cut)
+					if err := fs.Mount(); err != nil {
+						return
+					}
+					s, err := kv.Open(fs, "store", mode.opts)
+					if err != nil {
+						return
+					}
+					b := s.Batch()
+					for i := 0; i < keys; i++ {
+						b.PutString(fmt.Sprintf("key%d", i), "new")
+					}
+					b.Delete("key0")
+					b.Commit()
+				}()
+
+				// every key must have been changed, or none of them
+				fs := lfs.New(config, dev)
+				if err := fs.Mount(); err != nil {
+					t.Fatalf("limit %d: %v", limit, err)
+				}
+				s, err := kv.Open(fs, "store", mode.opts)
+				if err != nil {
+					t.Fatalf("limit %d: %v", limit, err)
+				}
+				values := map[string]int{}
+				for i := 0; i < keys; i++ {
+					v, err := s.GetString(fmt.Sprintf("key%d", i))
+					if err == kv.ErrNotFound {
+						v = "deleted"
+					} else if err != nil {
+						t.Fatalf("limit %d: %v", limit, err)
+					}
+					values[v]++
+				}
+				if values["old"] != keys && (values["new"] != keys-1 || values["deleted"] != 1) {
+					t.Fatalf("limit %d: batch was partly made: %v", limit, values)
+				}
+				check(t, fs.Unmount())
+				if cut.count <= cut.limit {
+					if values["new"] != keys-1 {
+						t.Fatalf("expected the batch to be made without a power cut")
+					}
+					t.Logf("checked %d power cuts", limit)
+					break
+				}
+			}
+		})
+	}
+}
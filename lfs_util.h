@@ -49,6 +49,34 @@ extern "C"
 // code footprint
 
 // Logging functions
+#ifdef LFS_GO_LOG
+// When built as part of the Go package, diagnostics are delivered to the
+// logger of the LFS instance and assertions panic in Go, see go_lfs_log.go.
+// These macros are only used where the lfs_t pointer is in scope as lfs.
+void go_lfs_log(const void *lfs, int level, int line, const char *fmt, ...);
+void go_lfs_assert(const void *lfs, const char *file, int line, const char *expr);
+
+#define GO_LFS_LOG_TRACE 0
+#define GO_LFS_LOG_DEBUG 1
+#define GO_LFS_LOG_WARN  2
+#define GO_LFS_LOG_ERROR 3
+
+#ifdef LFS_YES_TRACE
+#define LFS_TRACE(fmt, ...) \
+    go_lfs_log(lfs, GO_LFS_LOG_TRACE, __LINE__, fmt, __VA_ARGS__)
+#else
+#define LFS_TRACE(fmt, ...)
+#endif
+#define LFS_DEBUG(fmt, ...) \
+    go_lfs_log(lfs, GO_LFS_LOG_DEBUG, __LINE__, fmt, __VA_ARGS__)
+#define LFS_WARN(fmt, ...) \
+    go_lfs_log(lfs, GO_LFS_LOG_WARN, __LINE__, fmt, __VA_ARGS__)
+#define LFS_ERROR(fmt, ...) \
+    go_lfs_log(lfs, GO_LFS_LOG_ERROR, __LINE__, fmt, __VA_ARGS__)
+#define LFS_ASSERT(test) \
+    do { if (!(test)) go_lfs_assert(lfs, __FILE__, __LINE__, #test); } while (0)
+#else
+
 #ifdef LFS_YES_TRACE
 #define LFS_TRACE(fmt, ...) \
     printf("lfs_trace:%d: " fmt "\n", __LINE__, __VA_ARGS__)
@@ -83,6 +111,7 @@ extern "C"
 #else
 #define LFS_ASSERT(test)
 #endif
+#endif // LFS_GO_LOG
 
 
 // Builtin functions, these may be replaced by more efficient
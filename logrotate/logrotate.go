@@ -0,0 +1,324 @@
+// Package logrotate keeps an append-only log of records on littlefs, split
+// across a bounded number of files so that the oldest records are discarded
+// as new ones are written.
+//
+//	w, err := logrotate.Open(fs, "events.log", logrotate.Config{MaxSize: 4096, MaxFiles: 3})
+//	w.Write([]byte("booted"))
+//	w.Sync()
+//
+//	r := logrotate.NewReader(fs, "events.log", logrotate.Config{MaxFiles: 3})
+//	for {
+//		record, err := r.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+//
+// The newest records are in the file with the given name, and older ones in
+// the same name followed by .1, .2, and so on up to MaxFiles, which holds the
+// oldest. Each record is framed with its length and a CRC-32, so that a record
+// which was only partly written before a power loss is recognised and skipped.
+package logrotate
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+// ErrRecordTooLarge is returned by Write for a record which could never fit
+// in a file of MaxSize bytes
+var ErrRecordTooLarge = errors.New("logrotate: record too large")
+
+// headerSize is the size of the frame in front of each record:
+//
+//	length uint32  length of the record
+//	crc    uint32  CRC-32 (IEEE) of the record
+//
+// Both are little-endian.
+const headerSize = 8
+
+// Config controls when a log is rotated
+type Config struct {
+	// MaxSize is the size in bytes, including framing, that the current file
+	// may reach before it is rotated; zero means no limit
+	MaxSize int64
+
+	// MaxFiles is the number of rotated files kept besides the current one
+	MaxFiles int
+}
+
+// name returns the name of the file holding the records that were rotated i
+// times, where 0 is the current file
+func name(base string, i int) string {
+	if i == 0 {
+		return base
+	}
+	return base + "." + strconv.Itoa(i)
+}
+
+// Writer appends records to a log
+type Writer struct {
+	fs     *lfs.LFS
+	name   string
+	config Config
+	f      *lfs.File
+	size   int64
+}
+
+// Open opens the log with the given name for appending, creating it if it
+// does not exist. A torn record at the end of the current file is cut off,
+// so that the records written after it can be read.
+func Open(fs *lfs.LFS, name string, config Config) (*Writer, error) {
+	w := &Writer{fs: fs, name: name, config: config}
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE)
+	if err != nil {
+		return nil, err
+	}
+	w.f = f
+	size, err := f.Size()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	valid, err := scan(f, size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if valid != size {
+		if err := f.Truncate(uint32(valid)); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.size = valid
+	return w, nil
+}
+
+// scan returns the offset of the end of the last intact record in f
+func scan(f *lfs.File, size int64) (int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	var off int64
+	for {
+		n, _, err := readRecord(f, size-off, nil)
+		if err == errTorn || err == io.EOF {
+			return off, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		off += n
+	}
+}
+
+// Write appends record to the log as a single record, rotating the log
+// first if the record would take the current file past MaxSize. The record
+// is not committed to storage until Sync or Close.
+func (w *Writer) Write(record []byte) (int, error) {
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	frame := int64(headerSize + len(record))
+	if w.config.MaxSize > 0 {
+		if frame > w.config.MaxSize {
+			return 0, ErrRecordTooLarge
+		}
+		if w.size > 0 && w.size+frame > w.config.MaxSize {
+			if err := w.Rotate(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	buf := make([]byte, frame)
+	binary.LittleEndian.PutUint32(buf, uint32(len(record)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(record))
+	copy(buf[headerSize:], record)
+	if _, err := w.f.Write(buf); err != nil {
+		return 0, err
+	}
+	w.size += frame
+	return len(record), nil
+}
+
+// Rotate closes the current file and starts a new one. Each file is renamed
+// to the next number in turn, starting with the oldest, which is replaced;
+// every rename is atomic, so a power loss part way through leaves at most a
+// gap in the numbering, which Reader skips over. If Rotate fails, the Writer
+// is closed.
+func (w *Writer) Rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	w.f = nil
+	for i := w.config.MaxFiles - 1; i >= 0; i-- {
+		if _, err := w.fs.Stat(name(w.name, i)); err == lfs.ErrNoEntry {
+			continue
+		}
+		if err := w.fs.Rename(name(w.name, i), name(w.name, i+1)); err != nil {
+			return err
+		}
+	}
+	f, err := w.fs.OpenFile(w.name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	w.f = f
+	w.size = 0
+	return nil
+}
+
+// Sync commits the records written so far to storage
+func (w *Writer) Sync() error {
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	return w.f.Sync()
+}
+
+// Close commits the records written so far and closes the log
+func (w *Writer) Close() error {
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// errTorn reports a record which was not completely written
+var errTorn = errors.New("logrotate: torn record")
+
+// readRecord reads the record at the current position of f, which has
+// remaining bytes left, appending it to buf. It returns the size of the
+// record including its frame.
+func readRecord(f *lfs.File, remaining int64, buf []byte) (int64, []byte, error) {
+	if remaining == 0 {
+		return 0, buf, io.EOF
+	}
+	var hdr [headerSize]byte
+	if remaining < headerSize {
+		return 0, buf, errTorn
+	}
+	if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		return 0, buf, err
+	}
+	length := int64(binary.LittleEndian.Uint32(hdr[:]))
+	if length > remaining-headerSize {
+		return 0, buf, errTorn
+	}
+	start := len(buf)
+	buf = append(buf, make([]byte, length)...)
+	if length > 0 {
+		if _, err := io.ReadFull(f, buf[start:]); err != nil {
+			return 0, buf[:start], err
+		}
+	}
+	if crc32.ChecksumIEEE(buf[start:]) != binary.LittleEndian.Uint32(hdr[4:]) {
+		return 0, buf[:start], errTorn
+	}
+	return headerSize + length, buf, nil
+}
+
+// Reader iterates over the records of a log, from the oldest to the newest.
+// A torn record ends the file it is in, and reading continues with the next
+// file. The log should not be written while it is being read.
+type Reader struct {
+	fs     *lfs.LFS
+	name   string
+	config Config
+
+	// next is the number of the file to read after the current one
+	next      int
+	f         *lfs.File
+	remaining int64
+	torn      int
+}
+
+// NewReader returns a Reader for the log with the given name; only MaxFiles
+// of config is used
+func NewReader(fs *lfs.LFS, name string, config Config) *Reader {
+	return &Reader{fs: fs, name: name, config: config, next: config.MaxFiles}
+}
+
+// Next returns the next record, or io.EOF once all the files have been read
+func (r *Reader) Next() ([]byte, error) {
+	for {
+		if r.f == nil {
+			if r.next < 0 {
+				return nil, io.EOF
+			}
+			if err := r.open(name(r.name, r.next)); err != nil {
+				return nil, err
+			}
+			r.next--
+			continue
+		}
+		n, record, err := readRecord(r.f, r.remaining, nil)
+		if err == nil {
+			r.remaining -= n
+			return record, nil
+		}
+		if err == errTorn {
+			r.torn++
+		} else if err != io.EOF {
+			return nil, err
+		}
+		if err := r.closeFile(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// open opens the named file, if it exists
+func (r *Reader) open(name string) error {
+	f, err := r.fs.Open(name)
+	if err == lfs.ErrNoEntry {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	size, err := f.Size()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.remaining = f, size
+	return nil
+}
+
+func (r *Reader) closeFile() error {
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// Torn returns the number of torn records that have been skipped
+func (r *Reader) Torn() int {
+	return r.torn
+}
+
+// Close releases the file being read, if any
+func (r *Reader) Close() error {
+	if r.f == nil {
+		return nil
+	}
+	return r.closeFile()
+}
@@ -0,0 +1,186 @@
+package logrotate_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	lfs "github.com/bgould/go-littlefs"
+	"github.com/bgould/go-littlefs/logrotate"
+)
+
+var config = lfs.Config{
+	ReadSize:      16,
+	ProgSize:      16,
+	BlockSize:     512,
+	BlockCount:    256,
+	CacheSize:     64,
+	LookaheadSize: 16,
+	BlockCycles:   500,
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newFS(t *testing.T) *lfs.LFS {
+	fs := lfs.New(config, lfs.NewMemoryDevice(config))
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	return fs
+}
+
+func record(i int) string {
+	return fmt.Sprintf("record %04d", i)
+}
+
+// readAll returns the records of the log, failing if they are not
+// consecutive
+func readAll(t *testing.T, fs *lfs.LFS, name string, rc logrotate.Config) (first, last int) {
+	t.Helper()
+	r := logrotate.NewReader(fs, name, rc)
+	defer r.Close()
+	first, last = -1, -1
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return
+		}
+		check(t, err)
+		var i int
+		if _, err := fmt.Sscanf(string(rec), "record %d", &i); err != nil {
+			t.Fatalf("unexpected record %q", rec)
+		}
+		if first < 0 {
+			first = i
+		} else if i != last+1 {
+			t.Fatalf("expected record %d after %d; got %d", last+1, last, i)
+		}
+		last = i
+	}
+}
+
+func TestLogrotate(t *testing.T) {
+	rc := logrotate.Config{MaxSize: 200, MaxFiles: 3}
+
+	t.Run("Rotation", func(t *testing.T) {
+		fs := newFS(t)
+		defer fs.Unmount()
+		w, err := logrotate.Open(fs, "log", rc)
+		check(t, err)
+		for i := 0; i < 100; i++ {
+			_, err := w.Write([]byte(record(i)))
+			check(t, err)
+		}
+		check(t, w.Close())
+
+		for i := 0; i <= rc.MaxFiles; i++ {
+			name := "log"
+			if i > 0 {
+				name = fmt.Sprintf("log.%d", i)
+			}
+			info, err := fs.Stat(name)
+			check(t, err)
+			if info.Size() > rc.MaxSize {
+				t.Errorf("%s: expected at most %d bytes; got %d", name, rc.MaxSize, info.Size())
+			}
+		}
+		if _, err := fs.Stat(fmt.Sprintf("log.%d", rc.MaxFiles+1)); err != lfs.ErrNoEntry {
+			t.Errorf("expected only %d rotated files; got %v", rc.MaxFiles, err)
+		}
+		// each file holds 10 records of 19 bytes
+		if first, last := readAll(t, fs, "log", rc); first != 60 || last != 99 {
+			t.Errorf("expected records 60 to 99; got %d to %d", first, last)
+		}
+
+		// appending carries on where the log left off
+		w, err = logrotate.Open(fs, "log", rc)
+		check(t, err)
+		_, err = w.Write([]byte(record(100)))
+		check(t, err)
+		check(t, w.Close())
+		if _, last := readAll(t, fs, "log", rc); last != 100 {
+			t.Errorf("expected last record 100; got %d", last)
+		}
+
+		w, err = logrotate.Open(fs, "log", rc)
+		check(t, err)
+		if _, err := w.Write(make([]byte, 200)); err != logrotate.ErrRecordTooLarge {
+			t.Errorf("expected ErrRecordTooLarge; got %v", err)
+		}
+		check(t, w.Close())
+	})
+
+	t.Run("TornTail", func(t *testing.T) {
+		fs := newFS(t)
+		defer fs.Unmount()
+		w, err := logrotate.Open(fs, "log", rc)
+		check(t, err)
+		for i := 0; i < 25; i++ {
+			_, err := w.Write([]byte(record(i)))
+			check(t, err)
+		}
+		check(t, w.Close())
+
+		// the start of a record whose data never made it to storage
+		for _, name := range []string{"log.1", "log"} {
+			f, err := fs.OpenFile(name, os.O_WRONLY|os.O_APPEND)
+			check(t, err)
+			_, err = f.Write([]byte{11, 0, 0, 0, 1, 2, 3, 4, 'r', 'e'})
+			check(t, err)
+			check(t, f.Close())
+		}
+		r := logrotate.NewReader(fs, "log", rc)
+		n := 0
+		for {
+			_, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			check(t, err)
+			n++
+		}
+		check(t, r.Close())
+		if n != 25 || r.Torn() != 2 {
+			t.Errorf("expected 25 records and 2 torn; got %d and %d", n, r.Torn())
+		}
+
+		// the writer cuts off the torn record, so what follows can be read
+		w, err = logrotate.Open(fs, "log", rc)
+		check(t, err)
+		_, err = w.Write([]byte(record(25)))
+		check(t, err)
+		check(t, w.Close())
+		if first, last := readAll(t, fs, "log", rc); first != 0 || last != 25 {
+			t.Errorf("expected records 0 to 25; got %d to %d", first, last)
+		}
+	})
+
+	t.Run("Gap", func(t *testing.T) {
+		fs := newFS(t)
+		defer fs.Unmount()
+		w, err := logrotate.Open(fs, "log", rc)
+		check(t, err)
+		for i := 0; i < 40; i++ {
+			_, err := w.Write([]byte(record(i)))
+			check(t, err)
+		}
+		check(t, w.Close())
+		// as if power was lost between renames while rotating
+		check(t, fs.Rename("log.2", "log.3"))
+		if first, last := readAll(t, fs, "log", rc); first != 10 || last != 39 {
+			t.Errorf("expected records 10 to 39; got %d to %d", first, last)
+		}
+		w, err = logrotate.Open(fs, "log", rc)
+		check(t, err)
+		check(t, w.Rotate())
+		check(t, w.Close())
+		if first, last := readAll(t, fs, "log", rc); first != 10 || last != 39 {
+			t.Errorf("expected records 10 to 39 after rotating; got %d to %d", first, last)
+		}
+	})
+}
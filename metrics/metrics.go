@@ -0,0 +1,228 @@
+// Package metrics collects the events reported by an lfs.Observer into
+// counters that can be published with expvar or scraped by Prometheus.
+//
+//	c := metrics.NewCollector()
+//	fs.SetObserver(c)
+//	expvar.Publish("littlefs", c)
+//	http.Handle("/metrics", c)
+package metrics
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	lfs "github.com/bgould/go-littlefs"
+)
+
+// Stats are the totals for one kind of operation
+type Stats struct {
+	Count    uint64
+	Errors   uint64
+	Bytes    uint64
+	Duration time.Duration
+}
+
+func (s *Stats) add(e lfs.Event) {
+	s.Count++
+	if e.Err != nil {
+		s.Errors++
+	}
+	s.Bytes += uint64(e.Bytes)
+	s.Duration += e.Duration
+}
+
+// BlockKey identifies the block device callbacks made on behalf of a public
+// operation, such as the erases caused by writes
+type BlockKey struct {
+	Op     lfs.Op
+	Parent lfs.Op
+}
+
+// Collector is an lfs.Observer that keeps running totals of the operations
+// it observes. It is safe to read from other goroutines while the filesystem
+// is in use, and implements expvar.Var and http.Handler.
+type Collector struct {
+	mu     sync.Mutex
+	ops    map[lfs.Op]*Stats
+	blocks map[BlockKey]*Stats
+}
+
+// NewCollector returns an empty collector
+func NewCollector() *Collector {
+	return &Collector{
+		ops:    map[lfs.Op]*Stats{},
+		blocks: map[BlockKey]*Stats{},
+	}
+}
+
+// Observe adds the event to the totals
+func (c *Collector) Observe(e lfs.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var s *Stats
+	if e.Op.IsBlock() {
+		key := BlockKey{Op: e.Op, Parent: e.Parent}
+		if s = c.blocks[key]; s == nil {
+			s = &Stats{}
+			c.blocks[key] = s
+		}
+	} else {
+		if s = c.ops[e.Op]; s == nil {
+			s = &Stats{}
+			c.ops[e.Op] = s
+		}
+	}
+	s.add(e)
+}
+
+// Ops returns the totals of the public operations
+func (c *Collector) Ops() map[lfs.Op]Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ops := make(map[lfs.Op]Stats, len(c.ops))
+	for op, s := range c.ops {
+		ops[op] = *s
+	}
+	return ops
+}
+
+// Blocks returns the totals of the block device callbacks, by the public
+// operation that caused them
+func (c *Collector) Blocks() map[BlockKey]Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	blocks := make(map[BlockKey]Stats, len(c.blocks))
+	for key, s := range c.blocks {
+		blocks[key] = *s
+	}
+	return blocks
+}
+
+// Reset clears the totals
+func (c *Collector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ops = map[lfs.Op]*Stats{}
+	c.blocks = map[BlockKey]*Stats{}
+}
+
+type jsonStats struct {
+	Count   uint64  `json:"count"`
+	Errors  uint64  `json:"errors"`
+	Bytes   uint64  `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+}
+
+func toJSON(s Stats) jsonStats {
+	return jsonStats{s.Count, s.Errors, s.Bytes, s.Duration.Seconds()}
+}
+
+// String returns the totals as JSON, which makes the collector an expvar.Var.
+// Block callbacks are grouped by operation and then by the public operation
+// that caused them, such as {"block_erase": {"write": {...}}}.
+func (c *Collector) String() string {
+	v := struct {
+		Ops    map[string]jsonStats            `json:"ops"`
+		Blocks map[string]map[string]jsonStats `json:"blocks"`
+	}{
+		Ops:    map[string]jsonStats{},
+		Blocks: map[string]map[string]jsonStats{},
+	}
+	for op, s := range c.Ops() {
+		v.Ops[op.String()] = toJSON(s)
+	}
+	for key, s := range c.Blocks() {
+		m := v.Blocks[key.Op.String()]
+		if m == nil {
+			m = map[string]jsonStats{}
+			v.Blocks[key.Op.String()] = m
+		}
+		m[key.Parent.String()] = toJSON(s)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// MarshalJSON returns the same JSON as String, so the collector can also be
+// published as part of another expvar variable such as an expvar.Func
+func (c *Collector) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// WritePrometheus writes the totals in the Prometheus text exposition format
+func (c *Collector) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	ops := c.Ops()
+	var opKeys []lfs.Op
+	for op := range ops {
+		opKeys = append(opKeys, op)
+	}
+	sort.Slice(opKeys, func(i, j int) bool { return opKeys[i] < opKeys[j] })
+	for _, m := range metricsFor("littlefs", "public littlefs operations") {
+		m.header(bw)
+		for _, op := range opKeys {
+			fmt.Fprintf(bw, "%s{op=%q} %s\n", m.name, op.String(), m.value(ops[op]))
+		}
+	}
+
+	blocks := c.Blocks()
+	var blockKeys []BlockKey
+	for key := range blocks {
+		blockKeys = append(blockKeys, key)
+	}
+	sort.Slice(blockKeys, func(i, j int) bool {
+		a, b := blockKeys[i], blockKeys[j]
+		return a.Op < b.Op || a.Op == b.Op && a.Parent < b.Parent
+	})
+	for _, m := range metricsFor("littlefs_block", "block device callbacks") {
+		m.header(bw)
+		for _, key := range blockKeys {
+			fmt.Fprintf(bw, "%s{op=%q,parent=%q} %s\n", m.name, key.Op.String(), key.Parent.String(), m.value(blocks[key]))
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the totals in the Prometheus text exposition format
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WritePrometheus(w)
+}
+
+// metric is one of the counters derived from Stats
+type metric struct {
+	name  string
+	help  string
+	value func(s Stats) string
+}
+
+func metricsFor(prefix string, what string) []metric {
+	return []metric{
+		{prefix + "_operations_total", "Number of " + what + ".", func(s Stats) string {
+			return fmt.Sprint(s.Count)
+		}},
+		{prefix + "_errors_total", "Number of " + what + " that failed.", func(s Stats) string {
+			return fmt.Sprint(s.Errors)
+		}},
+		{prefix + "_bytes_total", "Bytes transferred by " + what + ".", func(s Stats) string {
+			return fmt.Sprint(s.Bytes)
+		}},
+		{prefix + "_seconds_total", "Time spent in " + what + ".", func(s Stats) string {
+			return fmt.Sprint(s.Duration.Seconds())
+		}},
+	}
+}
+
+func (m metric) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+}
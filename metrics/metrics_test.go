@@ -0,0 +1,105 @@
+package metrics_test
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	lfs "github.com/bgould/go-littlefs"
+	"github.com/bgould/go-littlefs/metrics"
+)
+
+var config = lfs.Config{
+	ReadSize:      16,
+	ProgSize:      16,
+	BlockSize:     512,
+	BlockCount:    128,
+	CacheSize:     64,
+	LookaheadSize: 16,
+	BlockCycles:   500,
+}
+
+func TestCollector(t *testing.T) {
+	fs := lfs.New(config, lfs.NewMemoryDevice(config))
+	c := metrics.NewCollector()
+	fs.SetObserver(c)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	check(t, fs.Mkdir("logs"))
+	f, err := fs.OpenFile("logs/boot", os.O_WRONLY|os.O_CREATE)
+	check(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := f.Write(make([]byte, 1000))
+		check(t, err)
+	}
+	check(t, f.Close())
+	if _, err := fs.Stat("nope"); err == nil {
+		t.Fatal("expected error")
+	}
+	check(t, fs.Unmount())
+
+	ops := c.Ops()
+	if s := ops[lfs.OpWrite]; s.Count != 10 || s.Bytes != 10000 || s.Errors != 0 {
+		t.Errorf("unexpected write stats: %+v", s)
+	}
+	if s := ops[lfs.OpStat]; s.Count != 1 || s.Errors != 1 {
+		t.Errorf("unexpected stat stats: %+v", s)
+	}
+	blocks := c.Blocks()
+	writeErases := blocks[metrics.BlockKey{Op: lfs.OpBlockErase, Parent: lfs.OpWrite}]
+	if writeErases.Count == 0 || writeErases.Bytes != writeErases.Count*uint64(config.BlockSize) {
+		t.Errorf("expected erases attributed to writes: %+v", writeErases)
+	}
+	if s := blocks[metrics.BlockKey{Op: lfs.OpBlockErase, Parent: lfs.OpFormat}]; s.Count == 0 {
+		t.Errorf("expected erases attributed to format")
+	}
+	if s := blocks[metrics.BlockKey{Op: lfs.OpBlockErase, Parent: lfs.OpNone}]; s.Count != 0 {
+		t.Errorf("expected every erase to be attributed; %d were not", s.Count)
+	}
+
+	t.Run("Expvar", func(t *testing.T) {
+		if expvar.Get("littlefs") == nil {
+			expvar.Publish("littlefs", expvar.Func(func() interface{} { return current }))
+		}
+		current = c
+		var v struct {
+			Ops    map[string]struct{ Count uint64 }
+			Blocks map[string]map[string]struct{ Count uint64 }
+		}
+		check(t, json.Unmarshal([]byte(expvar.Get("littlefs").String()), &v))
+		if v.Ops["write"].Count != 10 || v.Blocks["block_erase"]["write"].Count != writeErases.Count {
+			t.Errorf("unexpected expvar: %s", c.String())
+		}
+	})
+
+	t.Run("Prometheus", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		body := rec.Body.String()
+		for _, want := range []string{
+			"# TYPE littlefs_operations_total counter\n",
+			"littlefs_operations_total{op=\"write\"} 10\n",
+			"littlefs_bytes_total{op=\"write\"} 10000\n",
+			"littlefs_errors_total{op=\"stat\"} 1\n",
+			"littlefs_block_operations_total{op=\"block_erase\",parent=\"write\"} ",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected %q in:\n%s", want, body)
+			}
+		}
+	})
+}
+
+// current is the collector published with expvar by the latest run of
+// TestCollector, as a name can only be published once
+var current *metrics.Collector
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
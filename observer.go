@@ -0,0 +1,181 @@
+package lfs
+
+import (
+	"time"
+)
+
+// Op identifies an operation reported to an Observer
+type Op uint8
+
+const (
+	OpNone Op = iota // no public operation is in progress
+	OpFormat
+	OpMount
+	OpUnmount
+	OpOpenFile
+	OpClose
+	OpRead
+	OpWrite
+	OpSync
+	OpTruncate
+	OpRemove
+	OpRename
+	OpMkdir
+	OpStat
+	OpSymlink
+	OpRepair
+
+	// Block device callbacks made by littlefs
+	OpBlockRead
+	OpBlockProgram
+	OpBlockErase
+	OpBlockSync
+)
+
+var opNames = [...]string{
+	OpNone:         "none",
+	OpFormat:       "format",
+	OpMount:        "mount",
+	OpUnmount:      "unmount",
+	OpOpenFile:     "open",
+	OpClose:        "close",
+	OpRead:         "read",
+	OpWrite:        "write",
+	OpSync:         "sync",
+	OpTruncate:     "truncate",
+	OpRemove:       "remove",
+	OpRename:       "rename",
+	OpMkdir:        "mkdir",
+	OpStat:         "stat",
+	OpSymlink:      "symlink",
+	OpRepair:       "repair",
+	OpBlockRead:    "block_read",
+	OpBlockProgram: "block_program",
+	OpBlockErase:   "block_erase",
+	OpBlockSync:    "block_sync",
+}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "unknown"
+}
+
+// IsBlock reports whether op is a block device callback
+func (op Op) IsBlock() bool {
+	return op >= OpBlockRead
+}
+
+// Event describes a completed operation
+type Event struct {
+	Op Op
+
+	// Parent is the public operation that was in progress when a block
+	// device callback was made, which allows erases and programs to be
+	// attributed to the operations that caused them. For public operations,
+	// it is the operation in progress when this one started, usually OpNone.
+	Parent Op
+
+	// Path is the path of the file or directory for public operations; for
+	// Rename, it is the old path
+	Path string
+
+	// Block is the block accessed by a block device callback
+	Block uint32
+
+	// Bytes is the number of bytes read or written; for erases it is the
+	// block size
+	Bytes int
+
+	Duration time.Duration
+	Err      error
+}
+
+// Observer receives an Event for every public operation on an LFS and every
+// block device callback made by littlefs. Observe is called synchronously,
+// so it should return quickly.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc adapts an ordinary function to the Observer interface
+type ObserverFunc func(e Event)
+
+func (fn ObserverFunc) Observe(e Event) {
+	fn(e)
+}
+
+// SetObserver sets the observer that receives an Event for every operation;
+// nil, the default, disables instrumentation
+func (l *LFS) SetObserver(o Observer) {
+	l.observer = o
+}
+
+// span tracks a public operation while it is in progress
+type span struct {
+	l      *LFS
+	op     Op
+	parent Op
+	path   string
+	start  time.Time
+}
+
+// begin starts tracking the public operation op on path; the operation must
+// be completed by calling end on the returned span
+func (l *LFS) begin(op Op, path string) span {
+	if l.observer == nil && l.watermarks.fn == nil {
+		return span{}
+	}
+	s := span{l: l, op: op, parent: l.op, path: path, start: time.Now()}
+	l.op = op
+	return s
+}
+
+// end reports the operation to the observer, and checks the watermarks once
+// the outermost operation is done
+func (s span) end(n int, err error) {
+	if s.l == nil {
+		return
+	}
+	s.l.op = s.parent
+	if o := s.l.observer; o != nil {
+		o.Observe(Event{
+			Op:       s.op,
+			Parent:   s.parent,
+			Path:     s.path,
+			Bytes:    n,
+			Duration: time.Since(s.start),
+			Err:      err,
+		})
+	}
+	// the filesystem is not mounted after formatting or unmounting it
+	mounted := s.op != OpFormat && s.op != OpUnmount && (s.op != OpMount || err == nil)
+	if s.parent == OpNone && mounted {
+		s.l.checkWatermarks()
+	}
+}
+
+// now returns the start time of a block device callback, or the zero time if
+// there is no observer
+func (l *LFS) now() time.Time {
+	if l.observer == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// observeBlock reports a block device callback which started at start
+func (l *LFS) observeBlock(op Op, block uint32, n int, start time.Time, err error) {
+	if l.observer == nil || start.IsZero() {
+		return
+	}
+	l.observer.Observe(Event{
+		Op:       op,
+		Parent:   l.op,
+		Block:    block,
+		Bytes:    n,
+		Duration: time.Since(start),
+		Err:      err,
+	})
+}
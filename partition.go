@@ -0,0 +1,311 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// PartitionBlockDevice exposes a range of the blocks of another block device
+// as a block device of its own, so that several filesystems can share one
+// flash chip
+type PartitionBlockDevice struct {
+	dev       BlockDevice
+	blockSize uint32
+	start     uint32
+	count     uint32
+}
+
+// Partition returns a block device made of the blockCount blocks of dev
+// starting at startBlock, where the BlockSize of config gives the size of
+// the blocks of dev. Any access outside of the blocks of the partition,
+// whether to a block past its end or past the end of a block, fails with
+// ErrInvalidParam rather than reaching dev.
+func Partition(dev BlockDevice, config Config, startBlock uint32, blockCount uint32) *PartitionBlockDevice {
+	return &PartitionBlockDevice{dev: dev, blockSize: config.BlockSize, start: startBlock, count: blockCount}
+}
+
+// Start returns the first block of the underlying device in the partition
+func (bd *PartitionBlockDevice) Start() uint32 {
+	return bd.start
+}
+
+// BlockCount returns the number of blocks in the partition
+func (bd *PartitionBlockDevice) BlockCount() uint32 {
+	return bd.count
+}
+
+func (bd *PartitionBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	if !bd.contains(block, offset, len(buf)) {
+		return ErrInvalidParam
+	}
+	return bd.dev.ReadBlock(bd.start+block, offset, buf)
+}
+
+func (bd *PartitionBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	if !bd.contains(block, offset, len(buf)) {
+		return ErrInvalidParam
+	}
+	return bd.dev.ProgramBlock(bd.start+block, offset, buf)
+}
+
+func (bd *PartitionBlockDevice) EraseBlock(block uint32) error {
+	if !bd.contains(block, 0, 0) {
+		return ErrInvalidParam
+	}
+	return bd.dev.EraseBlock(bd.start + block)
+}
+
+func (bd *PartitionBlockDevice) Sync() error {
+	return bd.dev.Sync()
+}
+
+// contains reports whether n bytes at offset in block are within the
+// partition
+func (bd *PartitionBlockDevice) contains(block uint32, offset uint32, n int) bool {
+	return block < bd.count && uint64(offset)+uint64(n) <= uint64(bd.blockSize)
+}
+
+// The partition table is kept in the first two blocks of the device, which
+// are written alternately so that a power loss while the table is updated
+// leaves the previous version intact. Each copy is laid out as:
+//
+//	magic    [8]byte  "lfsparts"
+//	revision uint32   incremented on each update; the newest valid copy wins
+//	count    uint32   number of entries
+//	entries  [count]{name [24]byte; start uint32; blocks uint32}
+//	crc      uint32   CRC-32 (IEEE) of everything before it
+//
+// All integers are little-endian; names are NUL padded.
+const (
+	partitionMagic       = "lfsparts"
+	partitionHeaderSize  = 16
+	partitionEntrySize   = 32
+	partitionNameMax     = 24
+	partitionTableBlocks = 2
+)
+
+// PartitionInfo describes one partition in a PartitionTable
+type PartitionInfo struct {
+	Name       string
+	StartBlock uint32
+	BlockCount uint32
+}
+
+// PartitionTable divides a block device into named partitions
+type PartitionTable struct {
+	dev        BlockDevice
+	blockSize  uint32
+	blockCount uint32
+	revision   uint32
+	parts      []PartitionInfo
+}
+
+// FormatPartitionTable writes an empty partition table to dev, whose
+// geometry is given by the BlockSize and BlockCount of config. Any existing
+// partitions are forgotten, though their contents are left in place.
+func FormatPartitionTable(dev BlockDevice, config Config) (*PartitionTable, error) {
+	pt := &PartitionTable{dev: dev, blockSize: config.BlockSize, blockCount: config.BlockCount}
+	if pt.maxEntries() < 0 || pt.blockCount < partitionTableBlocks {
+		return nil, ErrInvalidParam
+	}
+	// write both copies, so that no stale table can take precedence
+	for i := 0; i < partitionTableBlocks; i++ {
+		if err := pt.write(); err != nil {
+			return nil, err
+		}
+	}
+	return pt, nil
+}
+
+// OpenPartitionTable reads the partition table from dev. It fails with
+// ErrCorrupt if neither copy of the table is valid.
+func OpenPartitionTable(dev BlockDevice, config Config) (*PartitionTable, error) {
+	pt := &PartitionTable{dev: dev, blockSize: config.BlockSize, blockCount: config.BlockCount}
+	found := false
+	buf := make([]byte, pt.blockSize)
+	for block := uint32(0); block < partitionTableBlocks; block++ {
+		if err := dev.ReadBlock(block, 0, buf); err != nil {
+			return nil, err
+		}
+		rev, parts, ok := pt.decode(buf)
+		if ok && (!found || int32(rev-pt.revision) > 0) {
+			pt.revision, pt.parts, found = rev, parts, true
+		}
+	}
+	if !found {
+		return nil, ErrCorrupt
+	}
+	return pt, nil
+}
+
+// List returns the partitions in the order of their blocks
+func (pt *PartitionTable) List() []PartitionInfo {
+	return append([]PartitionInfo(nil), pt.parts...)
+}
+
+// Create adds a partition of blockCount blocks, placed in the first gap
+// between existing partitions that is large enough
+func (pt *PartitionTable) Create(name string, blockCount uint32) (PartitionInfo, error) {
+	if len(name) == 0 || blockCount == 0 {
+		return PartitionInfo{}, ErrInvalidParam
+	}
+	if len(name) > partitionNameMax {
+		return PartitionInfo{}, ErrNameTooLong
+	}
+	if _, ok := pt.find(name); ok {
+		return PartitionInfo{}, ErrEntryExists
+	}
+	if len(pt.parts) >= pt.maxEntries() {
+		return PartitionInfo{}, ErrNoSpace
+	}
+	start := uint32(partitionTableBlocks)
+	i := 0
+	for ; i < len(pt.parts); i++ {
+		if pt.parts[i].StartBlock-start >= blockCount {
+			break
+		}
+		start = pt.parts[i].StartBlock + pt.parts[i].BlockCount
+	}
+	if start > pt.blockCount || pt.blockCount-start < blockCount {
+		return PartitionInfo{}, ErrNoSpace
+	}
+	info := PartitionInfo{Name: name, StartBlock: start, BlockCount: blockCount}
+	parts := append([]PartitionInfo(nil), pt.parts[:i]...)
+	parts = append(parts, info)
+	parts = append(parts, pt.parts[i:]...)
+	return info, pt.update(parts)
+}
+
+// Delete removes a partition from the table; its blocks are not erased
+func (pt *PartitionTable) Delete(name string) error {
+	i, ok := pt.find(name)
+	if !ok {
+		return ErrNoEntry
+	}
+	parts := append([]PartitionInfo(nil), pt.parts[:i]...)
+	return pt.update(append(parts, pt.parts[i+1:]...))
+}
+
+// Open returns the block device for the named partition
+func (pt *PartitionTable) Open(name string) (*PartitionBlockDevice, error) {
+	i, ok := pt.find(name)
+	if !ok {
+		return nil, ErrNoEntry
+	}
+	config := Config{BlockSize: pt.blockSize, BlockCount: pt.blockCount}
+	return Partition(pt.dev, config, pt.parts[i].StartBlock, pt.parts[i].BlockCount), nil
+}
+
+// Filesystem returns a new, unmounted LFS on the named partition. The
+// BlockCount of config is replaced with the size of the partition.
+func (pt *PartitionTable) Filesystem(name string, config Config) (*LFS, error) {
+	dev, err := pt.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	config.BlockCount = dev.BlockCount()
+	return New(config, dev), nil
+}
+
+func (pt *PartitionTable) find(name string) (int, bool) {
+	for i, p := range pt.parts {
+		if p.Name == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func (pt *PartitionTable) maxEntries() int {
+	return (int(pt.blockSize) - partitionHeaderSize - 4) / partitionEntrySize
+}
+
+// update writes parts as the new revision of the table
+func (pt *PartitionTable) update(parts []PartitionInfo) error {
+	prev := pt.parts
+	pt.parts = parts
+	if err := pt.write(); err != nil {
+		pt.parts = prev
+		return err
+	}
+	return nil
+}
+
+// write writes the next revision of the table to the block holding the
+// older copy
+func (pt *PartitionTable) write() error {
+	rev := pt.revision + 1
+	buf := make([]byte, pt.blockSize)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	copy(buf, partitionMagic)
+	binary.LittleEndian.PutUint32(buf[8:], rev)
+	binary.LittleEndian.PutUint32(buf[12:], uint32(len(pt.parts)))
+	off := partitionHeaderSize
+	for _, p := range pt.parts {
+		entry := buf[off : off+partitionEntrySize]
+		for i := range entry[:partitionNameMax] {
+			entry[i] = 0
+		}
+		copy(entry, p.Name)
+		binary.LittleEndian.PutUint32(entry[24:], p.StartBlock)
+		binary.LittleEndian.PutUint32(entry[28:], p.BlockCount)
+		off += partitionEntrySize
+	}
+	binary.LittleEndian.PutUint32(buf[off:], crc32.ChecksumIEEE(buf[:off]))
+
+	block := rev % partitionTableBlocks
+	if err := pt.dev.EraseBlock(block); err != nil {
+		return err
+	}
+	if err := pt.dev.ProgramBlock(block, 0, buf); err != nil {
+		return err
+	}
+	if err := pt.dev.Sync(); err != nil {
+		return err
+	}
+	pt.revision = rev
+	return nil
+}
+
+// decode parses one copy of the table, reporting whether it is valid
+func (pt *PartitionTable) decode(buf []byte) (uint32, []PartitionInfo, bool) {
+	if string(buf[:8]) != partitionMagic {
+		return 0, nil, false
+	}
+	rev := binary.LittleEndian.Uint32(buf[8:])
+	count := binary.LittleEndian.Uint32(buf[12:])
+	if count > uint32(pt.maxEntries()) {
+		return 0, nil, false
+	}
+	off := partitionHeaderSize + int(count)*partitionEntrySize
+	if crc32.ChecksumIEEE(buf[:off]) != binary.LittleEndian.Uint32(buf[off:]) {
+		return 0, nil, false
+	}
+	parts := make([]PartitionInfo, count)
+	end := uint32(partitionTableBlocks)
+	for i := range parts {
+		entry := buf[partitionHeaderSize+i*partitionEntrySize:]
+		name := entry[:partitionNameMax]
+		for j, c := range name {
+			if c == 0 {
+				name = name[:j]
+				break
+			}
+		}
+		p := PartitionInfo{
+			Name:       string(name),
+			StartBlock: binary.LittleEndian.Uint32(entry[24:]),
+			BlockCount: binary.LittleEndian.Uint32(entry[28:]),
+		}
+		// partitions must be in order, and must not overlap each other,
+		// the table, or the end of the device
+		if p.StartBlock < end || p.StartBlock > pt.blockCount || p.BlockCount > pt.blockCount-p.StartBlock {
+			return 0, nil, false
+		}
+		end = p.StartBlock + p.BlockCount
+		parts[i] = p
+	}
+	return rev, parts, true
+}
@@ -0,0 +1,164 @@
+package lfs
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPartitionTable(t *testing.T) {
+	dev := NewMemoryDevice(defaultConfig)
+	pt, err := FormatPartitionTable(dev, defaultConfig)
+	check(t, err)
+	for _, p := range []struct {
+		name   string
+		blocks uint32
+	}{
+		{"config", 64},
+		{"logs", 256},
+		{"ota", 512},
+	} {
+		_, err := pt.Create(p.name, p.blocks)
+		check(t, err)
+	}
+	if _, err := pt.Create("logs", 16); err != ErrEntryExists {
+		t.Fatalf("expected ErrEntryExists; was %v", err)
+	}
+	if _, err := pt.Create("huge", 1024); err != ErrNoSpace {
+		t.Fatalf("expected ErrNoSpace; was %v", err)
+	}
+	if _, err := pt.Create("a-name-that-is-far-too-long", 1); err != ErrNameTooLong {
+		t.Fatalf("expected ErrNameTooLong; was %v", err)
+	}
+
+	// each partition holds an independent filesystem
+	for _, name := range []string{"config", "logs", "ota"} {
+		fs, err := pt.Filesystem(name, defaultConfig)
+		check(t, err)
+		check(t, fs.Format())
+		check(t, fs.Mount())
+		writeFileTest(t, fs, 2000, name+".bin")
+		check(t, fs.Unmount())
+	}
+
+	pt, err = OpenPartitionTable(dev, defaultConfig)
+	check(t, err)
+	parts := pt.List()
+	want := []PartitionInfo{{"config", 2, 64}, {"logs", 66, 256}, {"ota", 322, 512}}
+	if len(parts) != len(want) {
+		t.Fatalf("expected %v; was %v", want, parts)
+	}
+	for i := range want {
+		if parts[i] != want[i] {
+			t.Fatalf("expected %v; was %v", want, parts)
+		}
+	}
+	for _, name := range []string{"config", "logs", "ota"} {
+		fs, err := pt.Filesystem(name, defaultConfig)
+		check(t, err)
+		check(t, fs.Mount())
+		readFileTest(t, fs, 2000, name+".bin")
+		if _, err := fs.Stat("logs.bin"); name != "logs" && err != ErrNoEntry {
+			t.Fatalf("%s: expected other partitions' files not to be visible; was %v", name, err)
+		}
+		check(t, fs.Unmount())
+	}
+
+	// deleting a partition leaves a gap that can be reused
+	check(t, pt.Delete("logs"))
+	if _, err := pt.Open("logs"); err != ErrNoEntry {
+		t.Fatalf("expected ErrNoEntry; was %v", err)
+	}
+	info, err := pt.Create("cache", 100)
+	check(t, err)
+	if info.StartBlock != 66 {
+		t.Fatalf("expected new partition in gap; was %v", info)
+	}
+
+	// losing the newest copy of the table falls back to the previous one
+	check(t, dev.EraseBlock(pt.revision%partitionTableBlocks))
+	pt, err = OpenPartitionTable(dev, defaultConfig)
+	check(t, err)
+	if _, err := pt.Open("cache"); err != ErrNoEntry {
+		t.Fatalf("expected previous table without cache partition; was %v", err)
+	}
+	check(t, dev.EraseBlock(pt.revision%partitionTableBlocks))
+	if _, err := OpenPartitionTable(dev, defaultConfig); err != ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt; was %v", err)
+	}
+}
+
+func TestPartitionBounds(t *testing.T) {
+	dev := &countingBlockDevice{BlockDevice: NewMemoryDevice(defaultConfig)}
+	part := Partition(dev, defaultConfig, 100, 10)
+	buf := make([]byte, 16)
+	if err := part.ReadBlock(10, 0, buf); err != ErrInvalidParam {
+		t.Errorf("expected read past the end to fail; was %v", err)
+	}
+	if err := part.ProgramBlock(10, 0, buf); err != ErrInvalidParam {
+		t.Errorf("expected program past the end to fail; was %v", err)
+	}
+	if err := part.EraseBlock(0xffffffff); err != ErrInvalidParam {
+		t.Errorf("expected erase past the end to fail; was %v", err)
+	}
+	if dev.programs != 0 || dev.erases != 0 {
+		t.Fatalf("expected no writes; had %d programs and %d erases", dev.programs, dev.erases)
+	}
+	check(t, part.EraseBlock(9))
+	if dev.erases != 1 {
+		t.Fatal("expected erase within partition to succeed")
+	}
+}
+
+// flatBlockDevice addresses its blocks as one array of bytes, like a raw
+// image or SPI flash, so accesses past the end of a block reach the next one
+type flatBlockDevice struct {
+	data      []byte
+	blockSize uint32
+}
+
+func (bd *flatBlockDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	copy(buf, bd.data[block*bd.blockSize+offset:])
+	return nil
+}
+
+func (bd *flatBlockDevice) ProgramBlock(block uint32, offset uint32, buf []byte) error {
+	copy(bd.data[block*bd.blockSize+offset:], buf)
+	return nil
+}
+
+func (bd *flatBlockDevice) EraseBlock(block uint32) error {
+	copy(bd.data[block*bd.blockSize:][:bd.blockSize], bytes.Repeat([]byte{0xff}, int(bd.blockSize)))
+	return nil
+}
+
+func (bd *flatBlockDevice) Sync() error {
+	return nil
+}
+
+func TestPartitionBlockBounds(t *testing.T) {
+	bs := defaultConfig.BlockSize
+	dev := &flatBlockDevice{data: bytes.Repeat([]byte{0xff}, int(4*bs)), blockSize: bs}
+	first := Partition(dev, defaultConfig, 0, 2)
+	second := Partition(dev, defaultConfig, 2, 2)
+	check(t, second.ProgramBlock(0, 0, []byte("second")))
+	before := append([]byte(nil), dev.data...)
+
+	buf := make([]byte, 16)
+	if err := first.ProgramBlock(1, bs-8, buf); err != ErrInvalidParam {
+		t.Errorf("expected program past the end of the last block to fail; was %v", err)
+	}
+	if err := first.ProgramBlock(1, bs, buf[:1]); err != ErrInvalidParam {
+		t.Errorf("expected program at the end of the last block to fail; was %v", err)
+	}
+	if err := first.ReadBlock(1, bs-8, buf); err != ErrInvalidParam {
+		t.Errorf("expected read past the end of the last block to fail; was %v", err)
+	}
+	if !bytes.Equal(dev.data, before) {
+		t.Fatal("expected the neighbouring partition to be unchanged")
+	}
+	check(t, first.ProgramBlock(1, bs-16, buf))
+	check(t, second.ReadBlock(0, 0, buf[:6]))
+	if string(buf[:6]) != "second" {
+		t.Errorf("expected the neighbouring partition to be intact; was %q", buf[:6])
+	}
+}
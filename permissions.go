@@ -0,0 +1,289 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+)
+
+// The AttrPermissions attribute of a file or directory holds:
+//
+//	mode uint32  permission bits, as in st_mode but without the file type
+//	uid  uint32
+//	gid  uint32
+//
+// as little-endian integers. Files without it are treated as having mode 0777
+// and belonging to user and group 0, which is how they were always reported.
+const permissionsSize = 12
+
+// Bits of st_mode, as in syscall.Stat_t
+const (
+	modeTypeDir     = 0040000
+	modeTypeRegular = 0100000
+	modeTypeSymlink = 0120000
+
+	modeSetuid = 04000
+	modeSetgid = 02000
+	modeSticky = 01000
+
+	permRead   = 4
+	permWrite  = 2
+	permSearch = 1
+)
+
+// modeCreate is the mode of a file created while a Credential is set
+const modeCreate = 0644
+
+// Stat_t is returned by Info.Sys to describe a file the way syscall.Stat_t
+// does on Unix systems
+type Stat_t struct {
+	Mode  uint32 // file type and permission bits
+	Nlink uint32
+	Uid   uint32
+	Gid   uint32
+	Size  int64
+}
+
+// Credential identifies the user on whose behalf files are opened, see
+// SetCredential
+type Credential struct {
+	Uid    uint32
+	Gid    uint32
+	Groups []uint32 // supplementary groups
+}
+
+// permissions is the decoded AttrPermissions attribute of a file
+type permissions struct {
+	mode uint32
+	uid  uint32
+	gid  uint32
+}
+
+var defaultPermissions = permissions{mode: 0777}
+
+func (p permissions) encode() []byte {
+	buf := make([]byte, permissionsSize)
+	binary.LittleEndian.PutUint32(buf[0:], p.mode)
+	binary.LittleEndian.PutUint32(buf[4:], p.uid)
+	binary.LittleEndian.PutUint32(buf[8:], p.gid)
+	return buf
+}
+
+// allows reports whether cred may access a file with permissions p in the
+// ways given by want, a combination of permRead, permWrite and permSearch
+func (p permissions) allows(cred *Credential, want uint32) bool {
+	if cred.Uid == 0 {
+		return true
+	}
+	bits := p.mode
+	if cred.Uid == p.uid {
+		bits >>= 6
+	} else if cred.inGroup(p.gid) {
+		bits >>= 3
+	}
+	return bits&want == want
+}
+
+func (cred *Credential) inGroup(gid uint32) bool {
+	if cred.Gid == gid {
+		return true
+	}
+	for _, g := range cred.Groups {
+		if g == gid {
+			return true
+		}
+	}
+	return false
+}
+
+// Chmod changes the permission bits of the named file, following symbolic
+// links; only os.ModePerm, os.ModeSetuid, os.ModeSetgid and os.ModeSticky are
+// kept
+func (l *LFS) Chmod(name string, mode os.FileMode) error {
+	resolved, err := l.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	p, err := l.permissions(resolved)
+	if err != nil {
+		return err
+	}
+	p.mode = posixMode(mode)
+	return l.Setattr(resolved, AttrPermissions, p.encode())
+}
+
+// Chown changes the owner and group of the named file, following symbolic
+// links; a uid or gid of -1 leaves it as it is
+func (l *LFS) Chown(name string, uid int, gid int) error {
+	return l.chown(name, uid, gid, true)
+}
+
+// Lchown is like Chown, but changes the owner and group of a symbolic link
+// itself rather than of the file it points to
+func (l *LFS) Lchown(name string, uid int, gid int) error {
+	return l.chown(name, uid, gid, false)
+}
+
+func (l *LFS) chown(name string, uid int, gid int, follow bool) error {
+	if uid < -1 || gid < -1 || int64(uid) > 1<<32-1 || int64(gid) > 1<<32-1 {
+		return ErrInvalidParam
+	}
+	resolved, err := l.resolve(name, follow)
+	if err != nil {
+		return err
+	}
+	p, err := l.permissions(resolved)
+	if err != nil {
+		return err
+	}
+	if uid != -1 {
+		p.uid = uint32(uid)
+	}
+	if gid != -1 {
+		p.gid = uint32(gid)
+	}
+	return l.Setattr(resolved, AttrPermissions, p.encode())
+}
+
+// SetCredential makes OpenFile, and the methods opening files through it,
+// check the permissions of files against cred, failing with ErrPermission if
+// they do not allow what the flags ask for. Every directory on the way to a
+// file must be searchable, and a file can only be created in a directory
+// which is writable; the file then belongs to cred and has mode 0644. User 0
+// is allowed everything. Files opened with OpenEncrypted and OpenCompressed
+// are checked as well; writing to them reads their chunks back, so they have
+// to be readable to be opened for writing. Nothing but opening files is
+// checked.
+//
+// nil, the default, disables checking permissions.
+func (l *LFS) SetCredential(cred *Credential) {
+	l.cred = cred
+}
+
+// keepPermissions gives the file tmp, which is to be renamed over the named
+// file, the permissions of that file, so that replacing its contents does not
+// change who may access it; tmp keeps its own if there is no such file
+func (l *LFS) keepPermissions(name string, tmp string) error {
+	name, err := l.resolve(name, false)
+	if err != nil {
+		return err
+	}
+	if tmp, err = l.resolve(tmp, false); err != nil {
+		return err
+	}
+	var buf [permissionsSize]byte
+	n, err := l.Getattr(name, AttrPermissions, buf[:])
+	if err == ErrNoEntry {
+		return nil
+	}
+	if err == ErrNoAttr {
+		err := l.Removeattr(tmp, AttrPermissions)
+		if err == ErrNoAttr {
+			err = nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	return l.Setattr(tmp, AttrPermissions, buf[:n])
+}
+
+// permissions reads the AttrPermissions attribute of the named file, which
+// must already be resolved
+func (l *LFS) permissions(name string) (permissions, error) {
+	var buf [permissionsSize]byte
+	n, err := l.Getattr(name, AttrPermissions, buf[:])
+	if err == ErrNoAttr {
+		return defaultPermissions, nil
+	}
+	if err != nil {
+		return permissions{}, err
+	}
+	if n != len(buf) {
+		return permissions{}, ErrCorrupt
+	}
+	return permissions{
+		mode: binary.LittleEndian.Uint32(buf[0:]),
+		uid:  binary.LittleEndian.Uint32(buf[4:]),
+		gid:  binary.LittleEndian.Uint32(buf[8:]),
+	}, nil
+}
+
+// checkOpen checks that the credential set with SetCredential allows opening
+// the named file, which must already be resolved, with flags; it reports
+// whether the file is going to be created
+func (l *LFS) checkOpen(name string, flags int) (bool, error) {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if err := l.checkAccess(dir, permSearch); err != nil {
+			return false, err
+		}
+		if dir == "/" || dir == "." {
+			break
+		}
+	}
+	var want uint32
+	switch flags & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		want = permWrite
+	case os.O_RDWR:
+		want = permRead | permWrite
+	default:
+		want = permRead
+	}
+	if flags&os.O_TRUNC != 0 {
+		want |= permWrite
+	}
+	err := l.checkAccess(name, want)
+	if err == ErrNoEntry && flags&os.O_CREATE != 0 {
+		return true, l.checkAccess(path.Dir(name), permWrite|permSearch)
+	}
+	if err == ErrNoEntry {
+		// opening the file fails anyway
+		return false, nil
+	}
+	return false, err
+}
+
+// checkAccess checks that the credential set with SetCredential allows the
+// accesses in want to the named file
+func (l *LFS) checkAccess(name string, want uint32) error {
+	p, err := l.permissions(name)
+	if err != nil {
+		return err
+	}
+	if !p.allows(l.cred, want) {
+		return ErrPermission
+	}
+	return nil
+}
+
+// posixMode converts the permission bits of mode to those of st_mode
+func posixMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= modeSetuid
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= modeSetgid
+	}
+	if mode&os.ModeSticky != 0 {
+		m |= modeSticky
+	}
+	return m
+}
+
+// fileMode converts the permission bits of st_mode to an os.FileMode
+func fileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode) & os.ModePerm
+	if mode&modeSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&modeSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&modeSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
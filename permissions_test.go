@@ -0,0 +1,213 @@
+package lfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	t.Run("Chmod", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("file", []byte("contents")))
+		check(t, fs.Symlink("file", "link"))
+		check(t, fs.Mkdir("dir"))
+
+		info, err := fs.Stat("file")
+		check(t, err)
+		if info.Mode() != 0777 {
+			t.Errorf("expected the default mode; got %v", info.Mode())
+		}
+		check(t, fs.Chmod("link", 0640|os.ModeSetgid))
+		check(t, fs.Chown("link", 1000, 100))
+		check(t, fs.Chown("file", -1, 200))
+		check(t, fs.Lchown("link", 3000, -1))
+		check(t, fs.Chmod("dir", 0700|os.ModeSticky))
+
+		info, err = fs.Stat("file")
+		check(t, err)
+		if info.Mode() != 0640|os.ModeSetgid {
+			t.Errorf("unexpected mode %v", info.Mode())
+		}
+		want := Stat_t{Mode: 0102640, Nlink: 1, Uid: 1000, Gid: 200, Size: 8}
+		if st, ok := info.Sys().(*Stat_t); !ok || *st != want {
+			t.Errorf("expected %+v; got %+v", want, info.Sys())
+		}
+		info, err = fs.Lstat("link")
+		check(t, err)
+		want = Stat_t{Mode: 0120777, Nlink: 1, Uid: 3000, Size: 4}
+		if st := info.Sys().(*Stat_t); *st != want {
+			t.Errorf("expected %+v; got %+v", want, *st)
+		}
+		info, err = fs.Stat("dir")
+		check(t, err)
+		if info.Mode() != 0700|os.ModeSticky|os.ModeDir {
+			t.Errorf("unexpected mode %v", info.Mode())
+		}
+
+		dir, err := fs.Open("/")
+		check(t, err)
+		infos, err := dir.Readdir(0)
+		check(t, err)
+		check(t, dir.Close())
+		for _, info := range infos {
+			if info.Name() == "file" && info.Mode() != 0640|os.ModeSetgid {
+				t.Errorf("unexpected mode %v in directory listing", info.Mode())
+			}
+		}
+		if err := fs.Chown("file", -2, 0); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+		if err := fs.Chmod("missing", 0600); err != ErrNoEntry {
+			t.Errorf("expected ErrNoEntry; got %v", err)
+		}
+	})
+
+	t.Run("Enforce", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("home"))
+		check(t, fs.Chown("home", 1000, 1000))
+		check(t, fs.Chmod("home", 0755))
+		check(t, fs.WriteFile("home/private", nil))
+		check(t, fs.Chown("home/private", 1000, 1000))
+		check(t, fs.Chmod("home/private", 0600))
+		check(t, fs.WriteFile("home/shared", nil))
+		check(t, fs.Chown("home/shared", 1000, 100))
+		check(t, fs.Chmod("home/shared", 0640))
+		check(t, fs.Mkdir("root"))
+		check(t, fs.Chmod("root", 0700))
+		check(t, fs.WriteFile("root/file", nil))
+
+		owner := &Credential{Uid: 1000, Gid: 1000}
+		member := &Credential{Uid: 2000, Gid: 2000, Groups: []uint32{100}}
+		for _, c := range []struct {
+			name  string
+			cred  *Credential
+			path  string
+			flags int
+			want  error
+		}{
+			{"Owner", owner, "home/private", os.O_RDWR, nil},
+			{"Other", member, "home/private", os.O_RDONLY, ErrPermission},
+			{"GroupRead", member, "home/shared", os.O_RDONLY, nil},
+			{"GroupWrite", member, "home/shared", os.O_WRONLY, ErrPermission},
+			{"GroupTruncate", member, "home/shared", os.O_RDONLY | os.O_TRUNC, ErrPermission},
+			{"CreateDenied", member, "home/new", os.O_WRONLY | os.O_CREATE, ErrPermission},
+			{"Search", owner, "root/file", os.O_RDONLY, ErrPermission},
+			{"ListDenied", owner, "root", os.O_RDONLY, ErrPermission},
+			{"Root", &Credential{}, "root/file", os.O_RDWR, nil},
+			{"Missing", owner, "home/missing", os.O_RDONLY, ErrNoEntry},
+			{"Create", owner, "home/new", os.O_WRONLY | os.O_CREATE, nil},
+		} {
+			fs.SetCredential(c.cred)
+			f, err := fs.OpenFile(c.path, c.flags)
+			if err != c.want {
+				t.Errorf("%s: expected %v; got %v", c.name, c.want, err)
+			}
+			if err == nil {
+				check(t, f.Close())
+			}
+		}
+		fs.SetCredential(nil)
+
+		info, err := fs.Stat("home/new")
+		check(t, err)
+		if st := info.Sys().(*Stat_t); st.Mode != 0100644 || st.Uid != 1000 || st.Gid != 1000 {
+			t.Errorf("unexpected permissions of a new file: %+v", *st)
+		}
+		if !errors.Is(ErrPermission, os.ErrPermission) {
+			t.Error("expected ErrPermission to match os.ErrPermission")
+		}
+
+		// encrypted and compressed files are checked as well
+		fs.SetCredential(member)
+		if _, err := fs.OpenEncrypted("home/private", os.O_WRONLY|os.O_TRUNC, make([]byte, 32)); err != ErrPermission {
+			t.Errorf("expected ErrPermission; got %v", err)
+		}
+		if _, err := fs.OpenCompressed("home/private", os.O_WRONLY|os.O_TRUNC, Deflate); err != ErrPermission {
+			t.Errorf("expected ErrPermission; got %v", err)
+		}
+		f, err := fs.OpenCompressed("home/log", os.O_WRONLY|os.O_CREATE, Deflate)
+		if err != ErrPermission {
+			t.Errorf("expected ErrPermission; got %v", err)
+			f.Close()
+		}
+		fs.SetCredential(owner)
+		f, err = fs.OpenCompressed("home/log", os.O_WRONLY|os.O_CREATE, Deflate)
+		check(t, err)
+		check(t, f.Close())
+		fs.SetCredential(nil)
+		info, err = fs.Stat("home/log")
+		check(t, err)
+		if st := info.Sys().(*Stat_t); st.Mode != 0100644 || st.Uid != 1000 {
+			t.Errorf("unexpected permissions of a new compressed file: %+v", *st)
+		}
+
+		// without a credential nothing is checked
+		check(t, fs.WriteFile("home/private", []byte("root")))
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("file", []byte("v1")))
+		check(t, fs.Chown("file", 1000, 100))
+		check(t, fs.Chmod("file", 0640))
+		check(t, fs.WriteFile("plain", []byte("v1")))
+
+		fs.SetCredential(&Credential{Uid: 1000, Gid: 1000})
+		expect := func(name string, mode, uid, gid uint32) {
+			t.Helper()
+			info, err := fs.Stat(name)
+			check(t, err)
+			if st := info.Sys().(*Stat_t); st.Mode&0777 != mode || st.Uid != uid || st.Gid != gid {
+				t.Errorf("%s: unexpected permissions %+v", name, *st)
+			}
+		}
+		check(t, fs.WriteFileAtomic("file", []byte("v2")))
+		expect("file", 0640, 1000, 100)
+		check(t, fs.CompressFile("file", Deflate))
+		expect("file", 0640, 1000, 100)
+		check(t, fs.DecompressFile("file", Deflate))
+		expect("file", 0640, 1000, 100)
+		tx, err := fs.Begin()
+		check(t, err)
+		f, err := tx.Create("file")
+		check(t, err)
+		check(t, f.Close())
+		check(t, tx.Commit())
+		expect("file", 0640, 1000, 100)
+
+		// files without permissions keep having none
+		check(t, fs.WriteFileAtomic("plain", []byte("v2")))
+		expect("plain", 0777, 0, 0)
+		// new files belong to the credential
+		check(t, fs.WriteFileAtomic("new", []byte("v1")))
+		expect("new", 0644, 1000, 1000)
+	})
+
+	t.Run("Revisions", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("secret", []byte("v1")))
+		check(t, fs.Chown("secret", 1000, 1000))
+		check(t, fs.Chmod("secret", 0600))
+		check(t, fs.SetVersions("secret", 3))
+		check(t, fs.WriteFile("secret", []byte("v2")))
+
+		fs.SetCredential(&Credential{Uid: 2000, Gid: 2000})
+		if _, err := fs.ReadRevision("secret", 1); err != ErrPermission {
+			t.Errorf("expected ErrPermission; got %v", err)
+		}
+		if err := fs.RestoreRevision("secret", 1); err != ErrPermission {
+			t.Errorf("expected ErrPermission; got %v", err)
+		}
+		fs.SetCredential(&Credential{Uid: 1000, Gid: 1000})
+		if data, err := fs.ReadRevision("secret", 1); err != nil || string(data) != "v1" {
+			t.Errorf("expected the revision to hold %q; got %q, %v", "v1", data, err)
+		}
+		check(t, fs.RestoreRevision("secret", 1))
+	})
+}
@@ -0,0 +1,280 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"os"
+	"path"
+)
+
+// The AttrQuota attribute of a directory holds the limits set with SetQuota:
+//
+//	bytes uint64
+//	files uint64
+//
+// as little-endian integers
+const quotaSize = 16
+
+// Quota limits what a directory may hold, counting everything below it as
+// DiskUsage does; a limit of 0 means there is none
+type Quota struct {
+	Bytes int64 // space taken, as in Usage
+	Files int64 // number of files, as in Usage
+}
+
+// Usage is the space taken by a file or directory tree, see DiskUsage
+type Usage struct {
+	// Bytes is the space taken by the contents of files and directories:
+	// files inlined in the metadata of their directory take their size,
+	// others whole blocks, including the pointers littlefs keeps in them;
+	// a directory takes the two blocks of its metadata pair.
+	Bytes int64
+
+	// Files is the number of regular files, including symbolic links
+	Files int64
+
+	// Dirs is the number of directories, including the one DiskUsage is
+	// called on
+	Dirs int64
+}
+
+// SetQuota limits what the named directory and the directories below it may
+// hold. Writes, truncations, and the creation of files by OpenFile,
+// OpenEncrypted, OpenCompressed and Symlink which would exceed the limits fail
+// with ErrQuotaExceeded; the
+// limits are checked by walking the whole directory tree, so they are best
+// kept to small trees. Files already open when a quota is set are not held to
+// it. A quota can be set lower than what the directory already holds, which
+// keeps it from growing any more.
+//
+// The quota is kept in the AttrQuota attribute of the directory, and setting
+// a zero Quota removes it.
+func (l *LFS) SetQuota(dir string, q Quota) error {
+	if l.readonly {
+		return ErrReadOnly
+	}
+	if q.Bytes < 0 || q.Files < 0 {
+		return ErrInvalidParam
+	}
+	resolved, err := l.resolve(dir, true)
+	if err != nil {
+		return err
+	}
+	if info, err := l.stat(resolved, resolved); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return ErrNotDir
+	}
+	if q == (Quota{}) {
+		err := l.Removeattr(resolved, AttrQuota)
+		if err == ErrNoAttr {
+			err = nil
+		}
+		return err
+	}
+	buf := make([]byte, quotaSize)
+	binary.LittleEndian.PutUint64(buf[0:], uint64(q.Bytes))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(q.Files))
+	return l.Setattr(resolved, AttrQuota, buf)
+}
+
+// Quota returns the quota set on the named directory with SetQuota, or a zero
+// Quota if there is none
+func (l *LFS) Quota(dir string) (Quota, error) {
+	resolved, err := l.resolve(dir, true)
+	if err != nil {
+		return Quota{}, err
+	}
+	if info, err := l.stat(resolved, resolved); err != nil {
+		return Quota{}, err
+	} else if !info.IsDir() {
+		return Quota{}, ErrNotDir
+	}
+	return l.quota(resolved)
+}
+
+// DiskUsage returns the space taken by the named file, or by the named
+// directory and everything below it, like du(1)
+func (l *LFS) DiskUsage(name string) (Usage, error) {
+	resolved, err := l.resolve(name, true)
+	if err != nil {
+		return Usage{}, err
+	}
+	var u Usage
+	if err := l.usage(absPath(resolved), "", &u); err != nil {
+		return Usage{}, err
+	}
+	return u, nil
+}
+
+// quota reads the AttrQuota attribute of the named directory, which must
+// already be resolved
+func (l *LFS) quota(dir string) (Quota, error) {
+	var buf [quotaSize]byte
+	n, err := l.Getattr(dir, AttrQuota, buf[:])
+	if err == ErrNoAttr {
+		return Quota{}, nil
+	}
+	if err != nil {
+		return Quota{}, err
+	}
+	if n != len(buf) {
+		return Quota{}, ErrCorrupt
+	}
+	return Quota{
+		Bytes: int64(binary.LittleEndian.Uint64(buf[0:])),
+		Files: int64(binary.LittleEndian.Uint64(buf[8:])),
+	}, nil
+}
+
+// quotaDirs returns the directories above the named file, which must already
+// be resolved, which have a quota
+func (l *LFS) quotaDirs(name string) ([]string, error) {
+	var dirs []string
+	for dir := path.Dir(absPath(name)); ; dir = path.Dir(dir) {
+		q, err := l.quota(dir)
+		if err != nil && err != ErrNoEntry {
+			return nil, err
+		}
+		if q != (Quota{}) {
+			dirs = append(dirs, dir)
+		}
+		if dir == "/" {
+			return dirs, nil
+		}
+	}
+}
+
+// usage adds the space taken by the file with the absolute path name, or by
+// the directory tree below it, to u; the file with the absolute path except
+// is left out
+func (l *LFS) usage(name string, except string, u *Usage) error {
+	f, err := l.openFile(name, os.O_RDONLY, nil)
+	if err != nil {
+		return err
+	}
+	if !f.IsDir() {
+		defer f.Close()
+		if name == except {
+			return nil
+		}
+		size, err := f.Size()
+		if err != nil {
+			return err
+		}
+		u.Files++
+		u.Bytes += l.fileUsage(size, f.inline())
+		return nil
+	}
+	infos, err := f.Readdir(0)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	u.Dirs++
+	u.Bytes += 2 * int64(l.cfg.block_size)
+	for _, info := range infos {
+		if err := l.usage(path.Join(name, info.Name()), except, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileUsage returns the space taken by a file of size bytes, which may be
+// inlined in the metadata of its directory
+func (l *LFS) fileUsage(size int64, inline bool) int64 {
+	if inline {
+		return size
+	}
+	bs := int64(l.cfg.block_size)
+	return fileBlocks(size, bs) * bs
+}
+
+// inlineMax returns the size up to which littlefs keeps a file inlined in the
+// metadata of its directory
+func (l *LFS) inlineMax() int64 {
+	return min(0x3fe, int64(l.cfg.cache_size), int64(l.cfg.block_size)/8)
+}
+
+// checkCreate checks that creating the named file, which must already be
+// resolved, does not exceed the quotas of the directories above it
+func (l *LFS) checkCreate(name string) error {
+	dirs, err := l.quotaDirs(name)
+	if err != nil || len(dirs) == 0 {
+		return err
+	}
+	if _, err := l.stat(name, name); err != ErrNoEntry {
+		// the file already exists, or it cannot be created anyway
+		return nil
+	}
+	return l.checkQuotas(dirs, "", 0, 1)
+}
+
+// checkQuotas checks that adding files and bytes to each of dirs, leaving out
+// the file with the absolute path except, does not exceed their quotas; the
+// number of files is not checked if files is 0
+func (l *LFS) checkQuotas(dirs []string, except string, bytes int64, files int64) error {
+	for _, dir := range dirs {
+		q, err := l.quota(dir)
+		if err != nil {
+			return err
+		}
+		var u Usage
+		if err := l.usage(dir, except, &u); err != nil {
+			return err
+		}
+		if q.Bytes > 0 && u.Bytes+bytes > q.Bytes {
+			return ErrQuotaExceeded
+		}
+		if files > 0 && q.Files > 0 && u.Files+files > q.Files {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// checkQuotaGrowth checks that writing to f up to end does not exceed the
+// quotas of the directories above it
+func (f *File) checkQuotaGrowth(end int64) error {
+	if len(f.quotas) == 0 {
+		return nil
+	}
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	if end <= size {
+		// writing within the file does not make it take more space
+		// once it is synced
+		return nil
+	}
+	l := f.lfs
+	inline := f.inline() && end <= l.inlineMax()
+	usage := l.fileUsage(end, inline)
+	if usage == l.fileUsage(size, f.inline()) {
+		// the write fills up the last block of the file, which is already
+		// counted, so the usage of the directories need not be walked
+		return nil
+	}
+	return l.checkQuotas(f.quotas, absPath(f.path), usage, 0)
+}
+
+// checkQuotaWrite checks that writing n bytes to f does not exceed the quotas
+// of the directories above it
+func (f *File) checkQuotaWrite(n int64) error {
+	if len(f.quotas) == 0 || n == 0 {
+		return nil
+	}
+	pos, err := f.Size()
+	if err != nil {
+		return err
+	}
+	if !f.appending() {
+		if pos, err = f.Tell(); err != nil {
+			return err
+		}
+	}
+	return f.checkQuotaGrowth(pos + n)
+}
@@ -0,0 +1,162 @@
+package lfs
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestQuota(t *testing.T) {
+	bs := int64(defaultConfig.BlockSize)
+
+	t.Run("DiskUsage", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("app"))
+		check(t, fs.Mkdir("app/sub"))
+		check(t, fs.WriteFile("app/inline", make([]byte, 10)))
+		check(t, fs.WriteFile("app/sub/file", make([]byte, 1000)))
+		check(t, fs.Symlink("sub/file", "app/link"))
+
+		for _, c := range []struct {
+			name string
+			want Usage
+		}{
+			{"app", Usage{Bytes: 4*bs + 10 + 2*bs + 8, Files: 3, Dirs: 2}},
+			{"app/sub", Usage{Bytes: 2*bs + 2*bs, Files: 1, Dirs: 1}},
+			{"app/sub/file", Usage{Bytes: 2 * bs, Files: 1}},
+			{"app/link", Usage{Bytes: 2 * bs, Files: 1}},
+			{"app/inline", Usage{Bytes: 10, Files: 1}},
+		} {
+			if u, err := fs.DiskUsage(c.name); err != nil || u != c.want {
+				t.Errorf("%s: expected %+v; got %+v, %v", c.name, c.want, u, err)
+			}
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		fs, bd, _ := createTestFS(t, defaultConfig)
+		check(t, fs.Mkdir("app"))
+		check(t, fs.Mkdir("app/sub"))
+		check(t, fs.WriteFile("app/sub/data", make([]byte, 400)))
+		check(t, fs.SetQuota("app", Quota{Bytes: 5*bs + bs/2}))
+
+		// the quota is kept when the filesystem is mounted again
+		check(t, fs.Unmount())
+		fs = New(defaultConfig, bd)
+		check(t, fs.Mount())
+		defer fs.Unmount()
+		if q, err := fs.Quota("app"); err != nil || q != (Quota{Bytes: 5*bs + bs/2}) {
+			t.Errorf("unexpected quota %+v, %v", q, err)
+		}
+
+		// the two directories and the file take five blocks
+		f, err := fs.OpenFile("app/sub/data", os.O_WRONLY|os.O_APPEND)
+		check(t, err)
+		if _, err := f.Write(make([]byte, 200)); err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+		if _, err := f.Write(make([]byte, 100)); err != nil {
+			t.Errorf("expected a write within the last block to succeed; got %v", err)
+		}
+		if err := f.Truncate(uint32(2 * bs)); err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+		check(t, f.Truncate(10))
+		check(t, f.Close())
+		if err := fs.WriteFile("app/other", make([]byte, bs)); err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+		check(t, fs.WriteFile("app/other", make([]byte, 10)))
+		check(t, fs.WriteFile("elsewhere", make([]byte, 4*bs)))
+
+		check(t, fs.SetQuota("app", Quota{}))
+		check(t, fs.WriteFile("app/other", make([]byte, 4*bs)))
+		if q, err := fs.Quota("app"); err != nil || q != (Quota{}) {
+			t.Errorf("expected the quota to be removed; got %+v, %v", q, err)
+		}
+	})
+
+	t.Run("Chunked", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		key := make([]byte, 32)
+		check(t, fs.Mkdir("app"))
+		check(t, fs.SetQuota("app", Quota{Bytes: 4 * bs, Files: 1}))
+		f, err := fs.OpenEncrypted("app/secret", os.O_WRONLY|os.O_CREATE, key)
+		check(t, err)
+		_, err = f.Write(make([]byte, 3*bs))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+		if _, err := fs.OpenCompressed("app/log", os.O_WRONLY|os.O_CREATE, Deflate); err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+	})
+
+	t.Run("Traversals", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("app"))
+		for i := 0; i < 10; i++ {
+			check(t, fs.WriteFile(fmt.Sprintf("app/file.%d", i), make([]byte, bs)))
+		}
+		// the usage of the directory is only walked by writes which make
+		// the file take more space, rather than those filling up a block
+		reads := func(name string) int {
+			f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+			check(t, err)
+			defer f.Close()
+			_, err = f.Write(make([]byte, 32))
+			check(t, err)
+			n := 0
+			fs.SetObserver(ObserverFunc(func(e Event) {
+				if e.Op == OpBlockRead {
+					n++
+				}
+			}))
+			defer fs.SetObserver(nil)
+			for i := int64(32); i < bs; i += 16 {
+				if _, err := f.Write(make([]byte, 16)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return n
+		}
+		unchecked := reads("app/log.0")
+		check(t, fs.SetQuota("app", Quota{Bytes: 100 * bs}))
+		if checked := reads("app/log.1"); checked > 3*unchecked {
+			t.Errorf("expected writes within a block not to be checked; %d block reads instead of %d", checked, unchecked)
+		}
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("app"))
+		check(t, fs.Mkdir("app/sub"))
+		check(t, fs.SetQuota("app", Quota{Files: 2}))
+		check(t, fs.WriteFile("app/a", nil))
+		check(t, fs.WriteFile("app/sub/b", nil))
+		if _, err := fs.OpenFile("app/c", os.O_WRONLY|os.O_CREATE); err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+		if err := fs.Symlink("a", "app/sub/c"); err != ErrQuotaExceeded {
+			t.Errorf("expected ErrQuotaExceeded; got %v", err)
+		}
+		// existing files can still be opened with O_CREATE
+		check(t, fs.WriteFile("app/a", []byte("contents")))
+		check(t, fs.Remove("app/a"))
+		check(t, fs.WriteFile("app/c", nil))
+
+		if err := fs.SetQuota("app/c", Quota{Files: 1}); err != ErrNotDir {
+			t.Errorf("expected ErrNotDir; got %v", err)
+		}
+		if err := fs.SetQuota("app", Quota{Files: -1}); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+}
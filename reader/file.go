@@ -0,0 +1,224 @@
+package reader
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// fileInfo describes a file or directory; like lfs.Info, littlefs does not
+// record modification times
+type fileInfo struct {
+	name string
+	size int64
+	dir  bool
+	link bool
+	mode uint32 // permission bits, as in st_mode
+}
+
+func (info *fileInfo) Name() string {
+	return info.name
+}
+
+func (info *fileInfo) Size() int64 {
+	return info.size
+}
+
+func (info *fileInfo) IsDir() bool {
+	return info.dir
+}
+
+func (info *fileInfo) Sys() interface{} {
+	return nil
+}
+
+func (info *fileInfo) Mode() fs.FileMode {
+	v := fs.FileMode(info.mode) & fs.ModePerm
+	if info.mode&04000 != 0 {
+		v |= fs.ModeSetuid
+	}
+	if info.mode&02000 != 0 {
+		v |= fs.ModeSetgid
+	}
+	if info.mode&01000 != 0 {
+		v |= fs.ModeSticky
+	}
+	if info.IsDir() {
+		v |= fs.ModeDir
+	}
+	if info.link {
+		v |= fs.ModeSymlink
+	}
+	return v
+}
+
+func (info *fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (info *fileInfo) Type() fs.FileMode {
+	return info.Mode().Type()
+}
+
+func (info *fileInfo) Info() (fs.FileInfo, error) {
+	return info, nil
+}
+
+// File is a regular file opened for reading; it implements fs.File,
+// io.Seeker and io.ReaderAt
+type File struct {
+	fs     *FS
+	info   *fileInfo
+	inline []byte
+	head   uint32
+	pos    int64
+	closed bool
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *File) Read(buf []byte) (int, error) {
+	n, err := f.ReadAt(buf, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(buf) bytes from the file starting at offset off
+func (f *File) ReadAt(buf []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("littlefs: negative offset")
+	}
+	var n int
+	for n < len(buf) {
+		pos := off + int64(n)
+		if pos >= f.info.size {
+			return n, io.EOF
+		}
+		remaining := buf[n:]
+		if int64(len(remaining)) > f.info.size-pos {
+			remaining = remaining[:f.info.size-pos]
+		}
+		if f.inline != nil {
+			n += copy(remaining, f.inline[pos:])
+			continue
+		}
+		block, boff, err := f.fs.ctzFind(f.head, uint32(f.info.size), uint32(pos))
+		if err != nil {
+			return n, err
+		}
+		if diff := f.fs.blockSize - boff; uint32(len(remaining)) > diff {
+			remaining = remaining[:diff]
+		}
+		if err := f.fs.readAt(block, boff, remaining); err != nil {
+			return n, err
+		}
+		n += len(remaining)
+	}
+	return n, nil
+}
+
+// Seek sets the offset for the next Read, as described by io.Seeker
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, errors.New("littlefs: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("littlefs: negative offset")
+	}
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// dir is a directory opened for reading; it implements fs.ReadDirFile
+type dir struct {
+	fs      *FS
+	e       *entry
+	info    *fileInfo
+	entries []fs.DirEntry
+	read    bool
+	closed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read(buf []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *dir) Close() error {
+	if d.closed {
+		return fs.ErrClosed
+	}
+	d.closed = true
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, as described by
+// fs.ReadDirFile
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.closed {
+		return nil, fs.ErrClosed
+	}
+	if !d.read {
+		pair, err := d.fs.dirPair(d.e)
+		if err != nil {
+			return nil, err
+		}
+		var ferr error
+		err = d.fs.readdir(pair, func(e *entry) bool {
+			info, err := d.fs.stat(e)
+			if err != nil {
+				ferr = err
+				return false
+			}
+			d.entries = append(d.entries, info)
+			return true
+		})
+		if err == nil {
+			err = ferr
+		}
+		if err != nil {
+			return nil, err
+		}
+		d.read = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,397 @@
+package reader
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/bits"
+)
+
+// Inspection is a low-level description of the metadata in an image, meant
+// for diagnosing corrupt images
+type Inspection struct {
+	// Pairs are the metadata pairs found by following the tail pointers from
+	// the superblock, in order
+	Pairs []MetadataPair
+
+	// Root is the metadata pair of the root directory, which is the last pair
+	// holding a superblock entry
+	Root [2]uint32
+
+	// GlobalState is the xor-sum of the global state deltas in every pair
+	GlobalState GlobalState
+
+	// Err is set if the list of metadata pairs could not be followed to its
+	// end, for instance because both blocks of a pair are corrupt
+	Err error
+}
+
+// MetadataPair describes both blocks of a metadata pair
+type MetadataPair struct {
+	Pair   [2]uint32
+	Blocks [2]MetadataBlock
+
+	// Active is the index in Blocks of the block that littlefs reads the
+	// metadata from, or -1 if neither block has a valid commit
+	Active int
+
+	// Tail is the pointer to the next metadata pair, as of the last valid
+	// commit of the active block; Split is set for a hard tail, meaning the
+	// next pair is a continuation of the same directory
+	Tail  [2]uint32
+	Split bool
+
+	// Count is the number of ids in the pair
+	Count uint16
+}
+
+// MetadataBlock describes the commits in one block of a metadata pair
+type MetadataBlock struct {
+	Block    uint32
+	Revision uint32
+	Commits  []Commit
+
+	// End is the offset just past the last valid commit; zero if there is no
+	// valid commit in the block
+	End uint32
+
+	// Err is set if the block could not be read
+	Err error
+}
+
+// Commit is a sequence of tags ending in a CRC tag. The last commit in a
+// block may be incomplete, in which case it has no CRC tag and is not Valid.
+type Commit struct {
+	Offset      uint32
+	Tags        []Tag
+	CRC         uint32 // CRC stored in the commit
+	ComputedCRC uint32 // CRC computed over the commit
+	Valid       bool
+}
+
+// Tag is a decoded metadata tag, see docs/SPEC.md
+type Tag struct {
+	Offset uint32 // offset of the tag in its block
+	Raw    uint32 // tag value, after undoing the xor with the previous tag
+	Type   uint16 // type3 field, including the chunk
+	ID     uint16
+	Length uint16 // 0x3ff means the tag has been deleted
+	Data   []byte
+}
+
+// TypeName returns the name of the tag's type, as used in docs/SPEC.md
+func (t Tag) TypeName() string {
+	switch t.Type {
+	case typeReg:
+		return "reg"
+	case typeDir:
+		return "dir"
+	case typeSuperblock:
+		return "superblock"
+	case typeDirStruct:
+		return "dirstruct"
+	case typeInlineStruct:
+		return "inlinestruct"
+	case typeCTZStruct:
+		return "ctzstruct"
+	case typeCreate:
+		return "create"
+	case typeDelete:
+		return "delete"
+	case typeSoftTail:
+		return "softtail"
+	case typeHardTail:
+		return "hardtail"
+	case typeMoveState:
+		return "movestate"
+	}
+	switch uint32(t.Type) &^ 0xff {
+	case typeName:
+		return fmt.Sprintf("name 0x%02x", t.Type&0xff)
+	case typeUserAttr:
+		return fmt.Sprintf("userattr 0x%02x", t.Type&0xff)
+	case typeCRC:
+		return fmt.Sprintf("crc 0x%02x", t.Type&0xff)
+	}
+	return fmt.Sprintf("0x%03x", t.Type)
+}
+
+// GlobalState is the decoded global move state
+type GlobalState struct {
+	Raw     uint32    // tag of the global state
+	Pair    [2]uint32 // metadata pair containing a pending move
+	Orphans uint32    // number of orphans waiting to be cleaned up
+	HasMove bool      // a rename was interrupted
+	MoveID  uint16    // id of the file being moved in Pair
+}
+
+func (g *gstate) decode() GlobalState {
+	return GlobalState{
+		Raw:     uint32(g.tag),
+		Pair:    g.pair,
+		Orphans: g.orphans(),
+		HasMove: g.hasMove(),
+		MoveID:  uint16(g.tag.id()),
+	}
+}
+
+// Inspect decodes the metadata pairs of the image on dev. Unlike Mount, it
+// does not give up on a corrupt image; it describes as much as it can and
+// records the first error that stopped it in the Inspection.
+func Inspect(dev BlockDevice, config Config) (*Inspection, error) {
+	if config.BlockSize < 4 {
+		return nil, fmt.Errorf("littlefs: invalid block size")
+	}
+	fsys := &FS{dev: dev, blockSize: config.BlockSize, blockCount: config.BlockCount}
+	ins := &Inspection{Root: [2]uint32{blockNull, blockNull}}
+	var gpending gstate
+	seen := map[uint32]bool{}
+	for tail := [2]uint32{0, 1}; !pairIsNull(tail); {
+		if seen[tail[0]] {
+			ins.Err = fmt.Errorf("littlefs: cycle in metadata list at {0x%x, 0x%x}", tail[0], tail[1])
+			break
+		}
+		seen[tail[0]] = true
+
+		p, m, err := fsys.inspectPair(tail)
+		if err != nil {
+			return nil, err
+		}
+		ins.Pairs = append(ins.Pairs, p)
+		if m == nil {
+			ins.Err = ErrCorrupt
+			break
+		}
+		if _, name, err := fsys.get(m, mktag(0x7ff, 0x3ff, 0), mktag(typeSuperblock, 0, 8)); err == nil && string(name) == "littlefs" {
+			ins.Root = tail
+		}
+		if _, data, err := fsys.get(m, mktag(0x7ff, 0, 0), mktag(typeMoveState, 0, 12)); err == nil {
+			gpending.xor(data)
+		}
+		tail = m.tail
+	}
+	ins.GlobalState = gpending.decode()
+	return ins, nil
+}
+
+// InspectImage inspects an image held in r, such as a file containing a dump
+// of a block device
+func InspectImage(r io.ReaderAt, config Config) (*Inspection, error) {
+	return Inspect(&imageDevice{r: r, blockSize: config.BlockSize}, config)
+}
+
+// inspectPair decodes both blocks of a pair; the returned mdir is the same
+// one fetch would return, or nil if neither block holds a valid commit
+func (fsys *FS) inspectPair(pair [2]uint32) (MetadataPair, *mdir, error) {
+	p := MetadataPair{Pair: pair, Active: -1}
+	for i, block := range pair {
+		b := &p.Blocks[i]
+		b.Block = block
+		data, err := fsys.readBlock(block)
+		if err == ErrCorrupt {
+			b.Err = err
+			continue
+		} else if err != nil {
+			return p, nil, err
+		}
+		b.Revision = binary.LittleEndian.Uint32(data)
+		m := &mdir{pair: [2]uint32{block, pair[(i+1)%2]}, rev: b.Revision, data: data}
+		commit := &Commit{Offset: 4}
+		m.parse(func(off uint32, t tag, data []byte, crc uint32) {
+			commit.Tags = append(commit.Tags, Tag{
+				Offset: off,
+				Raw:    uint32(t),
+				Type:   uint16(t.type3()),
+				ID:     uint16(t.id()),
+				Length: uint16(t.size()),
+				Data:   data,
+			})
+			if t.type1() == typeCRC {
+				commit.CRC = binary.LittleEndian.Uint32(data)
+				commit.ComputedCRC = crc
+				commit.Valid = crc == commit.CRC
+				b.Commits = append(b.Commits, *commit)
+				commit = &Commit{Offset: off + t.dsize()}
+			}
+		})
+		if len(commit.Tags) > 0 {
+			b.Commits = append(b.Commits, *commit)
+		}
+		b.End = m.off
+	}
+
+	m, err := fsys.fetch(pair)
+	if err == ErrCorrupt {
+		return p, nil, nil
+	} else if err != nil {
+		return p, nil, err
+	}
+	if m.pair[0] == pair[0] {
+		p.Active = 0
+	} else {
+		p.Active = 1
+	}
+	p.Tail, p.Split, p.Count = m.tail, m.split, m.count
+	return p, m, nil
+}
+
+// Dump writes a human readable description of the inspection to w
+func (ins *Inspection) Dump(w io.Writer) error {
+	pw := &printer{w: w}
+	pw.printf("root: {0x%x, 0x%x}\n", ins.Root[0], ins.Root[1])
+	g := ins.GlobalState
+	pw.printf("gstate: 0x%08x orphans=%d move=%t", g.Raw, g.Orphans, g.HasMove)
+	if g.HasMove {
+		pw.printf(" movepair={0x%x, 0x%x} moveid=%d", g.Pair[0], g.Pair[1], g.MoveID)
+	}
+	pw.printf("\n")
+	for _, p := range ins.Pairs {
+		pw.printf("\nmetadata pair {0x%x, 0x%x}", p.Pair[0], p.Pair[1])
+		if p.Active >= 0 {
+			tailType := "softtail"
+			if p.Split {
+				tailType = "hardtail"
+			}
+			pw.printf(" active=0x%x count=%d %s={0x%x, 0x%x}",
+				p.Pair[p.Active], p.Count, tailType, p.Tail[0], p.Tail[1])
+		} else {
+			pw.printf(" corrupt")
+		}
+		pw.printf("\n")
+		for _, b := range p.Blocks {
+			pw.printf("  block 0x%x", b.Block)
+			if b.Err != nil {
+				pw.printf(": %v\n", b.Err)
+				continue
+			}
+			pw.printf(" rev=%d end=%d\n", b.Revision, b.End)
+			for _, c := range b.Commits {
+				pw.printf("    commit at %d", c.Offset)
+				switch {
+				case c.Valid:
+					pw.printf(" crc=0x%08x\n", c.CRC)
+				case c.CRC != 0 || c.ComputedCRC != 0:
+					pw.printf(" crc=0x%08x BAD (computed 0x%08x)\n", c.CRC, c.ComputedCRC)
+				default:
+					pw.printf(" incomplete\n")
+				}
+				for _, t := range c.Tags {
+					pw.printf("      %5d: 0x%08x %-16s id=%-4d len=%-4d", t.Offset, t.Raw, t.TypeName(), t.ID, t.Length)
+					if t.Length == 0x3ff {
+						pw.printf(" (deleted)")
+					} else {
+						pw.printf(" %s", describeData(t))
+					}
+					pw.printf("\n")
+				}
+			}
+		}
+	}
+	if ins.Err != nil {
+		pw.printf("\nerror: %v\n", ins.Err)
+	}
+	return pw.err
+}
+
+// describeData summarizes the data of a tag according to its type
+func describeData(t Tag) string {
+	le32 := func(i int) uint32 {
+		if len(t.Data) < 4*(i+1) {
+			return 0
+		}
+		return binary.LittleEndian.Uint32(t.Data[4*i:])
+	}
+	switch {
+	case t.Type == typeDirStruct, t.Type == typeSoftTail, t.Type == typeHardTail:
+		return fmt.Sprintf("{0x%x, 0x%x}", le32(0), le32(1))
+	case t.Type == typeCTZStruct:
+		return fmt.Sprintf("head=0x%x size=%d", le32(0), le32(1))
+	case t.Type == typeMoveState:
+		return fmt.Sprintf("delta=0x%08x {0x%x, 0x%x}", le32(0), le32(1), le32(2))
+	case uint32(t.Type)&^0xff == typeName:
+		return fmt.Sprintf("%q", t.Data)
+	case uint32(t.Type)&^0xff == typeCRC:
+		return ""
+	}
+	if len(t.Data) > 16 {
+		return fmt.Sprintf("% x ...", t.Data[:16])
+	}
+	return fmt.Sprintf("% x", t.Data)
+}
+
+type printer struct {
+	w   io.Writer
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+// FileLayout describes where the contents of a file are stored
+type FileLayout struct {
+	Size   uint32
+	Inline bool // contents are stored in the file's metadata pair
+
+	// Pair and ID identify the file's entry
+	Pair [2]uint32
+	ID   uint16
+
+	// Blocks is the CTZ skip-list holding the contents of a file that is not
+	// inline, ordered from the first block of the file to the last
+	Blocks []CTZBlock
+}
+
+// CTZBlock is one block of a CTZ skip-list
+type CTZBlock struct {
+	Index uint32
+	Block uint32
+
+	// Pointers are the skip-list pointers at the start of the block;
+	// Pointers[i] points to the block with index Index-2^i
+	Pointers []uint32
+}
+
+// Layout decodes where the contents of the named file are stored
+func (fsys *FS) Layout(name string) (*FileLayout, error) {
+	e, err := fsys.lookup("layout", name, false)
+	if err != nil {
+		return nil, err
+	}
+	if e.typ == typeDir {
+		return nil, &fs.PathError{Op: "layout", Path: name, Err: errIsDir}
+	}
+	inline, head, size, err := fsys.fileStruct(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "layout", Path: name, Err: err}
+	}
+	layout := &FileLayout{Size: size, Inline: inline != nil, Pair: e.m.pair, ID: uint16(e.id)}
+	if inline != nil || size == 0 {
+		return layout, nil
+	}
+
+	index, _ := ctzIndex(fsys.blockSize, size-1)
+	layout.Blocks = make([]CTZBlock, index+1)
+	for {
+		b := CTZBlock{Index: index, Block: head}
+		if index > 0 {
+			b.Pointers = make([]uint32, bits.TrailingZeros32(index)+1)
+			buf := make([]byte, 4*len(b.Pointers))
+			if err := fsys.readAt(head, 0, buf); err != nil {
+				return nil, &fs.PathError{Op: "layout", Path: name, Err: err}
+			}
+			for i := range b.Pointers {
+				b.Pointers[i] = binary.LittleEndian.Uint32(buf[4*i:])
+			}
+		}
+		layout.Blocks[index] = b
+		if index == 0 {
+			return layout, nil
+		}
+		head = b.Pointers[0]
+		index--
+	}
+}
@@ -0,0 +1,127 @@
+package reader_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	lfs "github.com/bgould/go-littlefs"
+	"github.com/bgould/go-littlefs/reader"
+)
+
+func TestInspect(t *testing.T) {
+	dev := lfs.NewMemoryDevice(config)
+	fs := lfs.New(config, dev)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	check(t, fs.Mkdir("dir"))
+	data := bytes.Repeat([]byte("0123456789abcdef"), 700)
+	for _, name := range []string{"dir/big", "small"} {
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		if name == "small" {
+			_, err = f.Write([]byte("tiny"))
+		} else {
+			_, err = f.Write(data)
+		}
+		check(t, err)
+		check(t, f.Close())
+	}
+	var blocks []uint32
+	check(t, fs.Traverse(func(block uint32) error {
+		blocks = append(blocks, block)
+		return nil
+	}))
+	check(t, fs.Unmount())
+
+	rconfig := reader.Config{BlockSize: config.BlockSize, BlockCount: config.BlockCount}
+	ins, err := reader.Inspect(dev, rconfig)
+	check(t, err)
+	if ins.Err != nil {
+		t.Fatal(ins.Err)
+	}
+	if ins.Root != [2]uint32{0, 1} {
+		t.Errorf("unexpected root pair: %v", ins.Root)
+	}
+	if len(ins.Pairs) != 2 {
+		t.Fatalf("expected root and dir pairs; found %d", len(ins.Pairs))
+	}
+	var superblock bool
+	for _, p := range ins.Pairs {
+		if p.Active < 0 {
+			t.Fatalf("pair %v has no valid commit", p.Pair)
+		}
+		active := p.Blocks[p.Active]
+		if len(active.Commits) == 0 {
+			t.Fatalf("pair %v: no commits", p.Pair)
+		}
+		for _, c := range active.Commits {
+			if c.Offset >= active.End {
+				continue
+			}
+			if !c.Valid || c.CRC != c.ComputedCRC {
+				t.Errorf("pair %v: bad commit at %d", p.Pair, c.Offset)
+			}
+			for _, tag := range c.Tags {
+				if tag.TypeName() == "superblock" && string(tag.Data) == "littlefs" {
+					superblock = true
+				}
+			}
+		}
+	}
+	if !superblock {
+		t.Errorf("superblock tag not found")
+	}
+	var out strings.Builder
+	check(t, ins.Dump(&out))
+	for _, want := range []string{"superblock", "dirstruct", "ctzstruct", "inlinestruct", "crc"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dump does not mention %s:\n%s", want, out.String())
+		}
+	}
+
+	r, err := reader.Mount(dev, rconfig)
+	check(t, err)
+	layout, err := r.Layout("small")
+	check(t, err)
+	if !layout.Inline || layout.Size != 4 || len(layout.Blocks) != 0 {
+		t.Errorf("unexpected layout for inline file: %+v", layout)
+	}
+	layout, err = r.Layout("dir/big")
+	check(t, err)
+	if layout.Inline || layout.Size != uint32(len(data)) {
+		t.Fatalf("unexpected layout: %+v", layout)
+	}
+	used := map[uint32]bool{}
+	for _, b := range blocks {
+		used[b] = true
+	}
+	for i, b := range layout.Blocks {
+		if b.Index != uint32(i) || !used[b.Block] {
+			t.Errorf("unexpected block %d: %+v", i, b)
+		}
+		if i > 0 && b.Pointers[0] != layout.Blocks[i-1].Block {
+			t.Errorf("block %d does not point to previous block", i)
+		}
+		if i%2 == 0 && i > 0 && b.Pointers[1] != layout.Blocks[i-2].Block {
+			t.Errorf("block %d does not skip two blocks", i)
+		}
+	}
+	if want := len(data) / int(config.BlockSize-8); len(layout.Blocks) < want {
+		t.Errorf("expected at least %d blocks; found %d", want, len(layout.Blocks))
+	}
+
+	// zeroing the directory's pair must not prevent inspecting the rest
+	dirPair := ins.Pairs[1].Pair
+	zero := make([]byte, config.BlockSize)
+	for _, b := range dirPair {
+		check(t, dev.EraseBlock(b))
+		check(t, dev.ProgramBlock(b, 0, zero))
+	}
+	ins, err = reader.Inspect(dev, rconfig)
+	check(t, err)
+	if ins.Err != reader.ErrCorrupt || len(ins.Pairs) != 2 || ins.Pairs[1].Active != -1 {
+		t.Errorf("expected corrupt second pair; got %+v", ins)
+	}
+}
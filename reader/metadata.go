@@ -0,0 +1,320 @@
+package reader
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+)
+
+// blockNull is the null block address
+const blockNull = 0xffffffff
+
+// Types of metadata tags, see docs/SPEC.md
+const (
+	typeName         = 0x000
+	typeReg          = 0x001
+	typeDir          = 0x002
+	typeSuperblock   = 0x0ff
+	typeStruct       = 0x200
+	typeDirStruct    = 0x200
+	typeInlineStruct = 0x201
+	typeCTZStruct    = 0x202
+	typeUserAttr     = 0x300
+	typeSplice       = 0x400
+	typeCreate       = 0x401
+	typeDelete       = 0x4ff
+	typeCRC          = 0x500
+	typeTail         = 0x600
+	typeSoftTail     = 0x600
+	typeHardTail     = 0x601
+	typeGlobals      = 0x700
+	typeMoveState    = 0x7ff
+)
+
+// tag is a 32-bit metadata tag as described in docs/SPEC.md
+type tag uint32
+
+func mktag(typ uint32, id uint32, size uint32) tag {
+	return tag(typ<<20 | id<<10 | size)
+}
+
+func (t tag) isValid() bool {
+	return t&0x80000000 == 0
+}
+
+func (t tag) isDelete() bool {
+	return int32(t<<22)>>22 == -1
+}
+
+func (t tag) type1() uint32 {
+	return uint32(t&0x70000000) >> 20
+}
+
+func (t tag) type3() uint32 {
+	return uint32(t&0x7ff00000) >> 20
+}
+
+func (t tag) chunk() uint8 {
+	return uint8((t & 0x0ff00000) >> 20)
+}
+
+func (t tag) splice() int8 {
+	return int8(t.chunk())
+}
+
+func (t tag) id() uint32 {
+	return uint32(t&0x000ffc00) >> 10
+}
+
+func (t tag) size() uint32 {
+	return uint32(t & 0x000003ff)
+}
+
+// dsize is the size of the tag and its data on disk
+func (t tag) dsize() uint32 {
+	if t.isDelete() {
+		return 4
+	}
+	return 4 + t.size()
+}
+
+// gstate is the global state, the xor-sum of the deltas stored in every
+// metadata pair
+type gstate struct {
+	tag  tag
+	pair [2]uint32
+}
+
+func (g *gstate) xor(data []byte) {
+	var buf [12]byte
+	copy(buf[:], data)
+	g.tag ^= tag(binary.LittleEndian.Uint32(buf[0:]))
+	g.pair[0] ^= binary.LittleEndian.Uint32(buf[4:])
+	g.pair[1] ^= binary.LittleEndian.Uint32(buf[8:])
+}
+
+func (g *gstate) orphans() uint32 {
+	return g.tag.size()
+}
+
+func (g *gstate) hasMove() bool {
+	return g.tag.type1() != 0
+}
+
+func (g *gstate) hasMoveHere(pair [2]uint32) bool {
+	return g.hasMove() && pairOverlaps(g.pair, pair)
+}
+
+func pairOverlaps(a, b [2]uint32) bool {
+	return a[0] == b[0] || a[1] == b[1] || a[0] == b[1] || a[1] == b[0]
+}
+
+func pairIsNull(pair [2]uint32) bool {
+	return pair[0] == blockNull || pair[1] == blockNull
+}
+
+// mdir is a fetched metadata pair; data holds the contents of pair[0], which
+// is the block with the most recent valid commit
+type mdir struct {
+	pair  [2]uint32
+	rev   uint32
+	off   uint32
+	etag  tag
+	count uint16
+	split bool
+	tail  [2]uint32
+	data  []byte
+}
+
+// fetch reads the metadata pair, choosing the block with the most recent
+// revision count that contains at least one valid commit, as in
+// lfs_dir_fetchmatch
+func (fsys *FS) fetch(pair [2]uint32) (*mdir, error) {
+	var blocks [2][]byte
+	var revs [2]uint32
+	for i := range pair {
+		data, err := fsys.readBlock(pair[i])
+		if err == ErrCorrupt {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		blocks[i] = data
+		revs[i] = binary.LittleEndian.Uint32(data)
+	}
+	r := 0
+	for i := range pair {
+		if blocks[i] != nil && int32(revs[i]-revs[(i+1)%2]) > 0 {
+			r = i
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		j := (r + i) % 2
+		if blocks[j] == nil {
+			continue
+		}
+		m := &mdir{
+			pair: [2]uint32{pair[j], pair[(j+1)%2]},
+			rev:  revs[j],
+			data: blocks[j],
+		}
+		if m.parse(nil); m.off > 0 {
+			return m, nil
+		}
+	}
+	return nil, ErrCorrupt
+}
+
+// tagVisitor is called by parse for each tag it finds in a metadata block, in
+// the order they were written. For CRC tags, crc is the checksum computed for
+// the commit, which may not match the stored one.
+type tagVisitor func(off uint32, t tag, data []byte, crc uint32)
+
+// parse scans the commits in m.data, updating m to reflect the most recent
+// valid commit
+func (m *mdir) parse(visit tagVisitor) {
+	blockSize := uint32(len(m.data))
+	off := uint32(0)
+	ptag := tag(blockNull)
+	count := uint16(0)
+	tail := [2]uint32{blockNull, blockNull}
+	split := false
+	crc := lfsCRC(blockNull, m.data[0:4])
+
+	for {
+		off += ptag.dsize()
+		if off+4 > blockSize {
+			return
+		}
+		crc = lfsCRC(crc, m.data[off:off+4])
+		t := tag(binary.BigEndian.Uint32(m.data[off:])) ^ ptag
+
+		// next commit not yet programmed or we're not in valid range
+		if !t.isValid() || off+t.dsize() > blockSize {
+			return
+		}
+		ptag = t
+		data := m.data[off+4 : off+t.dsize()]
+
+		if t.type1() == typeCRC {
+			if len(data) < 4 {
+				return
+			}
+			dcrc := binary.LittleEndian.Uint32(data)
+			if visit != nil {
+				visit(off, t, data, crc)
+			}
+			if crc != dcrc {
+				return
+			}
+			// reset the next bit if we need to
+			ptag ^= tag(t.chunk()&1) << 31
+
+			m.off = off + t.dsize()
+			m.etag = ptag
+			m.count = count
+			m.tail = tail
+			m.split = split
+			crc = blockNull
+			continue
+		}
+
+		crc = lfsCRC(crc, data)
+		if visit != nil {
+			visit(off, t, data, 0)
+		}
+
+		switch t.type1() {
+		case typeName:
+			if uint16(t.id()) >= count {
+				count = uint16(t.id()) + 1
+			}
+		case typeSplice:
+			count += uint16(t.splice())
+		case typeTail:
+			if len(data) < 8 {
+				return
+			}
+			split = t.chunk()&1 != 0
+			tail[0] = binary.LittleEndian.Uint32(data[0:])
+			tail[1] = binary.LittleEndian.Uint32(data[4:])
+		}
+	}
+}
+
+// get finds the most recent tag matching gtag under gmask, returning the tag
+// and its data, as in lfs_dir_getslice
+func (fsys *FS) get(m *mdir, gmask tag, gtag tag) (tag, []byte, error) {
+	off := m.off
+	ntag := m.etag
+	gdiff := tag(0)
+
+	if fsys.gstate.hasMoveHere(m.pair) && gtag.id() <= fsys.gstate.tag.id() {
+		// synthetic moves
+		gdiff -= mktag(0, 1, 0)
+	}
+
+	// iterate over dir block backwards (for faster lookups)
+	for off >= 4+ntag.dsize() {
+		off -= ntag.dsize()
+		t := ntag
+		ntag = (tag(binary.BigEndian.Uint32(m.data[off:])) ^ t) & 0x7fffffff
+
+		if gmask.id() != 0 && t.type1() == typeSplice && t.id() <= (gtag-gdiff).id() {
+			if t == mktag(typeCreate, 0, 0)|(mktag(0, 0x3ff, 0)&(gtag-gdiff)) {
+				// found where we were created
+				return 0, nil, errNoEntry
+			}
+			// move around splices
+			gdiff += tag(uint32(int32(t.splice())) << 10)
+		}
+
+		if gmask&t == gmask&(gtag-gdiff) {
+			if t.isDelete() {
+				return 0, nil, errNoEntry
+			}
+			return t + gdiff, m.data[off+4 : off+4+t.size()], nil
+		}
+	}
+	return 0, nil, errNoEntry
+}
+
+// lfsCRC computes the CRC-32 used by littlefs, which is the IEEE polynomial
+// without the final inversion
+func lfsCRC(crc uint32, data []byte) uint32 {
+	return ^crc32.Update(^crc, crc32.IEEETable, data)
+}
+
+// ctzIndex returns the index of the block in a CTZ skip-list that contains
+// the byte at offset off, along with the offset of that byte in the block, as
+// in lfs_ctz_index
+func ctzIndex(blockSize uint32, off uint32) (uint32, uint32) {
+	b := blockSize - 2*4
+	i := off / b
+	if i == 0 {
+		return 0, off
+	}
+	i = (off - 4*uint32(bits.OnesCount32(i-1)+2)) / b
+	return i, off - b*i - 4*uint32(bits.OnesCount32(i))
+}
+
+// ctzFind finds the block and offset holding the byte at pos in the CTZ
+// skip-list of a file, as in lfs_ctz_find
+func (fsys *FS) ctzFind(head uint32, size uint32, pos uint32) (uint32, uint32, error) {
+	current, _ := ctzIndex(fsys.blockSize, size-1)
+	target, off := ctzIndex(fsys.blockSize, pos)
+	var ptr [4]byte
+	for current > target {
+		skip := uint32(32-bits.LeadingZeros32(current-target)) - 1
+		if tz := uint32(bits.TrailingZeros32(current)); tz < skip {
+			skip = tz
+		}
+		if err := fsys.readAt(head, 4*skip, ptr[:]); err != nil {
+			return 0, 0, err
+		}
+		head = binary.LittleEndian.Uint32(ptr[:])
+		current -= 1 << skip
+	}
+	return head, off, nil
+}
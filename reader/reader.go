@@ -0,0 +1,583 @@
+// Package reader decodes littlefs images in pure Go, without cgo.
+//
+// It follows the on-disk format described in docs/SPEC.md and provides
+// read-only access to the files, directories and custom attributes of an
+// image through the io/fs interfaces. It reads images written by the lfs
+// package, but never writes to them; in particular, orphans and interrupted
+// renames are resolved in memory the same way littlefs does when mounting,
+// rather than by fixing the image.
+package reader
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+var (
+	// ErrCorrupt is returned when the image contains invalid metadata
+	ErrCorrupt = errors.New("littlefs: Corrupted")
+
+	// ErrNoAttr is returned by Getattr if the attribute does not exist
+	ErrNoAttr = errors.New("littlefs: No data/attr available")
+
+	errNoEntry     = fs.ErrNotExist
+	errNotDir      = errors.New("littlefs: Entry is not a dir")
+	errIsDir       = errors.New("littlefs: Entry is a dir")
+	errSymlinkLoop = errors.New("littlefs: too many levels of symbolic links")
+)
+
+// Symbolic links created by lfs.Symlink are files holding the path they point
+// to, tagged with a custom attribute of type lfs.AttrSymlink
+const (
+	attrSymlink    = 0xe5
+	symlinkVersion = 1
+	maxSymlinks    = 40
+)
+
+// Permissions set with lfs.Chmod are kept in a custom attribute of type
+// lfs.AttrPermissions, holding the mode, uid and gid as little-endian uint32s
+const (
+	attrPermissions = 0xe6
+	defaultMode     = 0777
+)
+
+// BlockDevice is the read-only subset of the lfs.BlockDevice interface that
+// is needed to decode an image
+type BlockDevice interface {
+	ReadBlock(block uint32, offset uint32, buf []byte) error
+}
+
+// Config describes the geometry of an image
+type Config struct {
+	// BlockSize is the size of each block in bytes; it is required, since the
+	// superblock can not be found without it
+	BlockSize uint32
+
+	// BlockCount is the number of blocks in the image; if zero, it is taken
+	// from the superblock
+	BlockCount uint32
+}
+
+// Superblock holds the configuration recorded in the image when it was
+// formatted
+type Superblock struct {
+	Version    uint32
+	BlockSize  uint32
+	BlockCount uint32
+	NameMax    uint32
+	FileMax    uint32
+	AttrMax    uint32
+}
+
+// FS is a read-only littlefs image; it implements fs.FS, fs.StatFS,
+// fs.ReadDirFS and fs.ReadFileFS, and the ReadLink and Lstat methods of
+// fs.ReadLinkFS. Symbolic links are followed except by those two methods and
+// Getattr, where they are the last element of a path.
+type FS struct {
+	dev        BlockDevice
+	blockSize  uint32
+	blockCount uint32
+	superblock Superblock
+	root       [2]uint32
+	gstate     gstate
+}
+
+// Mount reads the superblock and global state from dev, as lfs_mount does
+func Mount(dev BlockDevice, config Config) (*FS, error) {
+	if config.BlockSize < 4 {
+		return nil, errors.New("littlefs: invalid block size")
+	}
+	fsys := &FS{
+		dev:        dev,
+		blockSize:  config.BlockSize,
+		blockCount: config.BlockCount,
+		root:       [2]uint32{blockNull, blockNull},
+	}
+
+	// scan directory blocks for superblock and any global updates
+	var gpending gstate
+	seen := map[uint32]bool{}
+	for tail := [2]uint32{0, 1}; !pairIsNull(tail); {
+		if seen[tail[0]] {
+			return nil, ErrCorrupt // cycle in the metadata list
+		}
+		seen[tail[0]] = true
+		m, err := fsys.fetch(tail)
+		if err != nil {
+			return nil, err
+		}
+
+		// has superblock?
+		_, name, err := fsys.get(m, mktag(0x7ff, 0x3ff, 0), mktag(typeSuperblock, 0, 8))
+		if err == nil && string(name) == "littlefs" {
+			fsys.root = m.pair
+			_, data, err := fsys.get(m, mktag(0x7ff, 0x3ff, 0), mktag(typeInlineStruct, 0, 24))
+			if err != nil {
+				return nil, err
+			}
+			var buf [24]byte
+			copy(buf[:], data)
+			fsys.superblock = Superblock{
+				Version:    binary.LittleEndian.Uint32(buf[0:]),
+				BlockSize:  binary.LittleEndian.Uint32(buf[4:]),
+				BlockCount: binary.LittleEndian.Uint32(buf[8:]),
+				NameMax:    binary.LittleEndian.Uint32(buf[12:]),
+				FileMax:    binary.LittleEndian.Uint32(buf[16:]),
+				AttrMax:    binary.LittleEndian.Uint32(buf[20:]),
+			}
+			if major := fsys.superblock.Version >> 16; major != 2 {
+				return nil, errors.New("littlefs: unsupported version")
+			}
+			if fsys.blockCount == 0 {
+				fsys.blockCount = fsys.superblock.BlockCount
+			}
+		} else if err != nil && err != errNoEntry {
+			return nil, err
+		}
+
+		// has gstate?
+		_, data, err := fsys.get(m, mktag(0x7ff, 0, 0), mktag(typeMoveState, 0, 12))
+		if err == nil {
+			gpending.xor(data)
+		} else if err != errNoEntry {
+			return nil, err
+		}
+
+		tail = m.tail
+	}
+
+	// found superblock?
+	if pairIsNull(fsys.root) {
+		return nil, ErrCorrupt
+	}
+	if !gpending.tag.isValid() {
+		gpending.tag++
+	}
+	fsys.gstate = gpending
+	return fsys, nil
+}
+
+// MountImage mounts an image held in r, such as a file containing a dump of
+// a block device
+func MountImage(r io.ReaderAt, config Config) (*FS, error) {
+	return Mount(&imageDevice{r: r, blockSize: config.BlockSize}, config)
+}
+
+type imageDevice struct {
+	r         io.ReaderAt
+	blockSize uint32
+}
+
+func (d *imageDevice) ReadBlock(block uint32, offset uint32, buf []byte) error {
+	_, err := d.r.ReadAt(buf, int64(block)*int64(d.blockSize)+int64(offset))
+	return err
+}
+
+// Superblock returns the configuration recorded in the image
+func (fsys *FS) Superblock() Superblock {
+	return fsys.superblock
+}
+
+// readBlock reads the whole of block
+func (fsys *FS) readBlock(block uint32) ([]byte, error) {
+	buf := make([]byte, fsys.blockSize)
+	if err := fsys.readAt(block, 0, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readAt reads from block at offset, failing with ErrCorrupt if the read is
+// out of bounds, as lfs_bd_read does
+func (fsys *FS) readAt(block uint32, offset uint32, buf []byte) error {
+	if (fsys.blockCount != 0 && block >= fsys.blockCount) ||
+		uint64(offset)+uint64(len(buf)) > uint64(fsys.blockSize) {
+		return ErrCorrupt
+	}
+	return fsys.dev.ReadBlock(block, offset, buf)
+}
+
+// entry is a file or directory found in a metadata pair
+type entry struct {
+	m    *mdir
+	id   uint32
+	typ  uint32
+	name string
+}
+
+// isRoot reports whether the entry is the root directory, which does not have
+// an entry of its own
+func (e *entry) isRoot() bool {
+	return e.id == 0x3ff
+}
+
+// find looks up the entry for name, which must be a valid io/fs path,
+// following symbolic links; one which is the last element of the path is only
+// followed if follow is set
+func (fsys *FS) find(name string, follow bool) (*entry, error) {
+	// the directories from the root down to the last element found, which
+	// ".." goes back up
+	dirs := []*entry{{id: 0x3ff, typ: typeDir, name: "."}}
+	rest := strings.Split(name, "/")
+	links := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			if len(dirs) > 1 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			continue
+		}
+		e := dirs[len(dirs)-1]
+		if e.typ != typeDir {
+			return nil, errNotDir
+		}
+		pair, err := fsys.dirPair(e)
+		if err != nil {
+			return nil, err
+		}
+		var found *entry
+		err = fsys.readdir(pair, func(child *entry) bool {
+			if child.name == elem {
+				found = child
+				return false
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if found == nil {
+			return nil, errNoEntry
+		}
+		if found.typ == typeReg && (len(rest) > 0 || follow) {
+			target, ok, err := fsys.readlink(found)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				if links++; links > maxSymlinks {
+					return nil, errSymlinkLoop
+				}
+				if strings.HasPrefix(target, "/") {
+					dirs = dirs[:1]
+				}
+				rest = append(strings.Split(target, "/"), rest...)
+				continue
+			}
+		}
+		dirs = append(dirs, found)
+	}
+	return dirs[len(dirs)-1], nil
+}
+
+// isSymlink reports whether the file e is a symbolic link
+func (fsys *FS) isSymlink(e *entry) (bool, error) {
+	if e.typ != typeReg {
+		return false, nil
+	}
+	_, data, err := fsys.get(e.m, mktag(0x7ff, 0x3ff, 0), mktag(typeUserAttr+attrSymlink, e.id, 0))
+	if err == errNoEntry {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if len(data) != 1 || data[0] != symlinkVersion {
+		return false, ErrCorrupt
+	}
+	return true, nil
+}
+
+// readlink returns the target of the file e if it is a symbolic link
+func (fsys *FS) readlink(e *entry) (string, bool, error) {
+	if ok, err := fsys.isSymlink(e); !ok || err != nil {
+		return "", false, err
+	}
+	info, err := fsys.stat(e)
+	if err != nil {
+		return "", false, err
+	}
+	f, err := fsys.openFile(e, info)
+	if err != nil {
+		return "", false, err
+	}
+	target, err := io.ReadAll(f)
+	if err != nil {
+		return "", false, err
+	}
+	return string(target), true, nil
+}
+
+// dirPair returns the first metadata pair of the directory e
+func (fsys *FS) dirPair(e *entry) ([2]uint32, error) {
+	if e.isRoot() {
+		return fsys.root, nil
+	}
+	_, data, err := fsys.get(e.m, mktag(0x700, 0x3ff, 0), mktag(typeStruct, e.id, 8))
+	if err != nil {
+		return [2]uint32{}, err
+	}
+	if len(data) < 8 {
+		return [2]uint32{}, ErrCorrupt
+	}
+	return [2]uint32{
+		binary.LittleEndian.Uint32(data[0:]),
+		binary.LittleEndian.Uint32(data[4:]),
+	}, nil
+}
+
+// readdir calls fn for each entry in the directory starting at pair, in the
+// order they are stored, until fn returns false; as in lfs_dir_read
+func (fsys *FS) readdir(pair [2]uint32, fn func(e *entry) bool) error {
+	seen := map[uint32]bool{}
+	for {
+		if seen[pair[0]] {
+			return ErrCorrupt // cycle in the directory
+		}
+		seen[pair[0]] = true
+		m, err := fsys.fetch(pair)
+		if err != nil {
+			return err
+		}
+		for id := uint32(0); id < uint32(m.count); id++ {
+			t, name, err := fsys.get(m, mktag(0x780, 0x3ff, 0), mktag(typeName, id, 0))
+			if err == errNoEntry {
+				continue
+			} else if err != nil {
+				return err
+			}
+			switch t.type3() {
+			case typeReg, typeDir:
+			default:
+				continue // superblock
+			}
+			if !fn(&entry{m: m, id: id, typ: t.type3(), name: string(name)}) {
+				return nil
+			}
+		}
+		if !m.split {
+			return nil
+		}
+		pair = m.tail
+	}
+}
+
+// fileStruct returns the location of the contents of the file e; for inline
+// files data holds the contents, otherwise head and size describe the CTZ
+// skip-list
+func (fsys *FS) fileStruct(e *entry) (data []byte, head uint32, size uint32, err error) {
+	t, data, err := fsys.get(e.m, mktag(0x700, 0x3ff, 0), mktag(typeStruct, e.id, 8))
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	switch t.type3() {
+	case typeInlineStruct:
+		return data, blockNull, uint32(len(data)), nil
+	case typeCTZStruct:
+		if len(data) < 8 {
+			return nil, 0, 0, ErrCorrupt
+		}
+		return nil, binary.LittleEndian.Uint32(data[0:]), binary.LittleEndian.Uint32(data[4:]), nil
+	default:
+		return nil, 0, 0, ErrCorrupt
+	}
+}
+
+func (fsys *FS) stat(e *entry) (*fileInfo, error) {
+	info := &fileInfo{name: e.name, dir: e.typ == typeDir}
+	var err error
+	if info.mode, err = fsys.mode(e); err != nil {
+		return nil, err
+	}
+	if !info.dir {
+		_, _, size, err := fsys.fileStruct(e)
+		if err != nil {
+			return nil, err
+		}
+		info.size = int64(size)
+		if info.link, err = fsys.isSymlink(e); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// mode returns the permission bits of e, as in st_mode
+func (fsys *FS) mode(e *entry) (uint32, error) {
+	m, id := e.m, e.id
+	if e.isRoot() {
+		var err error
+		if m, err = fsys.fetch(fsys.root); err != nil {
+			return 0, err
+		}
+		id = 0
+	}
+	_, data, err := fsys.get(m, mktag(0x7ff, 0x3ff, 0), mktag(typeUserAttr+attrPermissions, id, 0))
+	if err == errNoEntry {
+		return defaultMode, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(data) != 12 {
+		return 0, ErrCorrupt
+	}
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// openFile opens the regular file e described by info
+func (fsys *FS) openFile(e *entry, info *fileInfo) (*File, error) {
+	f := &File{fs: fsys, info: info}
+	var err error
+	if f.inline, f.head, _, err = fsys.fileStruct(e); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fsys *FS) lookup(op string, name string, follow bool) (*entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	e, err := fsys.find(name, follow)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return e, nil
+}
+
+// Open opens the named file or directory for reading
+func (fsys *FS) Open(name string) (fs.File, error) {
+	e, err := fsys.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.stat(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if name != "." {
+		// the name of a link rather than of the file it points to
+		info.name = path.Base(name)
+	}
+	if info.dir {
+		return &dir{fs: fsys, e: e, info: info}, nil
+	}
+	f, err := fsys.openFile(e, info)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f, nil
+}
+
+// Stat returns a FileInfo describing the named file or directory
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	return fsys.statPath("stat", name, true)
+}
+
+// Lstat is like Stat, except that if the named file is a symbolic link, it
+// describes the link rather than the file it points to
+func (fsys *FS) Lstat(name string) (fs.FileInfo, error) {
+	return fsys.statPath("lstat", name, false)
+}
+
+func (fsys *FS) statPath(op string, name string, follow bool) (fs.FileInfo, error) {
+	e, err := fsys.lookup(op, name, follow)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fsys.stat(e)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if name != "." {
+		// the name of a link rather than of the file it points to
+		info.name = path.Base(name)
+	}
+	return info, nil
+}
+
+// ReadLink returns the path the named symbolic link points to
+func (fsys *FS) ReadLink(name string) (string, error) {
+	e, err := fsys.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	target, ok, err := fsys.readlink(e)
+	if err == nil && !ok {
+		err = fs.ErrInvalid
+	}
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	return target, nil
+}
+
+// ReadDir reads the named directory, returning its entries sorted by name
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d, ok := f.(*dir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errNotDir}
+	}
+	entries, err := d.ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, err
+}
+
+// ReadFile reads the named file and returns its contents
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	buf := make([]byte, info.Size())
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return buf, nil
+}
+
+// Getattr returns the custom attribute of type attr attached to the named
+// file or directory, or ErrNoAttr if there is no such attribute
+func (fsys *FS) Getattr(name string, attr uint8) ([]byte, error) {
+	e, err := fsys.lookup("getattr", name, false)
+	if err != nil {
+		return nil, err
+	}
+	m, id := e.m, e.id
+	if e.isRoot() {
+		// attributes on the root are stored with the superblock
+		if m, err = fsys.fetch(fsys.root); err != nil {
+			return nil, &fs.PathError{Op: "getattr", Path: name, Err: err}
+		}
+		id = 0
+	}
+	_, data, err := fsys.get(m, mktag(0x7ff, 0x3ff, 0), mktag(typeUserAttr+uint32(attr), id, 0))
+	if err == errNoEntry {
+		return nil, ErrNoAttr
+	} else if err != nil {
+		return nil, &fs.PathError{Op: "getattr", Path: name, Err: err}
+	}
+	return append([]byte(nil), data...), nil
+}
@@ -0,0 +1,301 @@
+package reader_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"testing"
+	"testing/fstest"
+
+	lfs "github.com/bgould/go-littlefs"
+	"github.com/bgould/go-littlefs/reader"
+)
+
+var config = lfs.Config{
+	ReadSize:      16,
+	ProgSize:      16,
+	BlockSize:     512,
+	BlockCount:    1024,
+	CacheSize:     64,
+	LookaheadSize: 16,
+	BlockCycles:   500,
+}
+
+func TestReader(t *testing.T) {
+	dev := lfs.NewMemoryDevice(config)
+	fs := lfs.New(config, dev)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+
+	rnd := rand.New(rand.NewSource(1))
+	contents := map[string][]byte{}
+	write := func(name string, size int) {
+		buf := make([]byte, size)
+		rnd.Read(buf)
+		f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		check(t, err)
+		for i := 0; i < size; i += 100 {
+			end := i + 100
+			if end > size {
+				end = size
+			}
+			_, err := f.Write(buf[i:end])
+			check(t, err)
+		}
+		check(t, f.Close())
+		contents[name] = buf
+	}
+
+	check(t, fs.Mkdir("etc"))
+	check(t, fs.Mkdir("etc/network"))
+	check(t, fs.Mkdir("var"))
+	check(t, fs.Mkdir("var/log"))
+	check(t, fs.Mkdir("empty"))
+	write("etc/hostname", 12)
+	write("etc/network/interfaces", 60)
+	write("etc/empty", 0)
+	write("var/log/messages", 20000)
+	write("var/log/boot", 3000)
+	write("firmware.bin", 150000)
+	for i := 0; i < 60; i++ {
+		// enough entries to split the directory across metadata pairs
+		write(fmt.Sprintf("var/log/rotated.%02d", i), rnd.Intn(2000))
+	}
+	// churn to exercise deletes, renames and superseded commits
+	for i := 0; i < 60; i += 3 {
+		name := fmt.Sprintf("var/log/rotated.%02d", i)
+		check(t, fs.Remove(name))
+		delete(contents, name)
+	}
+	check(t, fs.Rename("var/log/boot", "var/log/boot.old"))
+	contents["var/log/boot.old"] = contents["var/log/boot"]
+	delete(contents, "var/log/boot")
+	write("var/log/messages", 25000)
+	check(t, fs.Setattr("etc/hostname", 0x74, []byte("timestamp")))
+	check(t, fs.Setattr("etc", 0x01, []byte{1, 2, 3}))
+	check(t, fs.Setattr("/", 0x02, []byte("root attr")))
+	check(t, fs.Setattr("firmware.bin", 0x03, []byte("to be removed")))
+	check(t, fs.Removeattr("firmware.bin", 0x03))
+	check(t, fs.Symlink("network/interfaces", "etc/interfaces"))
+	check(t, fs.Symlink("/var/log", "logs"))
+	check(t, fs.Chmod("etc/hostname", 0640|os.ModeSetgid))
+	check(t, fs.Chmod("var/log", 01755))
+	check(t, fs.Chown("var/log", 0, 4))
+	check(t, fs.Unmount())
+
+	r, err := reader.Mount(dev, reader.Config{BlockSize: config.BlockSize})
+	check(t, err)
+	if sb := r.Superblock(); sb.BlockSize != config.BlockSize || sb.BlockCount != config.BlockCount {
+		t.Fatalf("unexpected superblock: %+v", sb)
+	}
+
+	t.Run("Contents", func(t *testing.T) {
+		for name, want := range contents {
+			got, err := r.ReadFile(name)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s: contents differ", name)
+			}
+		}
+	})
+
+	t.Run("Differential", func(t *testing.T) {
+		check(t, fs.Mount())
+		defer fs.Unmount()
+		compareDir(t, fs, r, ".")
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		for _, test := range []struct {
+			name string
+			attr uint8
+			want string
+		}{
+			{"etc/hostname", 0x74, "timestamp"},
+			{"etc", 0x01, "\x01\x02\x03"},
+			{".", 0x02, "root attr"},
+		} {
+			got, err := r.Getattr(test.name, test.attr)
+			check(t, err)
+			if string(got) != test.want {
+				t.Errorf("%s: expected attribute %q; was %q", test.name, test.want, got)
+			}
+		}
+		if _, err := r.Getattr("firmware.bin", 0x03); err != reader.ErrNoAttr {
+			t.Errorf("expected ErrNoAttr for removed attribute; was %v", err)
+		}
+	})
+
+	t.Run("Symlinks", func(t *testing.T) {
+		for name, want := range map[string]string{
+			"etc/interfaces": "etc/network/interfaces",
+			"logs/messages":  "var/log/messages",
+		} {
+			got, err := r.ReadFile(name)
+			check(t, err)
+			if !bytes.Equal(got, contents[want]) {
+				t.Errorf("%s: expected the contents of %s", name, want)
+			}
+		}
+		info, err := r.Stat("logs")
+		check(t, err)
+		if info.Name() != "logs" || !info.IsDir() {
+			t.Errorf("expected a link to a directory to be a directory named logs")
+		}
+		info, err = r.Lstat("logs")
+		check(t, err)
+		if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("expected Lstat to describe the link; mode was %v", info.Mode())
+		}
+		if target, err := r.ReadLink("etc/interfaces"); err != nil || target != "network/interfaces" {
+			t.Errorf("expected the target of the link; was %q, %v", target, err)
+		}
+		if _, err := r.ReadLink("etc/hostname"); err == nil {
+			t.Errorf("expected an error reading a file which is not a link")
+		}
+	})
+
+	t.Run("Seek", func(t *testing.T) {
+		f, err := r.Open("firmware.bin")
+		check(t, err)
+		defer f.Close()
+		rs := f.(io.ReadSeeker)
+		want := contents["firmware.bin"]
+		for i := 0; i < 100; i++ {
+			off := rnd.Intn(len(want))
+			_, err := rs.Seek(int64(off), io.SeekStart)
+			check(t, err)
+			buf := make([]byte, 700)
+			n, err := io.ReadFull(rs, buf)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], want[off:off+n]) {
+				t.Fatalf("contents differ at offset %d", off)
+			}
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := r.Open("nope"); !os.IsNotExist(err) {
+			t.Errorf("expected not exist error; was %v", err)
+		}
+		if _, err := r.Open("etc/hostname/nope"); err == nil {
+			t.Errorf("expected error opening path through a file")
+		}
+		if _, err := r.Open("/etc"); err == nil {
+			t.Errorf("expected error for invalid path")
+		}
+	})
+
+	t.Run("TestFS", func(t *testing.T) {
+		var expected []string
+		for name := range contents {
+			expected = append(expected, name)
+		}
+		check(t, fstest.TestFS(r, expected...))
+	})
+}
+
+func TestMountImage(t *testing.T) {
+	dev := lfs.NewMemoryDevice(config)
+	fs := lfs.New(config, dev)
+	check(t, fs.Format())
+	check(t, fs.Mount())
+	f, err := fs.OpenFile("hello", os.O_WRONLY|os.O_CREATE)
+	check(t, err)
+	_, err = f.Write([]byte("Hello World!"))
+	check(t, err)
+	check(t, f.Close())
+	check(t, fs.Unmount())
+
+	image := make([]byte, config.BlockSize*config.BlockCount)
+	for i := uint32(0); i < config.BlockCount; i++ {
+		check(t, dev.ReadBlock(i, 0, image[i*config.BlockSize:(i+1)*config.BlockSize]))
+	}
+	r, err := reader.MountImage(bytes.NewReader(image), reader.Config{BlockSize: config.BlockSize})
+	check(t, err)
+	got, err := r.ReadFile("hello")
+	check(t, err)
+	if string(got) != "Hello World!" {
+		t.Fatalf("unexpected contents: %q", got)
+	}
+
+	if _, err := reader.MountImage(bytes.NewReader(make([]byte, len(image))), reader.Config{BlockSize: config.BlockSize}); err != reader.ErrCorrupt {
+		t.Fatalf("expected ErrCorrupt for blank image; was %v", err)
+	}
+}
+
+// compareDir checks that the directory name reads the same through the lfs
+// package and the reader, recursively
+func compareDir(t *testing.T, fs *lfs.LFS, r *reader.FS, name string) {
+	lname := "/" + name
+	if name == "." {
+		lname = "/"
+	}
+	dir, err := fs.Open(lname)
+	check(t, err)
+	infos, err := dir.Readdir(0)
+	check(t, err)
+	check(t, dir.Close())
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	entries, err := r.ReadDir(name)
+	check(t, err)
+	if len(entries) != len(infos) {
+		t.Fatalf("%s: expected %d entries; reader found %d", name, len(infos), len(entries))
+	}
+	for i, info := range infos {
+		entry := entries[i]
+		child := path.Join(name, info.Name())
+		if entry.Name() != info.Name() || entry.IsDir() != info.IsDir() {
+			t.Fatalf("%s: expected %s; reader found %s", name, info.Name(), entry.Name())
+		}
+		rinfo, err := entry.Info()
+		check(t, err)
+		if rinfo.Mode() != info.Mode() {
+			t.Fatalf("%s: expected mode %v; reader found %v", child, info.Mode(), rinfo.Mode())
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			want, err := fs.Readlink("/" + child)
+			check(t, err)
+			if got, err := r.ReadLink(child); err != nil || got != want || rinfo.Mode() != info.Mode() {
+				t.Fatalf("%s: expected a link to %s; reader found %q, %v", child, want, got, rinfo.Mode())
+			}
+			continue
+		}
+		if info.IsDir() {
+			compareDir(t, fs, r, child)
+			continue
+		}
+		if rinfo.Size() != info.Size() {
+			t.Fatalf("%s: expected size %d; reader found %d", child, info.Size(), rinfo.Size())
+		}
+		want := make([]byte, info.Size())
+		if len(want) > 0 {
+			f, err := fs.Open("/" + child)
+			check(t, err)
+			_, err = io.ReadFull(f, want)
+			check(t, err)
+			check(t, f.Close())
+		}
+		got, err := r.ReadFile(child)
+		check(t, err)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("%s: contents differ", child)
+		}
+	}
+}
+
+func check(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
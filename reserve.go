@@ -0,0 +1,277 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"path"
+	"sort"
+)
+
+// The reservations made with Reserve are kept in the AttrReservation
+// attribute of the root directory, as a list of
+//
+//	size uint64  bytes reserved
+//	len  uint16  length of the path
+//	path [len]byte
+//
+// so that they still hold after the filesystem is mounted again.
+
+// attrMax is the largest size of an attribute, LFS_ATTR_MAX
+const attrMax = 1022
+
+// reserveSlack is the number of blocks reserved for a file on top of those
+// for its contents: one for the last block, which is copied when it is
+// written to, two for the metadata pair its directory is split into if
+// creating it does not fit, and one more as the allocator of littlefs can
+// give up with a block still free
+const reserveSlack = 4
+
+// Reserve sets aside room for the named file to grow to size bytes. The room
+// reserved for all files makes up a budget which ordinary writes to other
+// files cannot use: they fail with ErrNoSpace once they would leave less free
+// space than is still reserved. Writes to a file with a reservation can use
+// its own reservation, as well as any space not reserved for another file,
+// and writes through a handle made privileged with SetPrivileged can use the
+// whole budget.
+//
+// The file does not need to exist, and the reservation stays with the path
+// when the file is removed or renamed, so that a file can be written from
+// scratch again; while it is being rewritten, its old contents take space as
+// well until the new ones are synced. A size of 0 cancels the reservation.
+// Reserve fails with ErrNoSpace if there is not enough free space for all of
+// the reservations.
+//
+// Space is counted in blocks, as by Size, and only for the contents of
+// files; the metadata written by creating files or directories is not
+// accounted for.
+func (l *LFS) Reserve(name string, size int64) error {
+	if l.readonly {
+		return ErrReadOnly
+	}
+	if size < 0 {
+		return ErrInvalidParam
+	}
+	resolved, err := l.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	key := absPath(resolved)
+	old, ok := l.reservations[key]
+	restore := func() {
+		if ok {
+			l.reservations[key] = old
+		} else {
+			delete(l.reservations, key)
+		}
+	}
+	if size == 0 {
+		delete(l.reservations, key)
+	} else {
+		if l.reservations == nil {
+			l.reservations = map[string]int64{}
+		}
+		l.reservations[key] = size
+		free, err := l.freeBlocks()
+		if err != nil {
+			restore()
+			return err
+		}
+		reserved, err := l.reservedBlocks("")
+		if err != nil {
+			restore()
+			return err
+		}
+		if free < reserved {
+			restore()
+			return ErrNoSpace
+		}
+	}
+	if err := l.storeReservations(); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+// Allocate reserves room for f to grow to size bytes, as Reserve does for the
+// path of f; unlike fallocate(2), it does not change the size of the file
+func (f *File) Allocate(size int64) error {
+	if !f.writable() {
+		return ErrBadFileNum
+	}
+	return f.lfs.Reserve(f.path, size)
+}
+
+// SetPrivileged lets writes through f use the space reserved with Reserve for
+// any file, as well as all the rest, or stops it from doing so
+func (f *File) SetPrivileged(privileged bool) error {
+	if !f.writable() {
+		return ErrBadFileNum
+	}
+	f.privileged = privileged
+	return nil
+}
+
+// absPath returns the resolved path name as an absolute path, which is how
+// files are keyed in reservations
+func absPath(name string) string {
+	return path.Clean("/" + name)
+}
+
+// loadReservations reads the reservations made with Reserve
+func (l *LFS) loadReservations() error {
+	l.reservations = nil
+	buf := make([]byte, attrMax)
+	n, err := l.Getattr("/", AttrReservation, buf)
+	if err == ErrNoAttr {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	buf = buf[:n]
+	l.reservations = map[string]int64{}
+	for len(buf) > 0 {
+		if len(buf) < 10 {
+			return ErrCorrupt
+		}
+		size := int64(binary.LittleEndian.Uint64(buf))
+		n := int(binary.LittleEndian.Uint16(buf[8:]))
+		if len(buf) < 10+n {
+			return ErrCorrupt
+		}
+		l.reservations[string(buf[10:10+n])] = size
+		buf = buf[10+n:]
+	}
+	return nil
+}
+
+// storeReservations writes the reservations made with Reserve
+func (l *LFS) storeReservations() error {
+	if len(l.reservations) == 0 {
+		err := l.Removeattr("/", AttrReservation)
+		if err == ErrNoAttr {
+			err = nil
+		}
+		return err
+	}
+	var names []string
+	for name := range l.reservations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	for _, name := range names {
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(l.reservations[name]))
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(name)))
+		buf = append(buf, name...)
+	}
+	return l.Setattr("/", AttrReservation, buf)
+}
+
+// freeBlocks returns the number of blocks not in use
+func (l *LFS) freeBlocks() (int64, error) {
+	used, err := l.Size()
+	if err != nil {
+		return 0, err
+	}
+	return int64(l.cfg.block_count) - int64(used), nil
+}
+
+// reservedBlocks returns the number of blocks which are reserved for files
+// other than the one with the key except, but not used by them yet
+func (l *LFS) reservedBlocks(except string) (int64, error) {
+	bs := int64(l.cfg.block_size)
+	var reserved int64
+	for name, size := range l.reservations {
+		if name == except {
+			continue
+		}
+		var current int64
+		info, err := l.stat(name, name)
+		if err == nil {
+			current = info.Size()
+		} else if err != ErrNoEntry {
+			return 0, err
+		}
+		if current < size {
+			reserved += fileBlocks(size, bs) + reserveSlack - fileBlocks(current, bs)
+		}
+	}
+	return reserved, nil
+}
+
+// checkSpace checks that writing n bytes to f leaves enough free space for
+// the reservations of other files. Only writes which make littlefs allocate
+// blocks are checked, as counting the free blocks takes a traversal.
+func (f *File) checkSpace(n int64) error {
+	l := f.lfs
+	if len(l.reservations) == 0 || f.privileged || n == 0 {
+		return nil
+	}
+	size, err := f.Size()
+	if err != nil {
+		return err
+	}
+	pos := size
+	if !f.appending() {
+		if pos, err = f.Tell(); err != nil {
+			return err
+		}
+	}
+	if !f.allocates(pos, n) {
+		return nil
+	}
+	return f.checkGrowth(pos, pos+n, size)
+}
+
+// checkTruncate checks that truncating f to size bytes leaves enough free
+// space for the reservations of other files
+func (f *File) checkTruncate(size int64) error {
+	if len(f.lfs.reservations) == 0 || f.privileged {
+		return nil
+	}
+	current, err := f.Size()
+	if err != nil || size <= current {
+		return err
+	}
+	return f.checkGrowth(current, size, current)
+}
+
+// checkGrowth checks that writing to f from pos to end, when it holds size
+// bytes, leaves enough free space for the reservations of other files
+func (f *File) checkGrowth(pos int64, end int64, size int64) error {
+	l := f.lfs
+	bs := int64(l.cfg.block_size)
+	if end < size {
+		end = size
+	}
+	// the blocks from the one holding pos onwards are written anew
+	need := fileBlocks(end, bs) - fileBlocks(pos+1, bs) + 1
+	free, err := l.freeBlocks()
+	if err != nil {
+		return err
+	}
+	reserved, err := l.reservedBlocks(absPath(f.path))
+	if err != nil {
+		return err
+	}
+	if free-need < reserved {
+		return ErrNoSpace
+	}
+	return nil
+}
+
+// fileBlocks returns the number of blocks taken by the contents of a file of
+// size bytes, including the pointers littlefs keeps at the start of every
+// block but the first
+func fileBlocks(size int64, bs int64) int64 {
+	var n int64
+	for capacity := int64(0); capacity < size; n++ {
+		capacity += bs
+		if n > 0 {
+			capacity -= 4 * int64(bits.TrailingZeros64(uint64(n))+1)
+		}
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package lfs
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestReserve(t *testing.T) {
+	config := defaultConfig
+	config.BlockCount = 64
+	bs := int(config.BlockSize)
+	dump := bytes.Repeat([]byte("dump"), 10*bs/4)
+
+	fill := func(t *testing.T, fs *LFS) {
+		for i := 0; ; i++ {
+			err := fs.WriteFile(fmt.Sprintf("log.%d", i), make([]byte, 2*bs))
+			if err == ErrNoSpace {
+				return
+			}
+			if err != nil {
+				t.Fatalf("write %d: %v", i, err)
+			}
+		}
+	}
+
+	t.Run("Reserve", func(t *testing.T) {
+		fs, bd, _ := createTestFS(t, config)
+		check(t, fs.Reserve("crash.dump", int64(len(dump))))
+		fill(t, fs)
+		free, err := fs.freeBlocks()
+		check(t, err)
+		if free < fileBlocks(int64(len(dump)), int64(bs)) {
+			t.Fatalf("expected the reserved blocks to be free; %d are", free)
+		}
+		if err := fs.Reserve("other", int64(len(dump))); err != ErrNoSpace {
+			t.Errorf("expected ErrNoSpace; got %v", err)
+		}
+
+		// the reservation is kept when the filesystem is mounted again
+		check(t, fs.Unmount())
+		fs = New(config, bd)
+		check(t, fs.Mount())
+		defer fs.Unmount()
+		f, err := fs.OpenFile("log.0", os.O_WRONLY)
+		check(t, err)
+		if err := f.Truncate(uint32(20 * bs)); err != ErrNoSpace {
+			t.Errorf("expected ErrNoSpace; got %v", err)
+		}
+		check(t, f.Close())
+		check(t, fs.WriteFile("crash.dump", dump))
+		if data, err := fs.ReadFile("crash.dump"); err != nil || !bytes.Equal(data, dump) {
+			t.Errorf("expected the whole dump to be written; got %d bytes, %v", len(data), err)
+		}
+
+		// cancelling the reservation makes the space left usable
+		check(t, fs.Remove("crash.dump"))
+		check(t, fs.Reserve("crash.dump", 0))
+		check(t, fs.WriteFile("log.new", make([]byte, 2*bs)))
+	})
+
+	t.Run("Allocate", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		f, err := fs.OpenFile("crash.dump", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		check(t, f.Allocate(int64(len(dump))))
+		fill(t, fs)
+		for i := 0; i < len(dump); i += 100 {
+			if _, err := f.Write(dump[i:min(i+100, len(dump))]); err != nil {
+				t.Fatalf("write at %d: %v", i, err)
+			}
+		}
+		check(t, f.Close())
+		if data, err := fs.ReadFile("crash.dump"); err != nil || !bytes.Equal(data, dump) {
+			t.Errorf("expected the whole dump to be written; got %d bytes, %v", len(data), err)
+		}
+
+		f, err = fs.Open("crash.dump")
+		check(t, err)
+		if err := f.Allocate(1); err != ErrBadFileNum {
+			t.Errorf("expected ErrBadFileNum; got %v", err)
+		}
+		check(t, f.Close())
+	})
+
+	t.Run("Privileged", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		check(t, fs.Reserve("crash.dump", int64(len(dump))))
+		fill(t, fs)
+		f, err := fs.OpenFile("other.dump", os.O_WRONLY|os.O_CREATE)
+		check(t, err)
+		if _, err := f.Write(dump[:2*bs]); err != ErrNoSpace {
+			t.Errorf("expected ErrNoSpace; got %v", err)
+		}
+		check(t, f.SetPrivileged(true))
+		if _, err := f.Write(dump[:2*bs]); err != nil {
+			t.Errorf("expected a privileged write to succeed; got %v", err)
+		}
+		check(t, f.Close())
+
+		f, err = fs.Open("other.dump")
+		check(t, err)
+		if err := f.SetPrivileged(true); err != ErrBadFileNum {
+			t.Errorf("expected ErrBadFileNum; got %v", err)
+		}
+		check(t, f.Close())
+	})
+
+	t.Run("Traversals", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		// counting the free blocks reads the metadata of the whole
+		// filesystem, so it is only done by writes which allocate a block
+		reads := func(name string) int {
+			f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE)
+			check(t, err)
+			defer f.Close()
+			n := 0
+			fs.SetObserver(ObserverFunc(func(e Event) {
+				if e.Op == OpBlockRead {
+					n++
+				}
+			}))
+			defer fs.SetObserver(nil)
+			for i := 0; i < 10*bs; i += 16 {
+				if _, err := f.Write(make([]byte, 16)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return n
+		}
+		unchecked := reads("log.0")
+		check(t, fs.Reserve("crash.dump", int64(bs)))
+		if checked := reads("log.1"); checked > 3*unchecked {
+			t.Errorf("expected writes within a block not to be checked; %d block reads instead of %d", checked, unchecked)
+		}
+	})
+}
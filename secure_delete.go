@@ -0,0 +1,128 @@
+package lfs
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrNotErased is returned by SecureRemove when the file it removed was small
+// enough for its contents to be inlined in its directory's metadata, where
+// they stay until the metadata is compacted
+var ErrNotErased = errors.New("littlefs: removed file was inlined in its directory and could not be erased")
+
+// SetSecureDelete makes Remove, Rename, File.Truncate, File.Sync and
+// File.Close erase the blocks they free, so that the contents of a removed or
+// overwritten file do not stay on the block device until the blocks are
+// reused. The blocks freed are found by comparing the blocks reported by
+// Traverse before and after the operation, which makes these operations walk
+// the whole filesystem twice.
+//
+// Only whole blocks are erased: files small enough to be inlined in their
+// directory's metadata, which is up to CacheSize bytes, stay there until the
+// metadata is compacted, as do the bytes past the end of a file which is
+// truncated to the middle of a block. Use SecureRemove to find out when a
+// file could not be erased.
+func (l *LFS) SetSecureDelete(on bool) {
+	l.secureDelete = on
+}
+
+// SecureRemove is like Remove, but erases the blocks it frees whether or not
+// SetSecureDelete is on. If the contents of the file were inlined in its
+// directory's metadata, the file is removed but ErrNotErased is returned, as
+// they cannot be erased; secrets should be padded to more than CacheSize
+// bytes so that they are stored in blocks of their own.
+func (l *LFS) SecureRemove(name string) error {
+	return l.remove(name, true)
+}
+
+// inlined reports whether path is a regular file with contents inlined in its
+// directory's metadata
+func (l *LFS) inlined(path string) (bool, error) {
+	f, err := l.openFile(path, os.O_RDONLY, nil)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return f.typ == fileTypeReg && f.inline() && f.fileptr().ctz.size > 0, nil
+}
+
+// scrub calls fn and, if secure delete is on, erases the blocks it freed; the
+// error of fn is returned first, as blocks may have been freed even if it
+// failed
+func (l *LFS) scrub(fn func() error) error {
+	if !l.secureDelete {
+		return fn()
+	}
+	before, err := l.usedBlocks()
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if serr := l.eraseFreed(before); err == nil {
+		err = serr
+	}
+	return err
+}
+
+// scrub is like LFS.scrub, but only looks for freed blocks if f was opened for
+// writing
+func (f *File) scrub(fn func() error) error {
+	if !f.writable() {
+		return fn()
+	}
+	return f.lfs.scrub(fn)
+}
+
+// blockSet is a bitmap of blocks
+type blockSet []uint64
+
+func (s blockSet) add(block uint32) {
+	if int(block/64) < len(s) {
+		s[block/64] |= 1 << (block % 64)
+	}
+}
+
+func (s blockSet) has(block uint32) bool {
+	return int(block/64) < len(s) && s[block/64]&(1<<(block%64)) != 0
+}
+
+// usedBlocks returns the blocks currently in use by the filesystem
+func (l *LFS) usedBlocks() (blockSet, error) {
+	used := make(blockSet, (uint32(l.cfg.block_count)+63)/64)
+	err := l.Traverse(func(block uint32) error {
+		used.add(block)
+		return nil
+	})
+	return used, err
+}
+
+// eraseFreed erases the blocks which were in use in before but are not any
+// more
+func (l *LFS) eraseFreed(before blockSet) error {
+	after, err := l.usedBlocks()
+	if err != nil {
+		return err
+	}
+	erased := false
+	for block := uint32(0); block < uint32(l.cfg.block_count); block++ {
+		if !before.has(block) || after.has(block) {
+			continue
+		}
+		start := l.now()
+		err := l.eraseBlock(block)
+		l.observeBlock(OpBlockErase, block, int(l.cfg.block_size), start, err)
+		if err != nil {
+			return err
+		}
+		erased = true
+	}
+	if !erased {
+		return nil
+	}
+	// the read cache of littlefs may still hold what was erased
+	l.lfs.rcache.block = 0xffffffff
+	start := l.now()
+	err = l.sync()
+	l.observeBlock(OpBlockSync, 0, 0, start, err)
+	return err
+}
@@ -0,0 +1,99 @@
+package lfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSecureDelete(t *testing.T) {
+	secret := []byte("secret-credential/")
+	contents := bytes.Repeat(secret, 100)
+	onDevice := func(bd BlockDevice) bool {
+		return bytes.Contains(bd.(*MemBlockDevice).Bytes(), secret)
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("key", contents))
+		check(t, fs.Remove("key"))
+		if !onDevice(bd) {
+			t.Error("expected Remove to only drop references to the contents")
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("other", bytes.Repeat([]byte("other"), 200)))
+		check(t, fs.WriteFile("key", contents))
+		check(t, fs.Mkdir("dir"))
+		check(t, fs.WriteFile("dir/key", contents))
+		check(t, fs.SecureRemove("key"))
+		check(t, fs.SecureRemove("dir/key"))
+		check(t, fs.SecureRemove("dir"))
+		if onDevice(bd) {
+			t.Error("expected SecureRemove to erase the contents")
+		}
+		if data, err := fs.ReadFile("other"); err != nil || !bytes.Equal(data, bytes.Repeat([]byte("other"), 200)) {
+			t.Errorf("expected other files to be kept; got %v", err)
+		}
+		report, err := fs.Check()
+		check(t, err)
+		if !report.OK() {
+			t.Errorf("unexpected problems: %+v", report.Problems)
+		}
+	})
+
+	t.Run("Inlined", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		key := []byte("s3cr3t-t0k3n")
+		if len(key) > int(defaultConfig.CacheSize) {
+			t.Fatal("expected the key to be inlined")
+		}
+		check(t, fs.WriteFile("key", key))
+		if err := fs.SecureRemove("key"); err != ErrNotErased {
+			t.Errorf("expected ErrNotErased; got %v", err)
+		}
+		if _, err := fs.Stat("key"); err != ErrNoEntry {
+			t.Errorf("expected the file to be removed; got %v", err)
+		}
+		if !bytes.Contains(bd.(*MemBlockDevice).Bytes(), key) {
+			t.Error("expected the inlined contents to be left on the device")
+		}
+		check(t, fs.WriteFile("empty", nil))
+		check(t, fs.SecureRemove("empty"))
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		fs, bd, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		fs.SetSecureDelete(true)
+
+		check(t, fs.WriteFile("key", contents))
+		check(t, fs.WriteFile("key", []byte("rotated")))
+		if onDevice(bd) {
+			t.Error("expected overwriting a file to erase its old contents")
+		}
+
+		check(t, fs.WriteFile("key", contents))
+		f, err := fs.OpenFile("key", os.O_RDWR)
+		check(t, err)
+		check(t, f.Truncate(0))
+		check(t, f.Close())
+		if onDevice(bd) {
+			t.Error("expected truncating a file to erase its old contents")
+		}
+
+		check(t, fs.WriteFile("key", contents))
+		check(t, fs.WriteFileAtomic("key", []byte("rotated")))
+		if onDevice(bd) {
+			t.Error("expected replacing a file to erase its old contents")
+		}
+		if data, err := fs.ReadFile("key"); err != nil || string(data) != "rotated" {
+			t.Errorf("expected the new contents; got %q, %v", data, err)
+		}
+	})
+}
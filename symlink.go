@@ -0,0 +1,177 @@
+package lfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrSymlinkLoop is returned when resolving a path involves following more
+// than maxSymlinks symbolic links, which is usually caused by a loop
+var ErrSymlinkLoop = errors.New("littlefs: too many levels of symbolic links")
+
+// maxSymlinks is the number of symbolic links followed when resolving a path
+// before giving up, as on Linux
+const maxSymlinks = 40
+
+// A symbolic link is a regular file holding the path it points to, with an
+// AttrSymlink attribute of one byte which is always symlinkVersion
+const symlinkVersion = 1
+
+// Symlink creates newname as a symbolic link to oldname. The link is followed
+// by OpenFile and Stat, and by the other methods where it is not the last
+// element of a path; Lstat, Readlink, Remove, Rename and Mkdir act on the
+// link itself. Getattr, Setattr and Removeattr do not follow links at all.
+func (l *LFS) Symlink(oldname string, newname string) (err error) {
+	s := l.begin(OpSymlink, newname)
+	defer func() { s.end(0, err) }()
+	if l.readonly {
+		return ErrReadOnly
+	}
+	if oldname == "" {
+		return ErrInvalidParam
+	}
+	newname, err = l.resolve(newname, false)
+	if err != nil {
+		return err
+	}
+	if err := l.checkCreate(newname); err != nil {
+		return err
+	}
+	attr := &fileAttr{typ: AttrSymlink, buf: make([]byte, 1)}
+	f, err := l.openFile(newname, os.O_WRONLY|os.O_CREATE|os.O_EXCL, []*fileAttr{attr})
+	if err != nil {
+		return err
+	}
+	attr.buf[0] = symlinkVersion
+	if err := writeAndClose(f, []byte(oldname)); err != nil {
+		l.Remove(newname)
+		return err
+	}
+	return nil
+}
+
+// Readlink returns the path the named symbolic link points to; it fails with
+// ErrInvalidParam if the file is not a link
+func (l *LFS) Readlink(name string) (string, error) {
+	name, err := l.resolve(name, false)
+	if err != nil {
+		return "", err
+	}
+	target, ok, err := l.readlink(name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrInvalidParam
+	}
+	return target, nil
+}
+
+// Lstat is like Stat, except that if the named file is a symbolic link, it
+// describes the link rather than the file it points to
+func (l *LFS) Lstat(name string) (_ *Info, err error) {
+	s := l.begin(OpStat, name)
+	defer func() { s.end(0, err) }()
+	resolved, err := l.resolve(name, false)
+	if err != nil {
+		return nil, err
+	}
+	return l.stat(name, resolved)
+}
+
+// isSymlink reports whether the named file is a symbolic link; name must
+// already be resolved
+func (l *LFS) isSymlink(name string) (bool, error) {
+	var buf [1]byte
+	if _, err := l.Getattr(name, AttrSymlink, buf[:]); err == ErrNoAttr {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if buf[0] != symlinkVersion {
+		return false, ErrCorrupt
+	}
+	return true, nil
+}
+
+// readlink returns the target of the named file if it is a symbolic link;
+// name must already be resolved
+func (l *LFS) readlink(name string) (string, bool, error) {
+	if ok, err := l.isSymlink(name); !ok || err != nil {
+		return "", false, err
+	}
+	f, err := l.openFile(name, os.O_RDONLY, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+	target, err := readContents(f)
+	if err != nil {
+		return "", false, err
+	}
+	return string(target), true, nil
+}
+
+// followLast reports whether OpenFile follows a symbolic link in the last
+// element of a path when opening it with flags; like open(2), it does not when
+// the file has to be created
+func followLast(flags int) bool {
+	return flags&(os.O_CREATE|os.O_EXCL) != os.O_CREATE|os.O_EXCL
+}
+
+// resolve returns the path littlefs knows the named file by, once the
+// symbolic links in it are followed; a link which is the last element of the
+// path is only followed if follow is set. The file does not need to exist.
+func (l *LFS) resolve(name string, follow bool) (string, error) {
+	// littlefs fails with ErrNotDir when a link is found where it expects a
+	// directory, so the elements of a path only have to be looked at one by
+	// one when that happens, or when the path is a link to be followed
+	ok, err := l.isSymlink(name)
+	switch {
+	case err == ErrNoEntry, err == nil && !(ok && follow):
+		return name, nil
+	case err != nil && err != ErrNotDir:
+		return "", err
+	}
+
+	resolved := "/"
+	rest := strings.Split(name, "/")
+	links := 0
+	for len(rest) > 0 {
+		elem := rest[0]
+		rest = rest[1:]
+		switch elem {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+		next := path.Join(resolved, elem)
+		if len(rest) == 0 && !follow {
+			return next, nil
+		}
+		target, ok, err := l.readlink(next)
+		if err == ErrNoEntry {
+			// the rest of the path does not exist either
+			return path.Join(append([]string{next}, rest...)...), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			resolved = next
+			continue
+		}
+		if links++; links > maxSymlinks {
+			return "", ErrSymlinkLoop
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,125 @@
+package lfs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSymlink(t *testing.T) {
+	t.Run("Follow", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("dir"))
+		check(t, fs.WriteFile("dir/file", []byte("contents")))
+		check(t, fs.Symlink("file", "dir/link"))
+		check(t, fs.Symlink("/dir", "dirlink"))
+		check(t, fs.Symlink("dirlink/link", "chain"))
+
+		for _, name := range []string{"dir/link", "dirlink/file", "dirlink/link", "chain"} {
+			if data, err := fs.ReadFile(name); err != nil || string(data) != "contents" {
+				t.Errorf("%s: expected the contents of the target; got %q, %v", name, data, err)
+			}
+		}
+		info, err := fs.Stat("chain")
+		check(t, err)
+		if info.Name() != "chain" || info.Size() != 8 || info.Mode()&os.ModeSymlink != 0 {
+			t.Errorf("unexpected Stat of a link: %s, %d bytes, %v", info.Name(), info.Size(), info.Mode())
+		}
+		info, err = fs.Lstat("chain")
+		check(t, err)
+		if info.Name() != "chain" || info.Size() != int64(len("dirlink/link")) || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("unexpected Lstat of a link: %s, %d bytes, %v", info.Name(), info.Size(), info.Mode())
+		}
+		if info, err := fs.Stat("dirlink"); err != nil || !info.IsDir() {
+			t.Errorf("expected a link to a directory to be a directory; got %v", err)
+		}
+		if target, err := fs.Readlink("dirlink/link"); err != nil || target != "file" {
+			t.Errorf("expected the target of the link; got %q, %v", target, err)
+		}
+		if _, err := fs.Readlink("dir/file"); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+		if err := fs.Symlink("elsewhere", "dir/link"); err != ErrEntryExists {
+			t.Errorf("expected ErrEntryExists; got %v", err)
+		}
+
+		// writing through links changes the targets
+		check(t, fs.WriteFile("dirlink/new", []byte("new")))
+		check(t, fs.AppendFile("chain", []byte("+")))
+		if data, _ := fs.ReadFile("dir/new"); string(data) != "new" {
+			t.Errorf("expected a file created through a link; got %q", data)
+		}
+		if data, _ := fs.ReadFile("dir/file"); string(data) != "contents+" {
+			t.Errorf("expected a file appended to through a link; got %q", data)
+		}
+
+		dir, err := fs.Open("dirlink")
+		check(t, err)
+		infos, err := dir.Readdir(0)
+		check(t, err)
+		check(t, dir.Close())
+		modes := map[string]os.FileMode{}
+		for _, info := range infos {
+			modes[info.Name()] = info.Mode() & os.ModeType
+		}
+		if len(modes) != 3 || modes["file"] != 0 || modes["new"] != 0 || modes["link"] != os.ModeSymlink {
+			t.Errorf("unexpected directory listing %v", modes)
+		}
+
+		// removing a link leaves its target alone
+		check(t, fs.Remove("dirlink/link"))
+		if _, err := fs.Lstat("dir/link"); err != ErrNoEntry {
+			t.Errorf("expected the link to be removed; got %v", err)
+		}
+		if _, err := fs.Stat("dir/file"); err != nil {
+			t.Errorf("expected the target to be kept; got %v", err)
+		}
+		if _, err := fs.Stat("chain"); err != ErrNoEntry {
+			t.Errorf("expected a dangling link; got %v", err)
+		}
+	})
+
+	t.Run("Dangling", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Symlink("target", "link"))
+		if _, err := fs.Stat("link"); err != ErrNoEntry {
+			t.Errorf("expected ErrNoEntry; got %v", err)
+		}
+		if _, err := fs.Lstat("link"); err != nil {
+			t.Errorf("expected the link itself to exist; got %v", err)
+		}
+		if _, err := fs.OpenFile("link", os.O_WRONLY|os.O_CREATE|os.O_EXCL); err != ErrEntryExists {
+			t.Errorf("expected ErrEntryExists; got %v", err)
+		}
+		check(t, fs.WriteFile("link", []byte("created")))
+		if data, _ := fs.ReadFile("target"); string(data) != "created" {
+			t.Errorf("expected the target to be created; got %q", data)
+		}
+	})
+
+	t.Run("Loop", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("dir"))
+		check(t, fs.Symlink("b", "a"))
+		check(t, fs.Symlink("a", "b"))
+		check(t, fs.Symlink("../self", "self"))
+		check(t, fs.Symlink("..", "dir/up"))
+		for _, name := range []string{"a", "b", "self", "a/file"} {
+			if _, err := fs.Stat(name); err != ErrSymlinkLoop {
+				t.Errorf("%s: expected ErrSymlinkLoop; got %v", name, err)
+			}
+			if _, err := fs.Open(name); err != ErrSymlinkLoop {
+				t.Errorf("%s: expected ErrSymlinkLoop; got %v", name, err)
+			}
+		}
+		if _, err := fs.Lstat("a"); err != nil {
+			t.Errorf("expected Lstat not to follow the link; got %v", err)
+		}
+		// a link to a parent directory is not a loop by itself
+		if info, err := fs.Stat("dir/up/dir/up/dir"); err != nil || !info.IsDir() {
+			t.Errorf("expected to find the directory; got %v", err)
+		}
+	})
+}
@@ -0,0 +1,480 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrTxActive is returned by Begin while another transaction is in
+	// progress
+	ErrTxActive = errors.New("littlefs: a transaction is already in progress")
+
+	// ErrTxDone is returned by the methods of a transaction that has already
+	// been committed or rolled back
+	ErrTxDone = errors.New("littlefs: transaction has already been committed or rolled back")
+)
+
+// The changes of a transaction are staged in txDir, a directory in the root
+// of the filesystem: the contents of the i-th change, if it writes a file,
+// are in the file named i. The transaction is committed by renaming its
+// journal from txJournalTemp to txJournal; the journal lists the changes as:
+//
+//	kind    byte  'w' to write, 'd' to remove, 'm' to rename
+//	pathlen uint16
+//	path    [pathlen]byte
+//
+// followed, for a rename, by the length and the new path in the same way, and
+// at the end by the CRC-32 (IEEE) of everything before it. All integers are
+// little-endian. While the changes are made, the number of them which have
+// been made is kept in the AttrTransaction attribute of the journal.
+//
+// The root directory has an empty AttrTransaction attribute from before
+// txDir is made until after it is removed, so that a txDir which does not
+// belong to a transaction is left alone, and so that mounting a filesystem
+// on which no transaction was interrupted does not write to it.
+const (
+	txDir         = ".txn"
+	txJournal     = txDir + "/journal"
+	txJournalTemp = txDir + "/journal.tmp"
+
+	txWrite  = 'w'
+	txRemove = 'd'
+	txRename = 'm'
+)
+
+// Tx is a set of changes to regular files which become visible all at once
+// when it is committed, even if power is lost while they are being made.
+// Until then the changes are staged, and the files are seen as they were
+// before the transaction, through the Tx as well as through the LFS.
+//
+// Only one transaction can be in progress on an LFS at a time.
+type Tx struct {
+	l     *LFS
+	ops   []txOp
+	files []*File
+	done  bool
+
+	// exists records, for the paths changed by the transaction, whether they
+	// will exist once it is committed
+	exists map[string]bool
+}
+
+// txOp is a change staged by a transaction
+type txOp struct {
+	kind    byte
+	path    string
+	newPath string
+}
+
+// Begin starts a transaction. If a transaction that was committed has not
+// been completely made yet, it is finished first.
+//
+// The changes are staged in the directory .txn in the root of the
+// filesystem, which is removed once the transaction is over; Begin fails
+// with ErrEntryExists if there is a file of that name.
+func (l *LFS) Begin() (*Tx, error) {
+	if l.readonly {
+		return nil, ErrReadOnly
+	}
+	if l.tx != nil {
+		return nil, ErrTxActive
+	}
+	if err := l.recoverTx(); err != nil {
+		return nil, err
+	}
+	if _, err := l.stat(txDir, txDir); err == nil {
+		return nil, ErrEntryExists
+	} else if err != ErrNoEntry {
+		return nil, err
+	}
+	if err := l.Setattr("/", AttrTransaction, nil); err != nil {
+		return nil, err
+	}
+	if err := l.Mkdir(txDir); err != nil {
+		l.Removeattr("/", AttrTransaction)
+		return nil, err
+	}
+	l.tx = &Tx{l: l, exists: map[string]bool{}}
+	return l.tx, nil
+}
+
+// WriteFile stages replacing the contents of the named file with data,
+// creating the file if necessary
+func (tx *Tx) WriteFile(path string, data []byte) error {
+	name, err := tx.check(path)
+	if err != nil {
+		return err
+	}
+	existed, found := tx.exists[name]
+	f, err := tx.Create(path)
+	if err != nil {
+		return err
+	}
+	tx.files = tx.files[:len(tx.files)-1]
+	if err := writeAndClose(f, data); err != nil {
+		// forget the change, so that the transaction can still be committed
+		tx.ops = tx.ops[:len(tx.ops)-1]
+		if found {
+			tx.exists[name] = existed
+		} else {
+			delete(tx.exists, name)
+		}
+		tx.l.Remove(f.name)
+		return err
+	}
+	return nil
+}
+
+// Create stages replacing the contents of the named file with what is written
+// to the returned file, creating the file if necessary; an existing file
+// keeps its permissions. The returned file
+// should be closed before the transaction is committed; Commit closes it
+// otherwise.
+func (tx *Tx) Create(path string) (*File, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	name, err := tx.check(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.checkParent(name); err != nil {
+		return nil, err
+	}
+	if _, err := tx.isFile(name); err != nil {
+		return nil, err
+	}
+	f, err := tx.l.OpenFile(txStaged(len(tx.ops)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	tx.ops = append(tx.ops, txOp{kind: txWrite, path: name})
+	tx.files = append(tx.files, f)
+	tx.exists[name] = true
+	return f, nil
+}
+
+// Remove stages removing the named file
+func (tx *Tx) Remove(path string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	name, err := tx.check(path)
+	if err != nil {
+		return err
+	}
+	if ok, err := tx.isFile(name); err != nil {
+		return err
+	} else if !ok {
+		return ErrNoEntry
+	}
+	tx.ops = append(tx.ops, txOp{kind: txRemove, path: name})
+	tx.exists[name] = false
+	return nil
+}
+
+// Rename stages renaming a file, replacing the file at newPath if there is one
+func (tx *Tx) Rename(oldPath string, newPath string) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	oldName, err := tx.check(oldPath)
+	if err != nil {
+		return err
+	}
+	newName, err := tx.check(newPath)
+	if err != nil {
+		return err
+	}
+	if ok, err := tx.isFile(oldName); err != nil {
+		return err
+	} else if !ok {
+		return ErrNoEntry
+	}
+	if err := tx.checkParent(newName); err != nil {
+		return err
+	}
+	if _, err := tx.isFile(newName); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, txOp{kind: txRename, path: oldName, newPath: newName})
+	tx.exists[oldName] = false
+	tx.exists[newName] = true
+	return nil
+}
+
+// Commit makes the changes of the transaction. If it fails before the changes
+// are committed, none of them are made and the transaction is rolled back; if
+// it fails while they are being made, or power is lost, the remaining changes
+// are made by the next Mount or Begin.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	l := tx.l
+	for _, f := range tx.files {
+		if err := f.Close(); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	tx.files = nil
+	for i, op := range tx.ops {
+		if op.kind != txWrite {
+			continue
+		}
+		if err := l.keepPermissions(op.path, txStaged(i)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	journal := encodeTxJournal(tx.ops)
+	if err := l.WriteFile(txJournalTemp, journal); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// the transaction is committed once the journal has its name
+	if err := l.Rename(txJournalTemp, txJournal); err != nil {
+		tx.Rollback()
+		return err
+	}
+	tx.finish()
+	if err := l.replayTx(tx.ops, false); err != nil {
+		return err
+	}
+	return l.cleanupTx()
+}
+
+// Rollback discards the changes of the transaction
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	for _, f := range tx.files {
+		f.Close()
+	}
+	tx.files = nil
+	tx.finish()
+	return tx.l.cleanupTx()
+}
+
+// finish ends the transaction, allowing another one to begin
+func (tx *Tx) finish() {
+	tx.done = true
+	tx.l.tx = nil
+}
+
+// txStaged returns the path of the file holding the contents of the i-th
+// change of a transaction
+func txStaged(i int) string {
+	return txDir + "/" + strconv.Itoa(i)
+}
+
+// check returns the path of a file the transaction is asked to change in a
+// canonical form, so that changes to the same file can be matched
+func (tx *Tx) check(name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", ErrIsDir
+	}
+	if name == "/"+txDir || strings.HasPrefix(name, "/"+txDir+"/") {
+		return "", ErrInvalidParam
+	}
+	return name, nil
+}
+
+// isFile reports whether the named file will exist once the changes staged so
+// far are made; it fails with ErrIsDir if it is a directory
+func (tx *Tx) isFile(name string) (bool, error) {
+	if ok, found := tx.exists[name]; found {
+		return ok, nil
+	}
+	info, err := tx.l.Stat(name)
+	switch {
+	case err == ErrNoEntry:
+		return false, nil
+	case err != nil:
+		return false, err
+	case info.IsDir():
+		return false, ErrIsDir
+	}
+	return true, nil
+}
+
+// checkParent checks that the directory which is to hold the named file
+// exists
+func (tx *Tx) checkParent(name string) error {
+	dir := path.Dir(name)
+	if dir == "/" {
+		return nil
+	}
+	info, err := tx.l.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return ErrNotDir
+	}
+	return nil
+}
+
+// replayTx makes the changes of a committed transaction, starting after the
+// ones that were already made. If resumed is set, the first of the remaining
+// changes may have been made without being recorded as such before power was
+// lost, so its source not existing any more is not an error.
+func (l *LFS) replayTx(ops []txOp, resumed bool) error {
+	var start uint32
+	var buf [4]byte
+	if n, err := l.Getattr(txJournal, AttrTransaction, buf[:]); err == nil && n == len(buf) {
+		start = binary.LittleEndian.Uint32(buf[:])
+	} else if err != nil && err != ErrNoAttr {
+		return err
+	}
+	for i := int(start); i < len(ops); i++ {
+		var err error
+		switch op := ops[i]; op.kind {
+		case txWrite:
+			err = l.Rename(txStaged(i), op.path)
+		case txRemove:
+			err = l.Remove(op.path)
+		case txRename:
+			err = l.Rename(op.path, op.newPath)
+		}
+		if err == ErrNoEntry && resumed && i == int(start) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint32(buf[:], uint32(i+1))
+		if err := l.Setattr(txJournal, AttrTransaction, buf[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverTx finishes a transaction that was committed but not completely
+// made, and discards the staged changes of one that was not committed; it
+// does not write anything unless a transaction was interrupted
+func (l *LFS) recoverTx() error {
+	if _, err := l.Getattr("/", AttrTransaction, nil); err == ErrNoAttr {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// littlefs only finishes a rename between directories that was
+	// interrupted by a power loss on the next change to the filesystem, and
+	// until then may find the wrong entries in the directory the file was
+	// renamed from, such as the staging directory; removing the journal of a
+	// transaction that was not committed, which has to go anyway, is such a
+	// change
+	if err := l.Remove(txJournalTemp); err != nil && err != ErrNoEntry {
+		return err
+	}
+	journal, err := l.ReadFile(txJournal)
+	switch err {
+	case nil:
+		ops, ok := decodeTxJournal(journal)
+		if !ok {
+			return ErrCorrupt
+		}
+		if err := l.replayTx(ops, true); err != nil {
+			return err
+		}
+	case ErrNoEntry:
+	default:
+		return err
+	}
+	return l.cleanupTx()
+}
+
+// cleanupTx removes the staging directory along with everything in it, and
+// then the mark of the transaction on the root directory
+func (l *LFS) cleanupTx() error {
+	dir, err := l.Open(txDir)
+	if err == nil {
+		infos, err := dir.Readdir(0)
+		dir.Close()
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			if err := l.Remove(txDir + "/" + info.Name()); err != nil {
+				return err
+			}
+		}
+		if err := l.Remove(txDir); err != nil {
+			return err
+		}
+	} else if err != ErrNoEntry {
+		return err
+	}
+	err = l.Removeattr("/", AttrTransaction)
+	if err == ErrNoAttr {
+		err = nil
+	}
+	return err
+}
+
+func encodeTxJournal(ops []txOp) []byte {
+	var journal []byte
+	for _, op := range ops {
+		journal = append(journal, op.kind)
+		journal = binary.LittleEndian.AppendUint16(journal, uint16(len(op.path)))
+		journal = append(journal, op.path...)
+		if op.kind == txRename {
+			journal = binary.LittleEndian.AppendUint16(journal, uint16(len(op.newPath)))
+			journal = append(journal, op.newPath...)
+		}
+	}
+	return binary.LittleEndian.AppendUint32(journal, crc32.ChecksumIEEE(journal))
+}
+
+func decodeTxJournal(journal []byte) ([]txOp, bool) {
+	if len(journal) < 4 {
+		return nil, false
+	}
+	body := journal[:len(journal)-4]
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(journal[len(body):]) {
+		return nil, false
+	}
+	next := func() (string, bool) {
+		if len(body) < 2 {
+			return "", false
+		}
+		n := int(binary.LittleEndian.Uint16(body))
+		if len(body) < 2+n {
+			return "", false
+		}
+		s := string(body[2 : 2+n])
+		body = body[2+n:]
+		return s, true
+	}
+	var ops []txOp
+	for len(body) > 0 {
+		op := txOp{kind: body[0]}
+		body = body[1:]
+		var ok bool
+		if op.path, ok = next(); !ok {
+			return nil, false
+		}
+		switch op.kind {
+		case txWrite, txRemove:
+		case txRename:
+			if op.newPath, ok = next(); !ok {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		ops = append(ops, op)
+	}
+	return ops, true
+}
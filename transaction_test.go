@@ -0,0 +1,195 @@
+package lfs
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestTransaction(t *testing.T) {
+	t.Run("Commit", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("fw"))
+		check(t, fs.WriteFile("fw/manifest", []byte("v1")))
+		check(t, fs.WriteFile("fw/a", []byte("a1")))
+		check(t, fs.WriteFile("fw/old", []byte("old")))
+
+		tx, err := fs.Begin()
+		check(t, err)
+		check(t, tx.WriteFile("fw/a.new", []byte("a2")))
+		check(t, tx.Rename("/fw/a.new", "fw/a"))
+		f, err := tx.Create("fw/b")
+		check(t, err)
+		if _, err := f.Write([]byte("b2")); err != nil {
+			t.Fatal(err)
+		}
+		check(t, tx.Remove("fw/old"))
+		check(t, tx.WriteFile("fw/manifest", []byte("v2")))
+
+		// nothing is visible before the commit
+		if data, _ := fs.ReadFile("fw/manifest"); string(data) != "v1" {
+			t.Errorf("expected the old manifest before the commit; got %q", data)
+		}
+		if _, err := fs.Stat("fw/b"); err != ErrNoEntry {
+			t.Errorf("expected no new file before the commit; got %v", err)
+		}
+		if _, err := fs.Begin(); err != ErrTxActive {
+			t.Errorf("expected ErrTxActive; got %v", err)
+		}
+		check(t, tx.Commit())
+
+		for name, want := range map[string]string{"fw/manifest": "v2", "fw/a": "a2", "fw/b": "b2"} {
+			if data, err := fs.ReadFile(name); err != nil || string(data) != want {
+				t.Errorf("%s: expected %q; got %q, %v", name, want, data, err)
+			}
+		}
+		for _, name := range []string{"fw/old", "fw/a.new", txDir} {
+			if _, err := fs.Stat(name); err != ErrNoEntry {
+				t.Errorf("%s: expected ErrNoEntry; got %v", name, err)
+			}
+		}
+		if err := tx.Commit(); err != ErrTxDone {
+			t.Errorf("expected ErrTxDone; got %v", err)
+		}
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("file", []byte("old")))
+		tx, err := fs.Begin()
+		check(t, err)
+		check(t, tx.WriteFile("file", []byte("new")))
+		check(t, tx.Remove("file"))
+		if _, err := tx.Create("new"); err != nil {
+			t.Fatal(err)
+		}
+		check(t, tx.Rollback())
+		if data, err := fs.ReadFile("file"); err != nil || string(data) != "old" {
+			t.Errorf("expected the old contents; got %q, %v", data, err)
+		}
+		for _, name := range []string{"new", txDir} {
+			if _, err := fs.Stat(name); err != ErrNoEntry {
+				t.Errorf("%s: expected ErrNoEntry; got %v", name, err)
+			}
+		}
+		if err := tx.WriteFile("file", nil); err != ErrTxDone {
+			t.Errorf("expected ErrTxDone; got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("dir"))
+		check(t, fs.WriteFile("file", nil))
+		tx, err := fs.Begin()
+		check(t, err)
+		defer tx.Rollback()
+		for _, c := range []struct {
+			name string
+			err  error
+			want error
+		}{
+			{"RemoveMissing", tx.Remove("missing"), ErrNoEntry},
+			{"RemoveDir", tx.Remove("dir"), ErrIsDir},
+			{"WriteDir", tx.WriteFile("dir", nil), ErrIsDir},
+			{"WriteNoParent", tx.WriteFile("missing/file", nil), ErrNoEntry},
+			{"WriteStaging", tx.WriteFile(txJournal, nil), ErrInvalidParam},
+			{"RenameMissing", tx.Rename("missing", "other"), ErrNoEntry},
+			{"RenameOverDir", tx.Rename("file", "dir"), ErrIsDir},
+			{"RemoveRemoved", func() error {
+				check(t, tx.Remove("file"))
+				return tx.Remove("file")
+			}(), ErrNoEntry},
+		} {
+			if c.err != c.want {
+				t.Errorf("%s: expected %v; got %v", c.name, c.want, c.err)
+			}
+		}
+	})
+
+	t.Run("TxnDir", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir(txDir))
+		check(t, fs.WriteFile(txDir+"/mine", []byte("data")))
+		if _, err := fs.Begin(); err != ErrEntryExists {
+			t.Errorf("expected ErrEntryExists; got %v", err)
+		}
+		check(t, fs.Unmount())
+		check(t, fs.Mount())
+		if data, err := fs.ReadFile(txDir + "/mine"); err != nil || string(data) != "data" {
+			t.Errorf("expected a directory not made by Begin to be kept; got %q, %v", data, err)
+		}
+	})
+
+	t.Run("PowerCut", func(t *testing.T) {
+		const files = 4
+		base := NewMemoryDevice(defaultConfig)
+		fs := New(defaultConfig, base)
+		check(t, fs.Format())
+		check(t, fs.Mount())
+		for i := 0; i < files; i++ {
+			check(t, fs.WriteFile(fmt.Sprintf("file%d", i), []byte("old")))
+		}
+		check(t, fs.Unmount())
+
+		for limit := 0; ; limit++ {
+			dev := base.Clone()
+			cut := &powerCutBlockDevice{BlockDevice: dev, limit: limit, halt: true}
+			untilPowerCut(func() {
+				fs := New(defaultConfig, cut)
+				if fs.Mount() != nil {
+					return
+				}
+				tx, err := fs.Begin()
+				if err != nil {
+					return
+				}
+				for i := 1; i < files; i++ {
+					tx.WriteFile(fmt.Sprintf("file%d", i), bytes.Repeat([]byte("new"), 100))
+				}
+				tx.Remove("file0")
+				tx.Rename("file1", "file0")
+				tx.Commit()
+			})
+
+			// every file must have been changed, or none of them
+			fs := New(defaultConfig, dev)
+			if err := fs.Mount(); err != nil {
+				t.Fatalf("limit %d: %v", limit, err)
+			}
+			values := map[string]int{}
+			for i := 0; i < files; i++ {
+				data, err := fs.ReadFile(fmt.Sprintf("file%d", i))
+				switch {
+				case err == ErrNoEntry:
+					values["missing"]++
+				case err != nil:
+					t.Fatalf("limit %d: %v", limit, err)
+				case string(data) == "old":
+					values["old"]++
+				case bytes.Equal(data, bytes.Repeat([]byte("new"), 100)):
+					values["new"]++
+				default:
+					t.Fatalf("limit %d: unexpected contents %q", limit, data)
+				}
+			}
+			if values["old"] != files && (values["new"] != files-1 || values["missing"] != 1) {
+				t.Fatalf("limit %d: transaction was partly made: %v", limit, values)
+			}
+			if _, err := fs.Stat(txDir); err != ErrNoEntry {
+				t.Fatalf("limit %d: expected the staging directory to be removed; got %v", limit, err)
+			}
+			check(t, fs.Unmount())
+			if !cut.cut() {
+				if values["new"] != files-1 {
+					t.Fatal("expected the transaction to be made without a power cut")
+				}
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,368 @@
+package lfs
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A versioned file has an AttrVersioning attribute holding:
+//
+//	keep uint32  number of revisions to keep
+//	seq  uint32  sequence number of the current contents
+//	time int64   when the current contents were written, in Unix nanoseconds
+//
+// Each of its revisions is a hidden file next to it, named by revisionPath,
+// with an AttrRevision attribute holding the seq and time the file had when
+// it was given those contents. All integers are little-endian.
+const (
+	versioningSize = 16
+	revisionSize   = 12
+)
+
+// Revision describes a previous version of the contents of a versioned file
+type Revision struct {
+	Seq  uint32    // sequence number, which increases with every version
+	Time time.Time // when the file was given these contents
+	Size int64
+}
+
+// versioning is the decoded AttrVersioning attribute of a versioned file
+type versioning struct {
+	keep uint32
+	seq  uint32
+	time time.Time
+}
+
+func (v versioning) encode() []byte {
+	buf := make([]byte, versioningSize)
+	binary.LittleEndian.PutUint32(buf[0:], v.keep)
+	binary.LittleEndian.PutUint32(buf[4:], v.seq)
+	binary.LittleEndian.PutUint64(buf[8:], uint64(v.time.UnixNano()))
+	return buf
+}
+
+// SetVersions makes the named file keep its last n revisions. From then on,
+// each time it is changed through a file opened for writing by OpenFile,
+// OpenEncrypted or OpenCompressed, or replaced by WriteFileAtomic, the
+// contents it had before are saved as a revision; opening it for writing
+// without changing it saves nothing. Revisions are
+// hidden files in the same directory: revision 3 of dir/config is
+// dir/.config.~3~. If there is not enough free space for another copy of the
+// file, the oldest revisions are removed to make room.
+//
+// Setting n to 0 stops versioning the file and removes its revisions.
+func (l *LFS) SetVersions(name string, n int) error {
+	if n < 0 || uint64(n) > math.MaxUint32 {
+		return ErrInvalidParam
+	}
+	info, err := l.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return ErrIsDir
+	}
+	if n == 0 {
+		if err := l.Removeattr(name, AttrVersioning); err != nil {
+			return err
+		}
+		return l.pruneRevisions(name, 0)
+	}
+	v, err := l.versioning(name)
+	if err == ErrNoAttr {
+		v = versioning{seq: 1, time: time.Now()}
+	} else if err != nil {
+		return err
+	}
+	v.keep = uint32(n)
+	if err := l.Setattr(name, AttrVersioning, v.encode()); err != nil {
+		return err
+	}
+	return l.pruneRevisions(name, n)
+}
+
+// Revisions lists the revisions kept of the named file, oldest first. They
+// are listed even if the file has been removed since.
+func (l *LFS) Revisions(name string) ([]Revision, error) {
+	dir, err := l.Open(path.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	infos, err := dir.Readdir(0)
+	dir.Close()
+	if err != nil {
+		return nil, err
+	}
+	prefix := "." + path.Base(name) + ".~"
+	var revs []Revision
+	for _, info := range infos {
+		s := info.Name()
+		if info.IsDir() || !strings.HasPrefix(s, prefix) || !strings.HasSuffix(s, "~") {
+			continue
+		}
+		seq, err := strconv.ParseUint(s[len(prefix):len(s)-1], 10, 32)
+		if err != nil {
+			continue
+		}
+		var buf [revisionSize]byte
+		if n, err := l.Getattr(revisionPath(name, uint32(seq)), AttrRevision, buf[:]); err != nil || n != len(buf) {
+			continue
+		}
+		revs = append(revs, Revision{
+			Seq:  binary.LittleEndian.Uint32(buf[0:]),
+			Time: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[4:]))),
+			Size: info.Size(),
+		})
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].Seq < revs[j].Seq })
+	return revs, nil
+}
+
+// ReadRevision returns the contents of the named file as they were in the
+// revision seq. Revisions have the permissions the file had when they were
+// saved, which are checked as for the file, see SetCredential.
+func (l *LFS) ReadRevision(name string, seq uint32) ([]byte, error) {
+	return l.ReadFile(revisionPath(name, seq))
+}
+
+// RestoreRevision gives the named file back its contents of the revision seq,
+// replacing them atomically as WriteFileAtomic does, so the contents it had
+// until then are kept as a revision in turn. The revisions of encrypted and
+// compressed files are restored as they were written, with their headers.
+// The credential set with SetCredential has to be allowed to read the
+// revision and to write the file, which keeps its permissions.
+func (l *LFS) RestoreRevision(name string, seq uint32) error {
+	resolved, err := l.resolve(name, true)
+	if err != nil {
+		return err
+	}
+	rev := revisionPath(resolved, seq)
+	if l.cred != nil {
+		if _, err := l.checkOpen(rev, os.O_RDONLY); err != nil {
+			return err
+		}
+		if _, err := l.checkOpen(resolved, os.O_WRONLY|os.O_CREATE); err != nil {
+			return err
+		}
+	}
+	tmp := resolved + atomicSuffix
+	err = l.copyFile(rev, tmp)
+	if err == nil {
+		err = l.keepPermissions(resolved, tmp)
+	}
+	if err == nil {
+		err = l.saveRevision(resolved, tmp)
+	}
+	if err == nil {
+		err = l.Rename(tmp, resolved)
+	}
+	if err != nil {
+		l.Remove(tmp)
+	}
+	return err
+}
+
+// revisionPath returns the path of the file holding the revision seq of the
+// named file
+func revisionPath(name string, seq uint32) string {
+	dir, base := path.Split(name)
+	return dir + "." + base + ".~" + strconv.FormatUint(uint64(seq), 10) + "~"
+}
+
+// versioning reads the AttrVersioning attribute of the named file
+func (l *LFS) versioning(name string) (versioning, error) {
+	var buf [versioningSize]byte
+	n, err := l.Getattr(name, AttrVersioning, buf[:])
+	if err != nil {
+		return versioning{}, err
+	}
+	if n != len(buf) {
+		return versioning{}, ErrCorrupt
+	}
+	return versioning{
+		keep: binary.LittleEndian.Uint32(buf[0:]),
+		seq:  binary.LittleEndian.Uint32(buf[4:]),
+		time: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:]))),
+	}, nil
+}
+
+// trackRevisions makes f, which has just been opened with flags, save its
+// contents as a revision before they are first changed if it is versioned;
+// truncating it on opening changes them, so they are saved right away then
+func (f *File) trackRevisions(flags int) error {
+	if !f.writable() {
+		return nil
+	}
+	if _, err := f.lfs.versioning(f.path); err == ErrNoAttr {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	f.revise = true
+	if flags&os.O_TRUNC != 0 {
+		return f.saveRevision()
+	}
+	return nil
+}
+
+// saveRevision saves the contents f had when it was opened as a revision, if
+// that still has to be done before they change
+func (f *File) saveRevision() error {
+	if !f.revise {
+		return nil
+	}
+	f.revise = false
+	return f.lfs.saveRevision(f.path, f.path)
+}
+
+// saveRevision saves the contents of the named file as a revision if it is
+// versioned, and gives next, which is to hold its new contents, the
+// versioning attribute to go with them; next may be the file itself
+func (l *LFS) saveRevision(name string, next string) error {
+	v, err := l.versioning(name)
+	if err == ErrNoEntry || err == ErrNoAttr {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := l.storeRevision(name, v); err != nil {
+		return err
+	}
+	if err := l.pruneRevisions(name, int(v.keep)); err != nil {
+		return err
+	}
+	v.seq++
+	v.time = time.Now()
+	return l.Setattr(next, AttrVersioning, v.encode())
+}
+
+// storeRevision copies the contents of the named file to its revision v.seq,
+// removing the oldest revisions if space is low. If there is not enough space
+// even once all the others are gone, the revision is not kept.
+func (l *LFS) storeRevision(name string, v versioning) error {
+	info, err := l.Stat(name)
+	if err != nil {
+		return err
+	}
+	// leave room for the revision and the new contents, allowing a block for
+	// the skip-list pointers of each, and two more for the metadata
+	bs := int64(l.cfg.block_size)
+	need := 2*((info.Size()+bs-1)/bs+1) + 2
+	for {
+		used, err := l.Size()
+		if err != nil {
+			return err
+		}
+		if int64(l.cfg.block_count)-int64(used) >= need {
+			break
+		}
+		if ok, err := l.removeOldestRevision(name, v.seq); err != nil {
+			return err
+		} else if !ok {
+			// writing the new contents comes first
+			return nil
+		}
+	}
+	for {
+		err := l.copyRevision(name, v)
+		if err != ErrNoSpace {
+			return err
+		}
+		if ok, err := l.removeOldestRevision(name, v.seq); err != nil {
+			return err
+		} else if !ok {
+			return l.Remove(revisionPath(name, v.seq))
+		}
+	}
+}
+
+// copiedAttrs are the attributes copied along with the contents of a file:
+// the headers of encrypted and compressed files, without which their contents
+// cannot be read, and the permissions, which keep applying to revisions
+var copiedAttrs = []uint8{AttrEncryption, AttrCompression, AttrPermissions}
+
+// copyRevision copies the contents of the named file to its revision v.seq,
+// along with the attribute recording it
+func (l *LFS) copyRevision(name string, v versioning) error {
+	attr := &fileAttr{typ: AttrRevision, buf: make([]byte, revisionSize)}
+	binary.LittleEndian.PutUint32(attr.buf[0:], v.seq)
+	binary.LittleEndian.PutUint64(attr.buf[4:], uint64(v.time.UnixNano()))
+	return l.copyFile(name, revisionPath(name, v.seq), attr)
+}
+
+// copyFile copies the contents of the file src as they are stored to dst,
+// which is created or truncated, along with its copiedAttrs and with attrs
+func (l *LFS) copyFile(src string, dst string, attrs ...*fileAttr) error {
+	in, err := l.openFile(src, os.O_RDONLY, nil)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if in.IsDir() {
+		return ErrIsDir
+	}
+	for _, typ := range copiedAttrs {
+		buf := make([]byte, attrMax)
+		n, err := l.Getattr(src, typ, buf)
+		if err == ErrNoAttr {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, &fileAttr{typ: typ, buf: buf[:n]})
+	}
+	// opening dst fills the attributes in, so their values are set after
+	values := make([][]byte, len(attrs))
+	for i, attr := range attrs {
+		values[i] = append([]byte(nil), attr.buf...)
+	}
+	out, err := l.openFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, attrs)
+	if err != nil {
+		return err
+	}
+	for i, attr := range attrs {
+		copy(attr.buf, values[i])
+	}
+	if _, err := io.CopyBuffer(out, in, make([]byte, l.cfg.block_size)); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// removeOldestRevision removes the oldest revision of the named file other
+// than the one being saved, reporting whether there was one
+func (l *LFS) removeOldestRevision(name string, saving uint32) (bool, error) {
+	revs, err := l.Revisions(name)
+	if err != nil {
+		return false, err
+	}
+	if len(revs) == 0 || revs[0].Seq == saving {
+		return false, nil
+	}
+	return true, l.Remove(revisionPath(name, revs[0].Seq))
+}
+
+// pruneRevisions removes all but the last keep revisions of the named file
+func (l *LFS) pruneRevisions(name string, keep int) error {
+	revs, err := l.Revisions(name)
+	if err != nil {
+		return err
+	}
+	for len(revs) > keep {
+		if err := l.Remove(revisionPath(name, revs[0].Seq)); err != nil {
+			return err
+		}
+		revs = revs[1:]
+	}
+	return nil
+}
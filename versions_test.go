@@ -0,0 +1,150 @@
+package lfs
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestVersions(t *testing.T) {
+	t.Run("Revisions", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.Mkdir("etc"))
+		check(t, fs.WriteFile("etc/config", []byte("v1")))
+		check(t, fs.SetVersions("etc/config", 3))
+		check(t, fs.WriteFile("etc/config", []byte("v2")))
+		check(t, fs.WriteFileAtomic("etc/config", []byte("v3")))
+		check(t, fs.AppendFile("etc/config", []byte("+")))
+		f, err := fs.OpenFile("etc/config", os.O_WRONLY|os.O_TRUNC)
+		check(t, err)
+		if _, err := f.Write([]byte("v5")); err != nil {
+			t.Fatal(err)
+		}
+		check(t, f.Close())
+
+		revs, err := fs.Revisions("etc/config")
+		check(t, err)
+		if len(revs) != 3 {
+			t.Fatalf("expected 3 revisions; got %d", len(revs))
+		}
+		for i, want := range []string{"v2", "v3", "v3+"} {
+			if revs[i].Seq != uint32(i+2) {
+				t.Errorf("expected revision %d to have seq %d; got %d", i, i+2, revs[i].Seq)
+			}
+			if revs[i].Size != int64(len(want)) {
+				t.Errorf("expected revision %d to have size %d; got %d", i, len(want), revs[i].Size)
+			}
+			if i > 0 && revs[i].Time.Before(revs[i-1].Time) {
+				t.Errorf("expected revision %d to be newer than the one before", i)
+			}
+			if data, err := fs.ReadRevision("etc/config", revs[i].Seq); err != nil || string(data) != want {
+				t.Errorf("expected revision %d to hold %q; got %q, %v", i, want, data, err)
+			}
+		}
+		if _, err := fs.ReadRevision("etc/config", 1); err != ErrNoEntry {
+			t.Errorf("expected the oldest revision to be pruned; got %v", err)
+		}
+
+		check(t, fs.RestoreRevision("etc/config", 2))
+		if data, _ := fs.ReadFile("etc/config"); string(data) != "v2" {
+			t.Errorf("expected the restored contents; got %q", data)
+		}
+		revs, err = fs.Revisions("etc/config")
+		check(t, err)
+		if data, _ := fs.ReadRevision("etc/config", revs[len(revs)-1].Seq); string(data) != "v5" {
+			t.Errorf("expected the contents before the restore to be kept; got %q", data)
+		}
+
+		check(t, fs.SetVersions("etc/config", 0))
+		check(t, fs.WriteFile("etc/config", []byte("v7")))
+		if revs, err := fs.Revisions("etc/config"); err != nil || len(revs) != 0 {
+			t.Errorf("expected no revisions; got %v, %v", revs, err)
+		}
+		if err := fs.SetVersions("etc", 1); err != ErrIsDir {
+			t.Errorf("expected ErrIsDir; got %v", err)
+		}
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		check(t, fs.WriteFile("config", []byte("v1")))
+		check(t, fs.SetVersions("config", 3))
+		for _, flags := range []int{os.O_RDWR, os.O_WRONLY | os.O_APPEND} {
+			f, err := fs.OpenFile("config", flags)
+			check(t, err)
+			check(t, f.Close())
+		}
+		if _, err := fs.OpenFile("config", os.O_WRONLY|os.O_CREATE|os.O_EXCL); err != ErrEntryExists {
+			t.Errorf("expected ErrEntryExists; got %v", err)
+		}
+		if revs, err := fs.Revisions("config"); err != nil || len(revs) != 0 {
+			t.Errorf("expected no revisions; got %v, %v", revs, err)
+		}
+		check(t, fs.AppendFile("config", []byte("+")))
+		if revs, err := fs.Revisions("config"); err != nil || len(revs) != 1 || revs[0].Seq != 1 {
+			t.Errorf("expected revision 1; got %v, %v", revs, err)
+		}
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, defaultConfig)
+		defer unmount()
+		key := bytes.Repeat([]byte{1}, 32)
+		write := func(flags int, data string) {
+			t.Helper()
+			f, err := fs.OpenEncrypted("secret", flags, key)
+			check(t, err)
+			if _, err := f.Write([]byte(data)); err != nil {
+				t.Fatal(err)
+			}
+			check(t, f.Close())
+		}
+		write(os.O_WRONLY|os.O_CREATE, "v1")
+		check(t, fs.SetVersions("secret", 3))
+		write(os.O_WRONLY|os.O_TRUNC, "v2")
+		if revs, err := fs.Revisions("secret"); err != nil || len(revs) != 1 {
+			t.Fatalf("expected a revision; got %v, %v", revs, err)
+		}
+
+		check(t, fs.RestoreRevision("secret", 1))
+		f, err := fs.OpenEncrypted("secret", os.O_RDONLY, key)
+		check(t, err)
+		defer f.Close()
+		data := make([]byte, 10)
+		if n, _ := f.Read(data); string(data[:n]) != "v1" {
+			t.Errorf("expected the restored contents; got %q", data[:n])
+		}
+	})
+
+	t.Run("LowSpace", func(t *testing.T) {
+		config := defaultConfig
+		config.BlockCount = 32
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		check(t, fs.WriteFile("config", nil))
+		check(t, fs.SetVersions("config", 100))
+		size := 3 * int(config.BlockSize)
+		for i := 0; i < 20; i++ {
+			data := bytes.Repeat([]byte{byte(i)}, size)
+			if err := fs.WriteFile("config", data); err != nil {
+				t.Fatalf("write %d: %v", i, err)
+			}
+		}
+		revs, err := fs.Revisions("config")
+		check(t, err)
+		if len(revs) == 0 || len(revs) >= 20 {
+			t.Fatalf("expected some revisions to be pruned; got %d", len(revs))
+		}
+		// the most recent revisions are the ones kept
+		if last := revs[len(revs)-1]; last.Seq != 20 {
+			t.Errorf("expected the last revision to have seq 20; got %d", last.Seq)
+		}
+		data, err := fs.ReadRevision("config", 20)
+		check(t, err)
+		if !bytes.Equal(data, bytes.Repeat([]byte{18}, size)) {
+			t.Errorf("unexpected contents of revision 20: %d bytes", len(data))
+		}
+	})
+}
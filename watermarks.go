@@ -0,0 +1,144 @@
+package lfs
+
+// SpaceLevel tells how close a filesystem is to running out of space, see
+// SetWatermarks
+type SpaceLevel uint8
+
+const (
+	SpaceNormal SpaceLevel = iota
+	SpaceLow
+	SpaceCritical
+)
+
+var spaceLevelNames = [...]string{
+	SpaceNormal:   "normal",
+	SpaceLow:      "low",
+	SpaceCritical: "critical",
+}
+
+func (level SpaceLevel) String() string {
+	if int(level) < len(spaceLevelNames) {
+		return spaceLevelNames[level]
+	}
+	return "unknown"
+}
+
+// Watermarks are numbers of blocks in use, as reported by Size, at which a
+// filesystem is running out of space; a watermark of 0 is not used
+type Watermarks struct {
+	Low      uint32
+	Critical uint32
+
+	// Hysteresis is the number of blocks by which the blocks in use have to
+	// drop below the watermark of the current level for the level to go
+	// back down, so that a filesystem hovering around a watermark does not
+	// report crossing it over and over
+	Hysteresis uint32
+}
+
+// SpaceEvent reports that the number of blocks in use crossed a watermark
+type SpaceEvent struct {
+	Level    SpaceLevel
+	Previous SpaceLevel
+	Used     uint32 // blocks in use, as reported by Size
+	Total    uint32 // BlockCount
+}
+
+// SetWatermarks makes the mounted filesystem call fn whenever its SpaceLevel
+// changes as the number of blocks in use crosses one of the watermarks w. The
+// level is checked with Size after every public operation which programmed
+// or erased a block, and right away, so that fn is called if the filesystem
+// is already low on space. The level rises as soon as a watermark is reached,
+// but only goes back down once the blocks in use drop w.Hysteresis blocks
+// below it.
+//
+// fn is called synchronously, so it can prune files before the operation that
+// follows runs out of space; the levels it causes to be crossed are reported
+// once it returns. To receive the events on a channel instead, fn can send
+// them without blocking. A nil fn, the default, disables the watermarks.
+func (l *LFS) SetWatermarks(w Watermarks, fn func(e SpaceEvent)) error {
+	if w.Low > uint32(l.cfg.block_count) || w.Critical > uint32(l.cfg.block_count) {
+		return ErrInvalidParam
+	}
+	if w.Low > 0 && w.Critical > 0 && w.Critical < w.Low {
+		return ErrInvalidParam
+	}
+	l.watermarks = watermarkState{Watermarks: w, fn: fn, programmed: true}
+	l.checkWatermarks()
+	return nil
+}
+
+// watermarkState is the state of the watermarks set with SetWatermarks
+type watermarkState struct {
+	Watermarks
+	fn    func(e SpaceEvent)
+	level SpaceLevel
+
+	// programmed is set when a block is programmed or erased, and checking
+	// while fn is being called
+	programmed bool
+	checking   bool
+}
+
+// checkWatermarks calls the function set with SetWatermarks if the level
+// changed since a block was last programmed or erased
+func (l *LFS) checkWatermarks() {
+	w := &l.watermarks
+	if w.fn == nil || w.checking {
+		return
+	}
+	w.checking = true
+	defer func() { w.checking = false }()
+	for w.programmed {
+		w.programmed = false
+		used, err := l.Size()
+		if err != nil {
+			return
+		}
+		level := w.Watermarks.level(uint32(used), w.level)
+		if level == w.level {
+			continue
+		}
+		e := SpaceEvent{
+			Level:    level,
+			Previous: w.level,
+			Used:     uint32(used),
+			Total:    uint32(l.cfg.block_count),
+		}
+		w.level = level
+		w.fn(e)
+	}
+}
+
+// level returns the level once used blocks are in use, when it was current
+func (w Watermarks) level(used uint32, current SpaceLevel) SpaceLevel {
+	level := SpaceNormal
+	if w.Low > 0 && used >= w.Low {
+		level = SpaceLow
+	}
+	if w.Critical > 0 && used >= w.Critical {
+		level = SpaceCritical
+	}
+	if level >= current {
+		return level
+	}
+	for current > level {
+		if mark := w.watermark(current); mark > 0 && used+w.Hysteresis >= mark {
+			break
+		}
+		current--
+	}
+	return current
+}
+
+// watermark returns the watermark at which level is reached
+func (w Watermarks) watermark(level SpaceLevel) uint32 {
+	switch level {
+	case SpaceLow:
+		return w.Low
+	case SpaceCritical:
+		return w.Critical
+	default:
+		return 0
+	}
+}
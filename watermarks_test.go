@@ -0,0 +1,127 @@
+package lfs
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestWatermarks(t *testing.T) {
+	config := defaultConfig
+	config.BlockCount = 64
+	bs := int(config.BlockSize)
+	w := Watermarks{Low: 32, Critical: 48, Hysteresis: 8}
+
+	t.Run("Levels", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		events := make(chan SpaceEvent, 10)
+		check(t, fs.SetWatermarks(w, func(e SpaceEvent) {
+			select {
+			case events <- e:
+			default:
+			}
+		}))
+		expect := func(level SpaceLevel) {
+			t.Helper()
+			select {
+			case e := <-events:
+				if e.Level != level || e.Total != config.BlockCount {
+					t.Errorf("expected level %v; got %+v", level, e)
+				}
+				if mark := w.watermark(max(e.Level, e.Previous)); e.Level > e.Previous && e.Used < mark ||
+					e.Level < e.Previous && e.Used+w.Hysteresis >= mark {
+					t.Errorf("unexpected event %+v", e)
+				}
+			default:
+				t.Errorf("expected level %v; got no event", level)
+			}
+		}
+		used := func() uint32 {
+			n, err := fs.Size()
+			check(t, err)
+			return uint32(n)
+		}
+
+		files := 0
+		for used() < w.Critical {
+			check(t, fs.WriteFile(fmt.Sprintf("file.%d", files), make([]byte, 3*bs)))
+			files++
+		}
+		expect(SpaceLow)
+		expect(SpaceCritical)
+
+		// hovering around a watermark does not report crossing it again
+		for i := 0; i < 5; i++ {
+			check(t, fs.Remove(fmt.Sprintf("file.%d", files-1)))
+			check(t, fs.WriteFile(fmt.Sprintf("file.%d", files-1), make([]byte, 3*bs)))
+		}
+		if len(events) > 0 {
+			t.Errorf("expected no events; got %+v", <-events)
+		}
+
+		for used()+w.Hysteresis >= w.Critical {
+			files--
+			check(t, fs.Remove(fmt.Sprintf("file.%d", files)))
+		}
+		expect(SpaceLow)
+		for files > 0 {
+			files--
+			check(t, fs.Remove(fmt.Sprintf("file.%d", files)))
+		}
+		expect(SpaceNormal)
+		if len(events) > 0 {
+			t.Errorf("expected no more events; got %+v", <-events)
+		}
+
+		// reading does not check the watermarks
+		check(t, fs.WriteFile("file", []byte("contents")))
+		fs.SetObserver(ObserverFunc(func(e Event) {
+			if e.Op == OpBlockRead && e.Parent == OpNone {
+				t.Errorf("unexpected read outside of an operation")
+			}
+		}))
+		if _, err := fs.ReadFile("file"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		oldest, next := 0, 0
+		check(t, fs.SetWatermarks(w, func(e SpaceEvent) {
+			if e.Level != SpaceCritical {
+				return
+			}
+			// prune the cache back to below the low watermark
+			for {
+				used, err := fs.Size()
+				check(t, err)
+				if uint32(used) < w.Low || oldest == next {
+					return
+				}
+				check(t, fs.Remove(fmt.Sprintf("cache.%d", oldest)))
+				oldest++
+			}
+		}))
+		for ; next < 100; next++ {
+			if err := fs.WriteFile(fmt.Sprintf("cache.%d", next), make([]byte, 3*bs)); err != nil {
+				t.Fatalf("write %d: %v", next, err)
+			}
+		}
+		if oldest == 0 {
+			t.Error("expected the cache to be pruned")
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		fs, _, unmount := createTestFS(t, config)
+		defer unmount()
+		if err := fs.SetWatermarks(Watermarks{Low: 40, Critical: 30}, func(SpaceEvent) {}); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+		if err := fs.SetWatermarks(Watermarks{Critical: 65}, func(SpaceEvent) {}); err != ErrInvalidParam {
+			t.Errorf("expected ErrInvalidParam; got %v", err)
+		}
+	})
+}